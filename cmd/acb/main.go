@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"os/signal"
@@ -22,6 +23,13 @@ func main() {
 	ctx, stop := signal.NotifyContext(ctx, []os.Signal{os.Interrupt}...)
 	defer stop()
 	if err := cmd.RunCLI(ctx, os.Args[1:]); err != nil {
+		var exitErr *cmd.ExitError
+		if errors.As(err, &exitErr) {
+			if exitErr.Err != nil {
+				log.Printf("error: %v", exitErr.Err)
+			}
+			os.Exit(exitErr.Code)
+		}
 		log.Printf("error: %v", err)
 		os.Exit(1)
 	}
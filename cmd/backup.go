@@ -6,11 +6,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/takaishi/acb/internal/archive"
 	"github.com/takaishi/acb/internal/aws"
 	"github.com/takaishi/acb/internal/backup"
 	"github.com/takaishi/acb/internal/config"
 	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
 	"github.com/takaishi/acb/internal/storage"
 )
 
@@ -26,21 +29,55 @@ func Backup(cli *CLI) error {
 		cancel()
 	}()
 
+	writeOptions, err := cli.Backup.S3.writeOptions()
+	if err != nil {
+		return err
+	}
+
+	codec, err := archive.ParseCodec(cli.Backup.Compression)
+	if err != nil {
+		return err
+	}
+	if err := codec.ValidateLevel(cli.Backup.CompressionLevel); err != nil {
+		return fmt.Errorf("invalid --compression-level: %w", err)
+	}
+	if cli.Backup.UsersPerShard <= 0 {
+		return fmt.Errorf("invalid --users-per-shard: must be positive")
+	}
+
 	// Parse URI
-	info, err := parseStorageURI(cli.Backup.URI)
+	loc, err := storage.ParseURI(cli.Backup.URI)
 	if err != nil {
 		return err
 	}
 
+	storageOpts := cli.storageOptions(cli.Backup.Storage)
+	storageOpts.S3Write = writeOptions
+
+	// With "-", stdout carries the archive, so send all logging to stderr
+	logOut := logOutput(loc.IsStdio())
+
 	// Validate AWS credentials
-	if info.storageType == "s3" {
+	if loc.Scheme == "s3" {
 		if err := config.ValidateAWSCredentials(ctx); err != nil {
 			return err
 		}
 	}
 
+	if cli.Backup.Incremental {
+		if err := requireCapabilities(loc, "incremental backup", storage.Capabilities{List: true}); err != nil {
+			return err
+		}
+	}
+	if cli.Backup.Repository {
+		// Stored chunks are listed to skip uploading them again
+		if err := requireCapabilities(loc, "repository backup", storage.Capabilities{List: true}); err != nil {
+			return err
+		}
+	}
+
 	// Initialize Cognito client
-	cognitoClient, err := aws.NewCognitoClient(ctx)
+	cognitoClient, err := aws.NewCognitoClient(ctx, cli.cognitoOptions(cli.Backup.Cognito))
 	if err != nil {
 		return fmt.Errorf("failed to initialize Cognito client: %w", err)
 	}
@@ -60,65 +97,119 @@ func Backup(cli *CLI) error {
 		cfg.KMS.DataKeyPath = cli.Backup.DataKeyPath
 	}
 
-	dataKeyInfo, err := parseStorageURI(cli.Backup.DataKeyPath)
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, storageOpts)
 	if err != nil {
-		return fmt.Errorf("failed to parse data key path: %w", err)
+		return err
 	}
-	fmt.Printf("dataKeyInfo: %+v\n", dataKeyInfo)
 
-	// Initialize storage
-	var store storage.Storage
-	switch info.storageType {
-	case "s3":
-		store, err = storage.NewS3Storage(ctx, info.bucket)
+	// Configure KMS encryption
+	var encryptor encryption.Encryptor
+	if cfg.KMS.Enabled {
+		kmsEncryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Backup.KMSRegion, cli.kmsOptions(cli.Backup.KMS))
 		if err != nil {
-			return fmt.Errorf("failed to initialize S3 storage: %w", err)
+			return fmt.Errorf("failed to initialize KMS encryption: %w", err)
 		}
-	case "file":
-		store, err = storage.NewLocalStorage()
-		if err != nil {
-			return fmt.Errorf("failed to initialize local storage: %w", err)
+
+		// Use the data key file if specified, otherwise a new data key is generated for this backup
+		if cfg.KMS.DataKeyPath != "" {
+			dataKey, err := readDataKey(ctx, cfg.KMS.DataKeyPath, cli.storageOptions(cli.Backup.Storage))
+			if err != nil {
+				return fmt.Errorf("failed to read data key file: %w", err)
+			}
+			kmsEncryptor.SetDataKey(dataKey)
 		}
+
+		encryptor = kmsEncryptor
+		fmt.Fprintf(logOut, "KMS encryption enabled (KeyID: %s)\n", cfg.KMS.KeyID)
 	}
 
-	// Configure KMS encryption
-	if cfg.KMS.Enabled {
-		// Read data key file
-		dataKey, err := readDataKey(dataKeyInfo)
-		if err != nil {
-			return fmt.Errorf("failed to read data key file: %w", err)
+	// Resolve archive layout
+	keyTemplate := cli.Backup.KeyTemplate
+	if loc.IsStdio() {
+		// stdout holds a single archive containing all user pools
+		keyTemplate = "{run_id}{ext}"
+	}
+	layout, err := backup.NewLayout(keyTemplate, loc.Path, time.Now())
+	if err != nil {
+		return err
+	}
+	layout.Region = cognitoClient.Region()
+	layout.Extension = codec.Extension()
+	layout.Account, err = aws.GetAccountID(ctx, cli.cognitoOptions(cli.Backup.Cognito))
+	if err != nil {
+		if layout.NeedsAccount() {
+			return err
 		}
+		fmt.Fprintf(logOut, "Warning: failed to get AWS account ID: %v\n", err)
+	}
 
-		// Decode plaintext data key
-		// dataKey, err := base64.StdEncoding.DecodeString(string(data))
-		// if err != nil {
-		// 	return fmt.Errorf("failed to decode plaintext data key: %w", err)
-		// }
-
-		// Initialize encryption handler
-		encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Backup.KMSRegion)
+	// Execute backup
+	backupper := backup.NewPoolBackupper(cognitoClient, store, layout)
+	if encryptor != nil {
+		backupper.SetEncryptor(encryptor)
+	}
+	backupper.SetCompression(codec, cli.Backup.CompressionLevel)
+	backupper.SetUsersPerShard(cli.Backup.UsersPerShard)
+	var repo *repository.Repository
+	if cli.Backup.Repository {
+		var created bool
+		repo, created, err = repository.OpenOrInit(ctx, store, loc.Path, encryptor, cfg.KMS.KeyID)
 		if err != nil {
-			return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+			return fmt.Errorf("failed to open repository: %w", err)
 		}
-
-		encryptor.SetDataKey(dataKey)
-		store.SetEncryptor(encryptor)
-		fmt.Printf("KMS encryption enabled (KeyID: %s)\n", cfg.KMS.KeyID)
+		if created {
+			fmt.Fprintf(logOut, "Created repository at %s\n", cli.Backup.URI)
+		}
+		backupper.SetRepository(repo)
 	}
+	if cli.Backup.Incremental {
+		// The newest backup of each user pool in the catalog becomes the parent
+		manifests, err := backup.ReadCatalog(ctx, store, layout.Prefix)
+		if err != nil {
+			return fmt.Errorf("failed to read catalog: %w", err)
+		}
+		parents, err := backup.FindParents(manifests)
+		if err != nil {
+			return err
+		}
+		backupper.SetParents(parents)
+		fmt.Fprintf(logOut, "Incremental backup enabled (%d user pools with a previous backup, others are backed up in full)\n", len(parents))
+	}
+	manifest, err := backupper.BackupPools(ctx, cli.Backup.Pattern)
+	if manifest != nil && len(manifest.Pools) > 0 {
+		for _, pool := range manifest.Pools {
+			destination := pool.Archive
+			if loc.IsStdio() {
+				destination = "stdout"
+			}
+			if pool.Parent != "" {
+				fmt.Fprintf(logOut, "Backed up user pool %s incrementally (%d changed users, parent: %s): %s\n", pool.UserPoolID, pool.Users, pool.Parent, destination)
+				continue
+			}
+			fmt.Fprintf(logOut, "Backed up user pool %s (%d users): %s\n", pool.UserPoolID, pool.Users, destination)
+		}
 
-	// Execute backup
-	backupper := backup.NewPoolBackupper(cognitoClient, store)
-	if err := backupper.BackupPools(ctx, cli.Backup.Pattern, info.path); err != nil {
+		// Record the run in the catalog, even if some user pools failed.
+		// A backup streamed to stdout has no destination to keep a catalog in
+		manifest.ToolVersion = Version
+		if cfg.KMS.Enabled {
+			manifest.KMSKeyID = cfg.KMS.KeyID
+		}
+		if !loc.IsStdio() {
+			if err := layout.Record(ctx, store, manifest); err != nil {
+				return err
+			}
+		}
+	}
+	if err != nil {
 		return fmt.Errorf("backup failed: %w", err)
 	}
 
-	fmt.Println("Backup completed")
-
-	// Compress backup directory
-	if err := store.CreateTarGz(ctx, info.path); err != nil {
-		return fmt.Errorf("failed to compress backup: %w", err)
+	if repo != nil {
+		stats := repo.Stats()
+		fmt.Fprintf(logOut, "Repository: %d new chunks (%d bytes), %d chunks reused\n", stats.NewChunks, stats.NewBytes, stats.ReusedChunks)
 	}
-
-	fmt.Println("Backup compression completed")
+	fmt.Fprintf(logOut, "Backup completed (run ID: %s)\n", layout.RunID())
 	return nil
 }
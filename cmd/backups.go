@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/takaishi/acb/internal/backup"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// Backups runs the subcommands that manage backups in a destination
+func Backups(cli *CLI, command string) error {
+	switch strings.Fields(command)[1] {
+	case "list":
+		return BackupsList(cli)
+	case "copy":
+		return BackupsCopy(cli)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+func BackupsList(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	since, err := parseTimeFlag(cli.Backups.List.Since, false)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, err := parseTimeFlag(cli.Backups.List.Until, true)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	// Initialize storage
+	store, loc, err := openStorage(ctx, cli.Backups.List.URI, cli.storageOptions(cli.Backups.List.Storage))
+	if err != nil {
+		return err
+	}
+	if err := requireCapabilities(loc, "backups list", storage.Capabilities{List: true}); err != nil {
+		return err
+	}
+
+	manifests, err := backup.ReadCatalog(ctx, store, loc.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	// Filter by user pool and date range
+	var runs []types.BackupManifest
+	for _, manifest := range manifests {
+		timestamp, err := time.Parse(time.RFC3339, manifest.Timestamp)
+		if err != nil {
+			fmt.Printf("Warning: invalid timestamp in run %s: %s\n", manifest.RunID, manifest.Timestamp)
+			continue
+		}
+		if (!since.IsZero() && timestamp.Before(since)) || (!until.IsZero() && timestamp.After(until)) {
+			continue
+		}
+
+		var pools []types.ManifestPool
+		for _, pool := range manifest.Pools {
+			if cli.Backups.List.Pool == "" || pool.UserPoolID == cli.Backups.List.Pool {
+				pools = append(pools, pool)
+			}
+		}
+		if len(pools) == 0 {
+			continue
+		}
+		manifest.Pools = pools
+		runs = append(runs, manifest)
+	}
+
+	if cli.Backups.List.Format == "json" {
+		data, err := json.MarshalIndent(runs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode backups: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No backups found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tTIMESTAMP\tUSER POOL\tTYPE\tUSERS\tSIZE\tKMS KEY\tARCHIVE")
+	for _, run := range runs {
+		kmsKeyID := run.KMSKeyID
+		if kmsKeyID == "" {
+			kmsKeyID = "-"
+		}
+		for _, pool := range run.Pools {
+			backupType := "full"
+			if pool.Parent != "" {
+				backupType = "incremental"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", run.RunID, run.Timestamp, pool.UserPoolID, backupType, pool.Users, pool.Size, kmsKeyID, pool.Archive)
+		}
+	}
+	return w.Flush()
+}
+
+func BackupsCopy(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	since, err := parseTimeFlag(cli.Backups.Copy.Since, false)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, err := parseTimeFlag(cli.Backups.Copy.Until, true)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	writeOptions, err := cli.Backups.Copy.S3.writeOptions()
+	if err != nil {
+		return err
+	}
+
+	// Initialize storage
+	src, srcLoc, err := openStorage(ctx, cli.Backups.Copy.From, cli.storageOptions(cli.Backups.Copy.FromStorage))
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	dstOpts := cli.storageOptions(cli.Backups.Copy.ToStorage)
+	dstOpts.S3Write = writeOptions
+	dst, dstLoc, err := openStorage(ctx, cli.Backups.Copy.To, dstOpts)
+	if err != nil {
+		return fmt.Errorf("failed to open target: %w", err)
+	}
+	// Both catalogs are listed: the source to select backups, the target to skip backups copied before
+	for _, loc := range []*storage.Location{srcLoc, dstLoc} {
+		if err := requireCapabilities(loc, "backups copy", storage.Capabilities{List: true}); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := backup.ReadCatalog(ctx, src, srcLoc.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read source catalog: %w", err)
+	}
+	selected, err := backup.SelectCopies(manifests, backup.CopySelection{
+		UserPoolID: cli.Backups.Copy.Pool,
+		Since:      since,
+		Until:      until,
+		Latest:     cli.Backups.Copy.Latest,
+	})
+	if err != nil {
+		return err
+	}
+
+	targetManifests, err := backup.ReadCatalog(ctx, dst, dstLoc.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read target catalog: %w", err)
+	}
+	copied := make(map[string]bool) // "<run ID>/<user pool ID>"
+	for _, manifest := range targetManifests {
+		for _, pool := range manifest.Pools {
+			copied[manifest.RunID+"/"+pool.UserPoolID] = true
+		}
+	}
+
+	// Group the backups to copy by run, oldest first so that parents are copied before their incremental backups
+	pending := make(map[string]map[string]bool) // run ID -> user pool IDs
+	count := 0
+	for _, b := range selected {
+		if repository.IsSnapshotKey(b.Archive) {
+			fmt.Printf("Warning: Skipping %s/%s: repository snapshots cannot be copied\n", b.RunID, b.UserPoolID)
+			continue
+		}
+		if copied[b.RunID+"/"+b.UserPoolID] {
+			fmt.Printf("Already copied: %s/%s\n", b.RunID, b.UserPoolID)
+			continue
+		}
+		if cli.Backups.Copy.DryRun {
+			fmt.Printf("Would copy %s/%s (%s): %s\n", b.RunID, b.UserPoolID, strings.Join(b.Reasons, ", "), b.Archive)
+		}
+		if pending[b.RunID] == nil {
+			pending[b.RunID] = make(map[string]bool)
+		}
+		pending[b.RunID][b.UserPoolID] = true
+		count++
+	}
+
+	if count == 0 {
+		fmt.Println("No backups to copy")
+		return nil
+	}
+	if cli.Backups.Copy.DryRun {
+		fmt.Printf("Dry run: %d backups would be copied to %s\n", count, cli.Backups.Copy.To)
+		return nil
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so no key ID is needed to read them
+	kmsOpts := cli.kmsOptions(cli.Backups.Copy.KMS)
+	decryptor, err := encryption.NewKMSEncryptor(ctx, "", cli.Backups.Copy.FromKMSRegion, kmsOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	copier := backup.NewCopier(src, srcLoc.Path, dst, dstLoc.Path)
+	copier.SetDecryptor(decryptor)
+	if cli.Backups.Copy.KMSKeyID != "" {
+		encryptor, err := encryption.NewKMSEncryptor(ctx, cli.Backups.Copy.KMSKeyID, cli.Backups.Copy.KMSRegion, kmsOpts)
+		if err != nil {
+			return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+		}
+		copier.SetEncryptor(encryptor, cli.Backups.Copy.KMSKeyID)
+	}
+	// Archives of incremental backups are rebuilt to point at the copied parents, keeping their original key
+	keyEncryptors := make(map[string]encryption.Encryptor)
+	copier.SetKeyEncryptor(func(keyID string) (encryption.Encryptor, error) {
+		if encryptor, ok := keyEncryptors[keyID]; ok {
+			return encryptor, nil
+		}
+		encryptor, err := encryption.NewKMSEncryptor(ctx, keyID, cli.Backups.Copy.FromKMSRegion, kmsOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize KMS encryption: %w", err)
+		}
+		keyEncryptors[keyID] = encryptor
+		return encryptor, nil
+	})
+
+	for _, manifest := range manifests {
+		userPoolIDs := pending[manifest.RunID]
+		if len(userPoolIDs) == 0 {
+			continue
+		}
+
+		result, err := copier.CopyRun(ctx, manifest, userPoolIDs)
+		if err != nil {
+			return fmt.Errorf("failed to copy run %s: %w", manifest.RunID, err)
+		}
+		if len(result.Pools) == 0 {
+			continue
+		}
+		for _, pool := range result.Pools {
+			fmt.Printf("Copied %s/%s (%d bytes, sha256 %s): %s\n", manifest.RunID, pool.UserPoolID, pool.Size, pool.SHA256, pool.Archive)
+		}
+		if err := backup.RecordCopy(ctx, dst, dstLoc.Path, result); err != nil {
+			return err
+		}
+	}
+
+	if err := backup.RebuildLatest(ctx, dst, dstLoc.Path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d backups to %s\n", count, cli.Backups.Copy.To)
+	return nil
+}
+
+// parseTimeFlag parses a date (YYYY-MM-DD) or RFC3339 time.
+// A date means the start of the day, or the end of the day if endOfDay is true
+func parseTimeFlag(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339: %s", value)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
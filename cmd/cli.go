@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -11,34 +12,239 @@ import (
 var Version = "dev"
 var Revision = "HEAD"
 
+// GlobalOptions holds the endpoint settings shared by all commands
 type GlobalOptions struct {
+	S3Endpoint      string `name:"s3-endpoint" help:"Custom S3 endpoint URL for S3-compatible storage (e.g., http://localhost:9000)"`
+	S3PathStyle     bool   `name:"s3-path-style" help:"Use path-style addressing for S3 (required by most S3-compatible storage)"`
+	S3Region        string `name:"s3-region" help:"Region of the S3 bucket. Skips looking up the bucket region"`
+	CognitoEndpoint string `help:"Custom Cognito endpoint URL (e.g., http://localhost:4566)"`
+	KMSEndpoint     string `help:"Custom KMS endpoint URL (e.g., http://localhost:4566)"`
+	GCSEndpoint     string `name:"gcs-endpoint" help:"Custom GCS JSON API endpoint URL (e.g., https://storage.example.com/storage/v1/)"`
+	AzureAccount    string `help:"Azure storage account name for azblob:// URIs (default: $AZURE_STORAGE_ACCOUNT)"`
+	AzureEndpoint   string `help:"Custom Azure Blob service URL (e.g., http://127.0.0.1:10000/devstoreaccount1 for Azurite)"`
+}
+
+// AssumeRoleFlags holds the role to assume for a set of AWS clients
+type AssumeRoleFlags struct {
+	RoleARN     string `help:"IAM role ARN to assume"`
+	ExternalID  string `help:"External ID to use when assuming the role"`
+	SessionName string `help:"Session name to use when assuming the role" default:"acb"`
+}
+
+// RestoreFlags holds the options shared by commands that restore users into a pool
+type RestoreFlags struct {
+	OnConflict  string `help:"Behavior when a group, client or user already exists in the target (skip|overwrite|fail)" default:"skip" enum:"skip,overwrite,fail"`
+	UserPattern string `help:"Regular expression pattern to filter users to restore by username" default:".*"`
+	SkipGroups  bool   `help:"Do not restore groups"`
+	SkipClients bool   `help:"Do not restore app clients"`
+	SkipUsers   bool   `help:"Do not restore users"`
+
+	SubMapping         string `help:"Destination URI for the old-to-new sub mapping file (e.g., file:///path/to/sub-mapping.json)"`
+	LegacySubAttribute string `help:"Custom attribute to store the original sub in (e.g., custom:legacy_sub)"`
+}
+
+// S3WriteFlags holds the options applied to every object written to S3
+type S3WriteFlags struct {
+	SSEKMSKeyID         string            `name:"sse-kms-key-id" help:"KMS key ID to encrypt objects with SSE-KMS (default: bucket default encryption)"`
+	BucketKey           bool              `help:"Use an S3 Bucket Key for SSE-KMS"`
+	StorageClass        string            `help:"Storage class of objects (e.g., STANDARD_IA, GLACIER_IR)"`
+	ObjectLockMode      string            `help:"Object Lock retention mode (GOVERNANCE|COMPLIANCE)"`
+	ObjectLockRetention string            `help:"Object Lock retention period from the time of writing (e.g., 365d, 52w)"`
+	Tag                 map[string]string `help:"Object tag in key=value form. Can be repeated"`
+	ChecksumAlgorithm   string            `help:"Checksum algorithm for uploads (CRC32|CRC32C|SHA1|SHA256|CRC64NVME)"`
 }
 
 type CLI struct {
+	GlobalOptions `embed:""`
+
 	Backup struct {
-		Pattern     string `help:"Regular expression pattern to filter user pools" default:".*"`
-		URI         string `help:"Backup destination URI (e.g., s3://bucket/prefix/file.tar.gz or file:///path/to/backup.tar.gz)" required:""`
-		KMSRegion   string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
-		KMSKeyID    string `help:"KMS key ID (e.g., alias/my-key or arn:aws:kms:region:account:key/key-id)" and:"KMSKeyID,DataKeyPath"`
-		DataKeyPath string `help:"Data key file path (e.g., file:///path/to/datakey.json)" and:"KMSKeyID,DataKeyPath"`
+		Pattern          string `help:"Regular expression pattern to filter user pools" default:".*"`
+		URI              string `help:"Backup destination URI (e.g., s3://bucket/prefix, gs://bucket/prefix, azblob://container/prefix or file:///path/to/backups)" required:""`
+		KeyTemplate      string `help:"Template for archive keys under the destination. Placeholders: {prefix}, {date}, {time}, {run_id}, {account}, {region}, {pool_id}, {ext}" default:"{prefix}/{date}/{time}/{pool_id}{ext}"`
+		Compression      string `help:"Compression of archives (gzip|zstd|xz|none)" default:"gzip" enum:"gzip,zstd,xz,none"`
+		CompressionLevel int    `help:"Compression level (gzip: 1-9, zstd: 1-22). If not specified, the default level of the compression is used"`
+		KMSRegion        string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+		KMSKeyID         string `help:"KMS key ID (e.g., alias/my-key or arn:aws:kms:region:account:key/key-id)"`
+		DataKeyPath      string `help:"Data key file path (e.g., file:///path/to/datakey.json). If not specified, a new data key is generated for each backup"`
+		UsersPerShard    int    `help:"Maximum number of users in each users/NNNNN.jsonl file of a backup" default:"10000"`
+		Incremental      bool   `help:"Save only users modified since the previous backup of each user pool, plus the list of all users. Requires a destination that supports listing"`
+		Repository       bool   `help:"Save into a deduplicated repository at the destination instead of archives: files are split into content-addressed chunks stored once and referenced from a snapshot per run. --key-template and --compression are not used"`
+
+		S3 S3WriteFlags `embed:"" prefix:"s3-"`
+
+		Cognito AssumeRoleFlags `embed:"" prefix:"cognito-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
 	} `cmd:"" help:"Backup Cognito user pools"`
 
 	List struct {
 		Pattern string `help:"Regular expression pattern to filter user pools" default:".*"`
+
+		Cognito AssumeRoleFlags `embed:"" prefix:"cognito-"`
 	} `cmd:"" help:"List Cognito user pools"`
 
 	Restore struct {
 		Pattern   string `help:"Regular expression pattern to filter backup files" default:".*"`
-		URI       string `help:"Backup source URI (e.g., s3://bucket/prefix/file.tar.gz or file:///path/to/backup.tar.gz)" required:""`
+		URI       string `help:"Backup source URI: an archive, a latest.json pointer, a backup destination containing latest.json, or - to read an archive from stdin (e.g., s3://bucket/prefix or file:///path/to/backups/2025-01-01/120000/pool.tar.gz)" required:""`
 		KMSRegion string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		RestoreFlags `embed:""`
+
+		Cognito AssumeRoleFlags `embed:"" prefix:"cognito-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
 	} `cmd:"" help:"Restore Cognito user pools from backup"`
 
+	Copy struct {
+		SourcePool     string `help:"Source user pool ID" required:""`
+		TargetRegion   string `help:"Region of the target user pool (default: region of the source user pool)"`
+		TargetPoolID   string `help:"Existing user pool to copy into (default: create a new user pool)"`
+		TargetPoolName string `help:"Name of the user pool to create (default: name of the source user pool)"`
+
+		RestoreFlags `embed:""`
+
+		Source  AssumeRoleFlags `embed:"" prefix:"source-"`
+		Target  AssumeRoleFlags `embed:"" prefix:"target-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+	} `cmd:"" help:"Copy a Cognito user pool directly into another region or account"`
+
+	Sync struct {
+		SourcePool   string        `help:"Source user pool ID" required:""`
+		TargetPool   string        `help:"Target (standby) user pool ID" required:""`
+		TargetRegion string        `help:"Region of the target user pool (default: region in the target user pool ID)"`
+		State        string        `help:"URI of the state file that keeps the sync cursor (e.g., s3://bucket/sync/state.json or file:///path/to/state.json)" required:""`
+		Interval     time.Duration `help:"Interval between syncs (e.g., 5m). If not specified, sync once and exit"`
+		Full         bool          `help:"Apply all users on the first sync regardless of the cursor, e.g., to pick up group membership changes"`
+
+		Source  AssumeRoleFlags `embed:"" prefix:"source-"`
+		Target  AssumeRoleFlags `embed:"" prefix:"target-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+	} `cmd:"" help:"Continuously replicate users to a standby user pool"`
+
+	Backups struct {
+		List struct {
+			URI    string `help:"Backup destination URI (e.g., s3://bucket/prefix, gs://bucket/prefix, azblob://container/prefix or file:///path/to/backups)" required:""`
+			Pool   string `help:"Show only backups of this user pool ID"`
+			Since  string `help:"Show only backups taken at or after this time (YYYY-MM-DD or RFC3339)"`
+			Until  string `help:"Show only backups taken at or before this time (YYYY-MM-DD or RFC3339)"`
+			Format string `help:"Output format (table|json)" default:"table" enum:"table,json"`
+
+			Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		} `cmd:"" help:"List backups recorded in the catalog"`
+
+		Copy struct {
+			From          string `help:"Backup destination URI to copy from (e.g., s3://bucket/prefix, gs://bucket/prefix, azblob://container/prefix or file:///path/to/backups)" required:""`
+			To            string `help:"Backup destination URI to copy to (e.g., s3://other-bucket/prefix or file:///mnt/nas/backups)" required:""`
+			Pool          string `help:"Copy only backups of this user pool ID"`
+			Since         string `help:"Copy only backups taken at or after this time (YYYY-MM-DD or RFC3339)"`
+			Until         string `help:"Copy only backups taken at or before this time (YYYY-MM-DD or RFC3339)"`
+			Latest        bool   `help:"Copy only the latest backup of each user pool"`
+			DryRun        bool   `help:"Show which backups would be copied without copying them"`
+			FromKMSRegion string `name:"from-kms-region" help:"KMS region of the keys that encrypted the source backups" default:"ap-northeast-1"`
+			KMSKeyID      string `help:"Re-encrypt the copies under this KMS key ID (e.g., alias/my-key or arn:aws:kms:region:account:key/key-id). If not specified, archives are copied as they are"`
+			KMSRegion     string `help:"KMS region of --kms-key-id" default:"ap-northeast-1"`
+
+			S3 S3WriteFlags `embed:"" prefix:"s3-"`
+
+			FromStorage AssumeRoleFlags `embed:"" prefix:"from-storage-"`
+			ToStorage   AssumeRoleFlags `embed:"" prefix:"to-storage-"`
+			KMS         AssumeRoleFlags `embed:"" prefix:"kms-"`
+		} `cmd:"" help:"Copy backups to another destination, verifying checksums and optionally re-encrypting them"`
+	} `cmd:"" help:"Manage backups"`
+
+	Prune struct {
+		URI         string `help:"Backup destination URI (e.g., s3://bucket/prefix, gs://bucket/prefix, azblob://container/prefix or file:///path/to/backups)" required:""`
+		KeepDaily   int    `help:"Number of most recent days to keep one backup per user pool for"`
+		KeepWeekly  int    `help:"Number of most recent weeks to keep one backup per user pool for"`
+		KeepMonthly int    `help:"Number of most recent months to keep one backup per user pool for"`
+		KeepWithin  string `help:"Keep all backups taken within this duration (e.g., 30d, 2w, 72h)"`
+		DryRun      bool   `help:"Show which backups would be deleted without deleting them"`
+
+		S3 S3WriteFlags `embed:"" prefix:"s3-"`
+
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+	} `cmd:"" help:"Delete old backups according to a retention policy"`
+
+	Repo struct {
+		GC struct {
+			URI    string `help:"Repository URI (e.g., s3://bucket/prefix or file:///path/to/backups)" required:""`
+			DryRun bool   `help:"Show how many chunks would be deleted without deleting them"`
+
+			Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		} `cmd:"gc" help:"Delete chunks that are not referenced by any snapshot"`
+	} `cmd:"" help:"Manage deduplicated backup repositories"`
+
+	Verify struct {
+		Pattern   string `help:"Regular expression pattern to filter user pools" default:".*"`
+		URI       string `help:"Backup URI to verify: an archive, a latest.json pointer, a backup destination containing latest.json, or - to read an archive from stdin (e.g., s3://bucket/prefix or file:///path/to/backups/2025-01-01/120000/pool.tar.gz)" required:""`
+		KMSRegion string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
+	} `cmd:"" help:"Verify the integrity of a backup"`
+
+	Diff struct {
+		A         string `arg:"" help:"Backup URI (an archive, a latest.json pointer or a backup destination) or live:<pool-id> to compare from"`
+		B         string `arg:"" help:"Backup URI (an archive, a latest.json pointer or a backup destination) or live:<pool-id> to compare to"`
+		Pattern   string `help:"Regular expression pattern to filter user pools in backups" default:".*"`
+		Format    string `help:"Output format (text|json)" default:"text" enum:"text,json"`
+		MaxUsers  int    `help:"Maximum number of added, removed and changed users listed per user pool in text output (0: no limit)" default:"20"`
+		KMSRegion string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		Cognito AssumeRoleFlags `embed:"" prefix:"cognito-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
+	} `cmd:"" help:"Compare two backups, or a backup and a live user pool. Exits with 0 if they are the same, 1 if they differ and 2 on errors"`
+
+	Drift struct {
+		Pattern   string `help:"Regular expression pattern to filter user pools" default:".*"`
+		URI       string `help:"Backup destination URI containing latest.json, a latest.json pointer or an archive to compare with (e.g., s3://bucket/prefix or file:///path/to/backups)" required:""`
+		Format    string `help:"Output format (text|json)" default:"text" enum:"text,json"`
+		KMSRegion string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		Cognito AssumeRoleFlags `embed:"" prefix:"cognito-"`
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
+	} `cmd:"" help:"Detect changes to the settings, app clients and identity providers of user pools since their latest backup. Exits with 0 if there is no drift, 1 if drift is found and 2 on errors"`
+
+	Show struct {
+		Pattern   string `help:"Regular expression pattern to filter user pools" default:".*"`
+		URI       string `help:"Backup URI to show: an archive, a latest.json pointer, a backup destination containing latest.json, or - to read an archive from stdin (e.g., s3://bucket/prefix or file:///path/to/backups/2025-01-01/120000/pool.tar.gz)" required:""`
+		User      string `help:"Print the record of this user as JSON instead of the summary"`
+		Config    bool   `help:"Print the configuration of each user pool as JSON instead of the summary"`
+		Format    string `help:"Output format of the summary (table|json)" default:"table" enum:"table,json"`
+		KMSRegion string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
+	} `cmd:"" help:"Show the contents of a backup without restoring it"`
+
+	Export struct {
+		Pattern        string   `help:"Regular expression pattern to filter user pools" default:".*"`
+		URI            string   `help:"Backup URI to export users from: an archive, a latest.json pointer, a backup destination containing latest.json, or - to read an archive from stdin (e.g., s3://bucket/prefix or file:///path/to/backups/2025-01-01/120000/pool.tar.gz)" required:""`
+		Output         string   `help:"Destination URI of the export, or - to write to stdout (e.g., file:///path/to/users.csv)" default:"-"`
+		Format         string   `help:"Output format (csv|jsonl|cognito-import-csv)" default:"csv" enum:"csv,jsonl,cognito-import-csv"`
+		Attributes     []string `help:"Attributes to export, in column order (e.g., email,name,custom:tenant). If not specified, all attributes in the user pool schema (all attributes of each user for jsonl) are exported"`
+		GroupSeparator string   `help:"Separator of the groups of a user in the groups column of csv output" default:";"`
+		KMSRegion      string   `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
+
+		S3 S3WriteFlags `embed:"" prefix:"s3-"`
+
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
+	} `cmd:"" help:"Export the users in a backup as CSV, JSONL or a CSV for Cognito user import jobs"`
+
 	Decrypt struct {
-		Input       string `help:"Path to encrypted backup file" required:""`
-		Output      string `help:"Path to output decrypted backup file" required:""`
+		Input       string `help:"Path to encrypted backup file, or - to read from stdin" required:""`
+		Output      string `help:"Path to output decrypted backup file, or - to write to stdout" required:""`
 		KMSRegion   string `help:"KMS region (e.g., ap-northeast-1)" default:"ap-northeast-1"`
-		KMSKeyID    string `help:"KMS key ID (e.g., alias/my-key or arn:aws:kms:region:account:key/key-id)" required:""`
-		DataKeyPath string `help:"Data key file path (e.g., file:///path/to/datakey.json)" required:""`
+		KMSKeyID    string `help:"KMS key ID (e.g., alias/my-key or arn:aws:kms:region:account:key/key-id)"`
+		DataKeyPath string `help:"Data key file path (e.g., file:///path/to/datakey.json). Only needed for backups created by older versions"`
+
+		S3 S3WriteFlags `embed:"" prefix:"s3-"`
+
+		Storage AssumeRoleFlags `embed:"" prefix:"storage-"`
+		KMS     AssumeRoleFlags `embed:"" prefix:"kms-"`
 	} `cmd:"" help:"Decrypt encrypted backup file"`
 
 	GenerateDatakey struct {
@@ -48,6 +254,8 @@ type CLI struct {
 		Format    string `help:"Output format (json|base64)" default:"json" enum:"json,base64"`
 		Spec      string `help:"Data key specification (AES_256|AES_128)" default:"AES_256" enum:"AES_256,AES_128"`
 		Test      bool   `help:"Generate test data key without KMS call"`
+
+		KMS AssumeRoleFlags `embed:"" prefix:"kms-"`
 	} `cmd:"generate-datakey" help:"Generate KMS data key for encryption"`
 	Version VersionFlag `name:"version" help:"show version"`
 }
@@ -88,6 +296,26 @@ func RunCLI(ctx context.Context, args []string) error {
 		return List(&cli)
 	case "restore":
 		return Restore(&cli)
+	case "copy":
+		return Copy(&cli)
+	case "sync":
+		return Sync(&cli)
+	case "backups":
+		return Backups(&cli, kctx.Command())
+	case "prune":
+		return Prune(&cli)
+	case "repo":
+		return Repo(&cli, kctx.Command())
+	case "verify":
+		return Verify(&cli)
+	case "diff":
+		return Diff(&cli)
+	case "drift":
+		return Drift(&cli)
+	case "show":
+		return Show(&cli)
+	case "export":
+		return Export(&cli)
 	case "decrypt":
 		return Decrypt(&cli)
 	case "generate-datakey":
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/restore"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+func Copy(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Initialize Cognito clients
+	sourceOpts := cli.cognitoOptions(cli.Copy.Source)
+	sourceOpts.Region = aws.RegionFromUserPoolID(cli.Copy.SourcePool)
+	sourceClient, err := aws.NewCognitoClient(ctx, sourceOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize source Cognito client: %w", err)
+	}
+
+	targetOpts := cli.cognitoOptions(cli.Copy.Target)
+	targetOpts.Region = cli.Copy.TargetRegion
+	if targetOpts.Region == "" {
+		targetOpts.Region = sourceOpts.Region
+	}
+	targetClient, err := aws.NewCognitoClient(ctx, targetOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize target Cognito client: %w", err)
+	}
+
+	// Initialize restorer
+	opts, err := cli.Copy.RestoreFlags.restoreOptions()
+	if err != nil {
+		return err
+	}
+	opts.PoolName = cli.Copy.TargetPoolName
+	restorer := restore.NewRestorer(targetClient, opts)
+
+	fmt.Printf("Starting copy of user pool %s to %s...\n", cli.Copy.SourcePool, targetOpts.Region)
+
+	// Copy user pool, groups, clients and users
+	result, err := restorer.Restore(ctx, restore.NewLiveSource(sourceClient, cli.Copy.SourcePool), cli.Copy.TargetPoolID)
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+
+	// Save sub mapping
+	if cli.Copy.SubMapping != "" {
+		subMappings := types.SubMappings{Mappings: result.SubMappings}
+		if err := writeSubMapping(ctx, cli.Copy.SubMapping, cli.storageOptions(cli.Copy.Storage), &subMappings); err != nil {
+			return err
+		}
+		fmt.Printf("Sub mapping saved to %s\n", cli.Copy.SubMapping)
+	}
+
+	fmt.Printf("Copy completed: %s -> %s\n", cli.Copy.SourcePool, result.UserPoolID)
+	return nil
+}
@@ -1,14 +1,19 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/takaishi/acb/internal/archive"
 	"github.com/takaishi/acb/internal/config"
 	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
 	"github.com/takaishi/acb/internal/storage"
 )
 
@@ -24,89 +29,89 @@ func Decrypt(cli *CLI) error {
 		cancel()
 	}()
 
+	writeOptions, err := cli.Decrypt.S3.writeOptions()
+	if err != nil {
+		return err
+	}
+
 	// Validate AWS credentials
 	if err := config.ValidateAWSCredentials(ctx); err != nil {
 		return err
 	}
 
-	inputInfo, err := parseStorageURI(cli.Decrypt.Input)
-	if err != nil {
-		return fmt.Errorf("failed to parse input path: %w", err)
-	}
-	var inputStore storage.Storage
-	switch inputInfo.storageType {
-	case "s3":
-		inputStore, err = storage.NewS3Storage(ctx, inputInfo.bucket)
-		if err != nil {
-			return fmt.Errorf("failed to initialize S3 storage: %w", err)
-		}
-	case "file":
-		inputStore, err = storage.NewLocalStorage()
-		if err != nil {
-			return fmt.Errorf("failed to initialize local storage: %w", err)
-		}
-	}
+	storageOpts := cli.storageOptions(cli.Decrypt.Storage)
+	storageOpts.S3Write = writeOptions
 
-	outputInfo, err := parseStorageURI(cli.Decrypt.Output)
+	inputStore, inputLoc, err := openStorage(ctx, cli.Decrypt.Input, storageOpts)
 	if err != nil {
-		return fmt.Errorf("failed to parse output path: %w", err)
+		return fmt.Errorf("failed to open input path: %w", err)
 	}
-	var outputStore storage.Storage
-	switch outputInfo.storageType {
-	case "s3":
-		outputStore, err = storage.NewS3Storage(ctx, outputInfo.bucket)
-		if err != nil {
-			return fmt.Errorf("failed to initialize S3 storage: %w", err)
-		}
-	case "file":
-		outputStore, err = storage.NewLocalStorage()
-		if err != nil {
-			return fmt.Errorf("failed to initialize local storage: %w", err)
-		}
+	if repository.IsSnapshotKey(inputLoc.Path) {
+		return fmt.Errorf("%s is a repository snapshot, which is not an archive; use restore instead", cli.Decrypt.Input)
 	}
 
-	dataKeyInfo, err := parseStorageURI(cli.Decrypt.DataKeyPath)
+	outputLoc, err := storage.ParseURI(cli.Decrypt.Output)
 	if err != nil {
-		return fmt.Errorf("failed to parse data key path: %w", err)
+		return fmt.Errorf("failed to parse output path: %w", err)
 	}
-	fmt.Printf("dataKeyInfo: %+v\n", dataKeyInfo)
 
-	// Read data key file
-	dataKey, err := readDataKey(dataKeyInfo)
+	// With "-", stdout carries the decrypted archive, so send all logging to stderr
+	logOut := logOutput(outputLoc.IsStdio())
+	outputStore, err := storage.Open(ctx, outputLoc, storageOpts)
 	if err != nil {
-		return fmt.Errorf("failed to read data key file: %w", err)
+		return fmt.Errorf("failed to open output path: %w", err)
 	}
 
 	// Initialize KMSEncryptor
-	encryptor, err := encryption.NewKMSEncryptor(ctx, cli.Decrypt.KMSKeyID, cli.Decrypt.KMSRegion)
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cli.Decrypt.KMSKeyID, cli.Decrypt.KMSRegion, cli.kmsOptions(cli.Decrypt.KMS))
 	if err != nil {
 		return fmt.Errorf("failed to initialize KMSEncryptor: %w", err)
 	}
-	encryptor.SetDataKey(dataKey)
 
-	// Read encrypted file
-	encryptedData, err := inputStore.ReadFile(ctx, inputInfo.path)
+	// Backups created by older versions need the data key file to decrypt
+	if cli.Decrypt.DataKeyPath != "" {
+		dataKey, err := readDataKey(ctx, cli.Decrypt.DataKeyPath, cli.storageOptions(cli.Decrypt.Storage))
+		if err != nil {
+			return fmt.Errorf("failed to read data key file: %w", err)
+		}
+		encryptor.SetDataKey(dataKey)
+	}
+
+	// Open encrypted file
+	r, err := inputStore.Open(ctx, inputLoc.Path)
 	if err != nil {
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
+	defer r.Close()
 
-	// Deserialize encrypted data
-	encryptedDataObj, err := encryption.DeserializeEncryptedData(encryptedData)
+	decrypted, err := archive.Decrypt(ctx, r, encryptor)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize encrypted data: %w", err)
+		return fmt.Errorf("failed to decrypt file: %w", err)
+	}
+
+	// Detect the compression of the decrypted archive from its magic bytes
+	br := bufio.NewReader(decrypted)
+	header, _ := br.Peek(512)
+	if codec, ok := archive.DetectCodec(header); ok {
+		fmt.Fprintf(logOut, "Archive compression: %s\n", codec)
+		if !outputLoc.IsStdio() && !strings.HasSuffix(outputLoc.Path, codec.Extension()) {
+			fmt.Fprintf(logOut, "Warning: the output file name does not end with %s\n", codec.Extension())
+		}
 	}
 
-	// Decrypt
-	decryptedData, err := encryptor.Decrypt(ctx, encryptedDataObj)
+	// Stream decrypted data to the output
+	w, err := outputStore.Create(ctx, outputLoc.Path)
 	if err != nil {
+		return fmt.Errorf("failed to save decrypted file: %w", err)
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		w.Abort()
 		return fmt.Errorf("failed to decrypt file: %w", err)
 	}
-
-	// Save decrypted data
-	if err := outputStore.WriteFile(ctx, outputInfo.path, decryptedData); err != nil {
+	if err := w.Close(); err != nil {
 		return fmt.Errorf("failed to save decrypted file: %w", err)
 	}
 
-	fmt.Printf("Decryption completed: %s\n", cli.Decrypt.Output)
+	fmt.Fprintf(logOut, "Decryption completed: %s\n", cli.Decrypt.Output)
 	return nil
 }
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/diff"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/restore"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// livePrefix marks a diff operand that refers to a live user pool instead of a backup
+const livePrefix = "live:"
+
+// diffReport is the JSON output of diff
+type diffReport struct {
+	Differences bool           `json:"differences"`
+	Pools       []*diff.Result `json:"pools"`
+	OnlyInA     []string       `json:"only_in_a"`
+	OnlyInB     []string       `json:"only_in_b"`
+}
+
+// Diff compares two backups, or a backup and a live user pool.
+// Like diff(1), it exits with 0 if they are the same, 1 if they differ and 2 on errors
+func Diff(cli *CLI) error {
+	differences, err := runDiff(cli)
+	if err != nil {
+		return &ExitError{Code: 2, Err: err}
+	}
+	if differences {
+		return &ExitError{Code: 1}
+	}
+	return nil
+}
+
+func runDiff(cli *CLI) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// stdout carries the output, so send all logging to stderr
+	out, logOut := os.Stdout, os.Stderr
+
+	pattern, err := regexp.Compile(cli.Diff.Pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	// Extract backups to a temporary directory so that they are read without holding them in memory
+	backupDir, err := os.MkdirTemp("", "acb-diff-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	sourcesA, err := loadDiffSources(ctx, logOut, cli, cli.Diff.A, filepath.Join(backupDir, "a"), pattern.MatchString)
+	if err != nil {
+		return false, fmt.Errorf("failed to load %s: %w", cli.Diff.A, err)
+	}
+	sourcesB, err := loadDiffSources(ctx, logOut, cli, cli.Diff.B, filepath.Join(backupDir, "b"), pattern.MatchString)
+	if err != nil {
+		return false, fmt.Errorf("failed to load %s: %w", cli.Diff.B, err)
+	}
+
+	// Pair user pools by ID. A single user pool on each side is compared even if the IDs differ,
+	// such as a backup and the user pool it was restored into
+	report := &diffReport{}
+	type pair struct{ a, b restore.Source }
+	var pairs []pair
+	if len(sourcesA) == 1 && len(sourcesB) == 1 {
+		pairs = append(pairs, pair{sourcesA[0], sourcesB[0]})
+	} else {
+		byID := make(map[string]restore.Source, len(sourcesB))
+		for _, source := range sourcesB {
+			byID[source.UserPoolID()] = source
+		}
+		for _, a := range sourcesA {
+			b, ok := byID[a.UserPoolID()]
+			if !ok {
+				report.OnlyInA = append(report.OnlyInA, a.UserPoolID())
+				continue
+			}
+			delete(byID, a.UserPoolID())
+			pairs = append(pairs, pair{a, b})
+		}
+		for _, b := range sourcesB {
+			if _, ok := byID[b.UserPoolID()]; ok {
+				report.OnlyInB = append(report.OnlyInB, b.UserPoolID())
+			}
+		}
+	}
+
+	report.Differences = len(report.OnlyInA) > 0 || len(report.OnlyInB) > 0
+	for _, p := range pairs {
+		fmt.Fprintf(logOut, "Comparing %s with %s...\n", p.a.UserPoolID(), p.b.UserPoolID())
+		result, err := diff.Pools(ctx, p.a, p.b)
+		if err != nil {
+			return false, fmt.Errorf("failed to compare %s with %s: %w", p.a.UserPoolID(), p.b.UserPoolID(), err)
+		}
+		report.Pools = append(report.Pools, result)
+		report.Differences = report.Differences || result.HasChanges()
+	}
+
+	if cli.Diff.Format == "json" {
+		return report.Differences, writeJSON(out, report)
+	}
+	printDiff(out, report, cli.Diff.A, cli.Diff.B, cli.Diff.MaxUsers)
+	return report.Differences, nil
+}
+
+// loadDiffSources returns the user pools of a diff operand: a live user pool, or the user pools in a backup matching match
+func loadDiffSources(ctx context.Context, logOut io.Writer, cli *CLI, operand, dir string, match func(userPoolID string) bool) ([]restore.Source, error) {
+	if userPoolID, ok := strings.CutPrefix(operand, livePrefix); ok {
+		opts := cli.cognitoOptions(cli.Diff.Cognito)
+		opts.Region = aws.RegionFromUserPoolID(userPoolID)
+		cognitoClient, err := aws.NewCognitoClient(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Cognito client: %w", err)
+		}
+		return []restore.Source{restore.NewLiveSource(cognitoClient, userPoolID)}, nil
+	}
+
+	loc, err := storage.ParseURI(operand)
+	if err != nil {
+		return nil, err
+	}
+	if loc.IsStdio() {
+		return nil, fmt.Errorf("backups cannot be read from stdin")
+	}
+
+	// Validate AWS credentials
+	if loc.Scheme == "s3" {
+		if err := config.ValidateAWSCredentials(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, cli.storageOptions(cli.Diff.Storage))
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Diff.KMSRegion, cli.kmsOptions(cli.Diff.KMS))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	pools, err := loadBackups(ctx, logOut, store, loc.Path, encryptor, dir, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no backups found matching the specified pattern")
+	}
+	sources := make([]restore.Source, 0, len(pools))
+	for _, pool := range pools {
+		sources = append(sources, pool.Source)
+	}
+	return sources, nil
+}
+
+// printDiff prints a readable summary of the differences.
+// At most maxUsers added, removed and changed users are listed per user pool, or all of them if maxUsers is 0
+func printDiff(out io.Writer, report *diffReport, a, b string, maxUsers int) {
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", a, b)
+	for _, userPoolID := range report.OnlyInA {
+		fmt.Fprintf(out, "Only in %s: %s\n", a, userPoolID)
+	}
+	for _, userPoolID := range report.OnlyInB {
+		fmt.Fprintf(out, "Only in %s: %s\n", b, userPoolID)
+	}
+
+	for _, result := range report.Pools {
+		fmt.Fprintln(out)
+		if result.A == result.B {
+			fmt.Fprintf(out, "User pool %s\n", result.A)
+		} else {
+			fmt.Fprintf(out, "User pool %s -> %s\n", result.A, result.B)
+		}
+		if !result.HasChanges() {
+			fmt.Fprintln(out, "  No differences")
+			continue
+		}
+
+		fmt.Fprintf(out, "  Settings: %d changed\n", len(result.Config))
+		printChanges(out, "    ", result.Config)
+		printItems(out, "Groups", result.Groups, 0)
+		printItems(out, "App clients", result.Clients, 0)
+		printItems(out, "Identity providers", result.IdentityProviders, 0)
+		printItems(out, "Users", result.Users, maxUsers)
+	}
+
+	fmt.Fprintln(out)
+	if report.Differences {
+		fmt.Fprintln(out, "Differences found")
+	} else {
+		fmt.Fprintln(out, "No differences found")
+	}
+}
+
+// printItems prints the added, removed and changed items, at most limit of each if limit is positive
+func printItems(out io.Writer, title string, items diff.Items, limit int) {
+	fmt.Fprintf(out, "  %s: %d added, %d removed, %d changed, %d unchanged\n", title, len(items.Added), len(items.Removed), len(items.Changed), items.Unchanged)
+	for i, name := range items.Added {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(out, "    ... and %d more added\n", len(items.Added)-limit)
+			break
+		}
+		fmt.Fprintf(out, "    + %s\n", name)
+	}
+	for i, name := range items.Removed {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(out, "    ... and %d more removed\n", len(items.Removed)-limit)
+			break
+		}
+		fmt.Fprintf(out, "    - %s\n", name)
+	}
+	for i, item := range items.Changed {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(out, "    ... and %d more changed\n", len(items.Changed)-limit)
+			break
+		}
+		fmt.Fprintf(out, "    ~ %s\n", item.Name)
+		printChanges(out, "        ", item.Changes)
+	}
+}
+
+// printChanges prints each changed field as "field: old -> new"
+func printChanges(out io.Writer, indent string, changes []diff.Change) {
+	for _, change := range changes {
+		fmt.Fprintf(out, "%s%s: %s -> %s\n", indent, change.Field, formatValue(change.Old), formatValue(change.New))
+	}
+}
+
+// formatValue formats a field value as compact JSON, or "(none)" if the field is not set
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/diff"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/restore"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// driftReport is the JSON output of drift
+type driftReport struct {
+	Drift       bool        `json:"drift"`
+	Pools       []driftPool `json:"pools"`
+	NotBackedUp []string    `json:"not_backed_up"`
+}
+
+// driftPool is the drift of a live user pool from its most recent backup
+type driftPool struct {
+	UserPoolID        string        `json:"user_pool_id"`
+	Name              string        `json:"name"`
+	Backup            string        `json:"backup"`
+	BackupTimestamp   string        `json:"backup_timestamp"`
+	Drift             bool          `json:"drift"`
+	Config            []diff.Change `json:"config"`
+	Clients           diff.Items    `json:"clients"`
+	IdentityProviders diff.Items    `json:"identity_providers"`
+}
+
+// Drift compares the settings, app clients and identity providers of live user pools with their most recent backups.
+// It exits with 0 if nothing changed, 1 if drift is found and 2 on errors
+func Drift(cli *CLI) error {
+	drift, err := runDrift(cli)
+	if err != nil {
+		return &ExitError{Code: 2, Err: err}
+	}
+	if drift {
+		return &ExitError{Code: 1}
+	}
+	return nil
+}
+
+func runDrift(cli *CLI) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// stdout carries the output, so send all logging to stderr
+	out, logOut := os.Stdout, os.Stderr
+
+	// Parse URI
+	loc, err := storage.ParseURI(cli.Drift.URI)
+	if err != nil {
+		return false, err
+	}
+	if loc.IsStdio() {
+		return false, fmt.Errorf("backups cannot be read from stdin")
+	}
+
+	// Validate AWS credentials
+	if err := config.ValidateAWSCredentials(ctx); err != nil {
+		return false, err
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return false, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Initialize Cognito client
+	cognitoClient, err := aws.NewCognitoClient(ctx, cli.cognitoOptions(cli.Drift.Cognito))
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize Cognito client: %w", err)
+	}
+
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, cli.storageOptions(cli.Drift.Storage))
+	if err != nil {
+		return false, err
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Drift.KMSRegion, cli.kmsOptions(cli.Drift.KMS))
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	pools, err := cognitoClient.ListUserPools(ctx, cli.Drift.Pattern)
+	if err != nil {
+		return false, err
+	}
+	if len(pools) == 0 {
+		return false, fmt.Errorf("no user pools found matching pattern: %s", cli.Drift.Pattern)
+	}
+	names := make(map[string]string, len(pools))
+	for _, pool := range pools {
+		names[*pool.Id] = *pool.Name
+	}
+
+	// Extract the settings of the backups to a temporary directory; users and parent backups are not needed
+	backupDir, err := os.MkdirTemp("", "acb-drift-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	backups, err := loadBackupSettings(ctx, logOut, store, loc.Path, encryptor, backupDir, func(userPoolID string) bool {
+		_, ok := names[userPoolID]
+		return ok
+	})
+	if err != nil {
+		return false, err
+	}
+	backedUp := make(map[string]backupPool, len(backups))
+	for _, backup := range backups {
+		backedUp[backup.Metadata.UserPoolID] = backup
+	}
+
+	report := &driftReport{}
+	for _, pool := range pools {
+		userPoolID := *pool.Id
+		backup, ok := backedUp[userPoolID]
+		if !ok {
+			fmt.Fprintf(logOut, "Warning: No backup found for user pool %s (%s)\n", userPoolID, *pool.Name)
+			report.NotBackedUp = append(report.NotBackedUp, userPoolID)
+			continue
+		}
+
+		fmt.Fprintf(logOut, "Checking user pool %s against %s...\n", userPoolID, backup.Archive)
+		result, err := diff.Settings(ctx, backup.Source, restore.NewLiveSource(cognitoClient, userPoolID))
+		if err != nil {
+			return false, fmt.Errorf("failed to compare user pool %s with its backup: %w", userPoolID, err)
+		}
+		report.Pools = append(report.Pools, driftPool{
+			UserPoolID:        userPoolID,
+			Name:              *pool.Name,
+			Backup:            backup.Archive,
+			BackupTimestamp:   backup.Metadata.Timestamp,
+			Drift:             result.HasChanges(),
+			Config:            result.Config,
+			Clients:           result.Clients,
+			IdentityProviders: result.IdentityProviders,
+		})
+		report.Drift = report.Drift || result.HasChanges()
+	}
+
+	if cli.Drift.Format == "json" {
+		return report.Drift, writeJSON(out, report)
+	}
+	printDrift(out, report)
+	return report.Drift, nil
+}
+
+// printDrift prints the changed settings of each user pool field by field
+func printDrift(out io.Writer, report *driftReport) {
+	drifted := 0
+	for i, pool := range report.Pools {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "User pool %s (%s), backed up at %s\n", pool.UserPoolID, pool.Name, pool.BackupTimestamp)
+		if !pool.Drift {
+			fmt.Fprintln(out, "  No drift")
+			continue
+		}
+		drifted++
+
+		fmt.Fprintf(out, "  Settings: %d changed\n", len(pool.Config))
+		printChanges(out, "    ", pool.Config)
+		printItems(out, "App clients", pool.Clients, 0)
+		printItems(out, "Identity providers", pool.IdentityProviders, 0)
+	}
+	if len(report.NotBackedUp) > 0 {
+		fmt.Fprintln(out)
+		for _, userPoolID := range report.NotBackedUp {
+			fmt.Fprintf(out, "Not backed up: %s\n", userPoolID)
+		}
+	}
+
+	fmt.Fprintln(out)
+	if report.Drift {
+		fmt.Fprintf(out, "Drift detected in %d of %d user pools\n", drifted, len(report.Pools))
+	} else {
+		fmt.Fprintln(out, "No drift detected")
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/export"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+func Export(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	format := export.Format(cli.Export.Format)
+	if format == export.CognitoImportCSV && len(cli.Export.Attributes) > 0 {
+		return fmt.Errorf("--attributes cannot be used with cognito-import-csv, whose columns are determined by the user pool schema")
+	}
+
+	writeOptions, err := cli.Export.S3.writeOptions()
+	if err != nil {
+		return err
+	}
+
+	// Parse URIs
+	loc, err := storage.ParseURI(cli.Export.URI)
+	if err != nil {
+		return err
+	}
+	outputLoc, err := storage.ParseURI(cli.Export.Output)
+	if err != nil {
+		return fmt.Errorf("failed to parse output path: %w", err)
+	}
+
+	// Validate AWS credentials
+	if loc.Scheme == "s3" || outputLoc.Scheme == "s3" {
+		if err := config.ValidateAWSCredentials(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	pattern, err := regexp.Compile(cli.Export.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	storageOpts := cli.storageOptions(cli.Export.Storage)
+	storageOpts.S3Write = writeOptions
+
+	// With "-", stdout carries the export, so send all logging to stderr
+	logOut := logOutput(outputLoc.IsStdio())
+
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, storageOpts)
+	if err != nil {
+		return err
+	}
+	outputStore, err := storage.Open(ctx, outputLoc, storageOpts)
+	if err != nil {
+		return fmt.Errorf("failed to open output path: %w", err)
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Export.KMSRegion, cli.kmsOptions(cli.Export.KMS))
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	// Extract backup to a temporary directory so that users are streamed without holding them in memory
+	backupDir, err := os.MkdirTemp("", "acb-export-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	pools, err := loadBackups(ctx, logOut, store, loc.Path, encryptor, backupDir, pattern.MatchString)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return fmt.Errorf("no backups found matching the specified pattern")
+	}
+	if format == export.CognitoImportCSV && len(pools) > 1 {
+		return fmt.Errorf("cognito-import-csv exports a single user pool, but %d user pools match; narrow them down with --pattern", len(pools))
+	}
+
+	// Columns follow the schema of the user pools unless attributes are specified
+	columns := cli.Export.Attributes
+	if len(columns) == 0 && format != export.JSONL {
+		var schemas [][]cognitotypes.SchemaAttributeType
+		for _, pool := range pools {
+			poolConfig, err := pool.Source.PoolConfig(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read backup of %s: %w", pool.Metadata.UserPoolID, err)
+			}
+			schemas = append(schemas, poolConfig.SchemaAttributes)
+		}
+		if format == export.CognitoImportCSV {
+			columns = export.ImportHeader(schemas[0])
+		} else {
+			columns = export.Attributes(schemas...)
+		}
+	}
+
+	w, err := outputStore.Create(ctx, outputLoc.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	exported, skipped, err := exportUsers(ctx, logOut, w, format, columns, cli.Export.GroupSeparator, pools)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to save export: %w", err)
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(logOut, "Warning: Skipped %d users signed in through external identity providers, which cannot be imported\n", skipped)
+	}
+	fmt.Fprintf(logOut, "Exported %d users from %d user pools to %s\n", exported, len(pools), cli.Export.Output)
+	return nil
+}
+
+// exportUsers writes the users of each user pool to w and returns the number of exported and skipped users
+func exportUsers(ctx context.Context, logOut io.Writer, w storage.Writer, format export.Format, columns []string, groupSeparator string, pools []backupPool) (int, int, error) {
+	writer, err := export.NewWriter(w, format, columns, groupSeparator)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	exported, skipped := 0, 0
+	for _, pool := range pools {
+		fmt.Fprintf(logOut, "Exporting users of %s...\n", pool.Metadata.UserPoolID)
+		err := pool.Source.Users(ctx, func(user *types.UserInfo) error {
+			if format == export.CognitoImportCSV && !export.Importable(user) {
+				skipped++
+				return nil
+			}
+			exported++
+			return writer.Write(pool.Metadata.UserPoolID, user)
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to export users of %s: %w", pool.Metadata.UserPoolID, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("failed to write export: %w", err)
+	}
+	return exported, skipped, nil
+}
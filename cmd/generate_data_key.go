@@ -36,7 +36,7 @@ func GenerateDatakey(cli *CLI) error {
 	}
 
 	// Initialize KMSEncryptor
-	encryptor, err := encryption.NewKMSEncryptor(ctx, cli.GenerateDatakey.KMSKeyID, cli.GenerateDatakey.KMSRegion)
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cli.GenerateDatakey.KMSKeyID, cli.GenerateDatakey.KMSRegion, cli.kmsOptions(cli.GenerateDatakey.KMS))
 	if err != nil {
 		return fmt.Errorf("failed to initialize KMSEncryptor: %w", err)
 	}
@@ -29,7 +29,7 @@ func List(cli *CLI) error {
 	}
 
 	// Initialize Cognito client
-	cognitoClient, err := aws.NewCognitoClient(ctx)
+	cognitoClient, err := aws.NewCognitoClient(ctx, cli.cognitoOptions(cli.List.Cognito))
 	if err != nil {
 		return fmt.Errorf("failed to initialize Cognito client: %w", err)
 	}
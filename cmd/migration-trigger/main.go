@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/takaishi/acb/cmd"
+)
+
+func main() {
+	if err := cmd.RunMigrationTrigger(context.Background()); err != nil {
+		log.Printf("error: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/migration"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// RunMigrationTrigger starts the Lambda handler for the user migration trigger
+func RunMigrationTrigger(ctx context.Context) error {
+	// Load configuration
+	cfg, err := config.LoadMigrationConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Initialize storage
+	storageOpts := storage.Options{}
+	store, loc, err := openStorage(ctx, cfg.BackupURI, storageOpts)
+	if err != nil {
+		return err
+	}
+
+	// Configure KMS decryption
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cfg.KMS.Region, aws.ClientOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+	if cfg.KMS.DataKeyPath != "" {
+		dataKey, err := readDataKey(ctx, cfg.KMS.DataKeyPath, storageOpts)
+		if err != nil {
+			return fmt.Errorf("failed to read data key file: %w", err)
+		}
+		encryptor.SetDataKey(dataKey)
+	}
+
+	// Load users from backup
+	archives, err := backupArchives(ctx, store, loc.Path, func(userPoolID string) bool {
+		return userPoolID == cfg.SourceUserPoolID
+	})
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no backup of user pool %s found in %s", cfg.SourceUserPoolID, cfg.BackupURI)
+	}
+	users, err := loadBackupUsers(ctx, store, archives[0], encryptor, cfg.SourceUserPoolID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d users of user pool %s from backup\n", len(users), cfg.SourceUserPoolID)
+
+	// Initialize Cognito clients
+	sourceClient, err := aws.NewCognitoClient(ctx, aws.ClientOptions{
+		Region:     aws.RegionFromUserPoolID(cfg.SourceUserPoolID),
+		RoleARN:    cfg.SourceRoleARN,
+		ExternalID: cfg.SourceExternalID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cognito client: %w", err)
+	}
+	targetClient, err := aws.NewCognitoClient(ctx, aws.ClientOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cognito client: %w", err)
+	}
+
+	handler := migration.NewHandler(sourceClient, targetClient, cfg.SourceUserPoolID, cfg.SourceClientID, users)
+	if cfg.SourceClientSecret != "" {
+		handler.SetClientSecret(cfg.SourceClientSecret)
+	}
+	handler.SetLegacySubAttribute(cfg.LegacySubAttribute)
+
+	lambda.StartWithOptions(handler.Handle, lambda.WithContext(ctx))
+	return nil
+}
+
+// loadBackupUsers reads the users of the user pool from the backup archive at key.
+// Incremental backups are combined with their parents up to the full backup
+func loadBackupUsers(ctx context.Context, store storage.Storage, key string, encryptor encryption.Encryptor, userPoolID string) ([]types.UserInfo, error) {
+	backupDir, err := os.MkdirTemp("", "acb-migration-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	dir := filepath.Join(backupDir, "backup")
+	if err := extractBackup(ctx, store, key, encryptor, dir); err != nil {
+		return nil, err
+	}
+
+	metadata, err := readBackupMetadata(dir, userPoolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	source, err := backupSource(ctx, os.Stdout, store, encryptor, dir, filepath.Join(backupDir, "parents"), metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []types.UserInfo
+	err = source.Users(ctx, func(user *types.UserInfo) error {
+		users = append(users, *user)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	return users, nil
+}
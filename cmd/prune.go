@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/takaishi/acb/internal/backup"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+func Prune(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	within, err := backup.ParseRetention(cli.Prune.KeepWithin)
+	if err != nil {
+		return fmt.Errorf("invalid --keep-within: %w", err)
+	}
+	policy := backup.RetentionPolicy{
+		Daily:   cli.Prune.KeepDaily,
+		Weekly:  cli.Prune.KeepWeekly,
+		Monthly: cli.Prune.KeepMonthly,
+		Within:  within,
+	}
+	if policy.IsZero() {
+		return fmt.Errorf("at least one of --keep-daily, --keep-weekly, --keep-monthly or --keep-within is required")
+	}
+
+	writeOptions, err := cli.Prune.S3.writeOptions()
+	if err != nil {
+		return err
+	}
+
+	storageOpts := cli.storageOptions(cli.Prune.Storage)
+	storageOpts.S3Write = writeOptions
+
+	// Initialize storage
+	store, loc, err := openStorage(ctx, cli.Prune.URI, storageOpts)
+	if err != nil {
+		return err
+	}
+	if err := requireCapabilities(loc, "prune", storage.Capabilities{List: true, Delete: true}); err != nil {
+		return err
+	}
+
+	manifests, err := backup.ReadCatalog(ctx, store, loc.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	plan, err := backup.PlanPrune(manifests, policy, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, b := range plan.Keep {
+		fmt.Printf("keep    %s  %s  %s (%s)\n", b.UserPoolID, b.RunID, b.Archive, strings.Join(b.Reasons, ", "))
+	}
+	for _, b := range plan.Delete {
+		fmt.Printf("delete  %s  %s  %s\n", b.UserPoolID, b.RunID, b.Archive)
+	}
+
+	if cli.Prune.DryRun {
+		fmt.Printf("Dry run: %d backups would be deleted, %d kept\n", len(plan.Delete), len(plan.Keep))
+		return nil
+	}
+
+	if len(plan.Delete) == 0 {
+		fmt.Println("Nothing to prune")
+		return nil
+	}
+
+	if err := backup.Prune(ctx, store, loc.Path, manifests, plan); err != nil {
+		return fmt.Errorf("prune failed: %w", err)
+	}
+
+	fmt.Printf("Pruned %d backups, %d kept\n", len(plan.Delete), len(plan.Keep))
+	for _, b := range plan.Delete {
+		if repository.IsSnapshotKey(b.Archive) {
+			fmt.Printf("Run \"acb repo gc --uri=%s\" to delete chunks no longer referenced by any snapshot\n", cli.Prune.URI)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// Repo runs the subcommands that manage deduplicated backup repositories
+func Repo(cli *CLI, command string) error {
+	switch strings.Fields(command)[1] {
+	case "gc":
+		return RepoGC(cli)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+func RepoGC(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Initialize storage
+	store, loc, err := openStorage(ctx, cli.Repo.GC.URI, cli.storageOptions(cli.Repo.GC.Storage))
+	if err != nil {
+		return err
+	}
+	if err := requireCapabilities(loc, "repo gc", storage.Capabilities{List: true, Delete: true}); err != nil {
+		return err
+	}
+
+	result, err := repository.GC(ctx, store, loc.Path, cli.Repo.GC.DryRun)
+	if result != nil {
+		fmt.Printf("%d snapshots reference %d of %d chunks\n", result.Snapshots, result.Referenced, result.Chunks)
+		for _, id := range result.Missing {
+			fmt.Printf("Warning: chunk %s is referenced but missing; run acb verify on the repository\n", id)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("gc failed: %w", err)
+	}
+
+	if cli.Repo.GC.DryRun {
+		fmt.Printf("Dry run: %d unreferenced chunks would be deleted\n", len(result.Unused))
+		return nil
+	}
+	fmt.Printf("Deleted %d unreferenced chunks\n", len(result.Unused))
+	return nil
+}
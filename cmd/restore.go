@@ -2,16 +2,18 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
-	"path"
+	"regexp"
 	"syscall"
 
+	"github.com/takaishi/acb/internal/archive"
 	"github.com/takaishi/acb/internal/aws"
 	"github.com/takaishi/acb/internal/config"
 	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
 	"github.com/takaishi/acb/internal/restore"
 	"github.com/takaishi/acb/internal/storage"
 	"github.com/takaishi/acb/pkg/types"
@@ -30,20 +32,20 @@ func Restore(cli *CLI) error {
 	}()
 
 	// Parse URI
-	info, err := parseStorageURI(cli.Restore.URI)
+	loc, err := storage.ParseURI(cli.Restore.URI)
 	if err != nil {
 		return err
 	}
 
 	// Validate AWS credentials
-	if info.storageType == "s3" {
+	if loc.Scheme == "s3" {
 		if err := config.ValidateAWSCredentials(ctx); err != nil {
 			return err
 		}
 	}
 
 	// Initialize Cognito client
-	cognitoClient, err := aws.NewCognitoClient(ctx)
+	cognitoClient, err := aws.NewCognitoClient(ctx, cli.cognitoOptions(cli.Restore.Cognito))
 	if err != nil {
 		return fmt.Errorf("failed to initialize Cognito client: %w", err)
 	}
@@ -54,46 +56,57 @@ func Restore(cli *CLI) error {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Initialize storage
-	var store storage.Storage
-	var backups []string
+	pattern, err := regexp.Compile(cli.Restore.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
 
-	switch info.storageType {
-	case "s3":
-		// Initialize S3 client
-		s3Client, err := aws.NewS3Client(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to initialize S3 client: %w", err)
-		}
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, cli.storageOptions(cli.Restore.Storage))
+	if err != nil {
+		return err
+	}
 
-		// Get backup list
-		backups, err = s3Client.ListBackups(ctx, info.bucket, info.path, cli.Restore.Pattern)
-		if err != nil {
-			return fmt.Errorf("failed to list backups: %w", err)
-		}
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Restore.KMSRegion, cli.kmsOptions(cli.Restore.KMS))
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
 
-		store, err = storage.NewS3Storage(ctx, info.bucket)
-		if err != nil {
-			return fmt.Errorf("failed to initialize S3 storage: %w", err)
-		}
+	// Extract backup to a temporary directory so that it is read without holding it in memory
+	backupDir, err := os.MkdirTemp("", "acb-restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+	parentDir, err := os.MkdirTemp("", "acb-restore-parents-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(parentDir)
 
-	case "file":
-		store, err = storage.NewLocalStorage()
-		if err != nil {
-			return fmt.Errorf("failed to initialize local storage: %w", err)
+	archives, err := backupArchives(ctx, store, loc.Path, pattern.MatchString)
+	if err != nil {
+		return err
+	}
+	for _, key := range archives {
+		fmt.Printf("Extracting backup %s...\n", key)
+		if err := extractBackup(ctx, store, key, encryptor, backupDir); err != nil {
+			return err
 		}
-		// For local storage, specify a single backup file
-		backups = []string{info.path}
 	}
 
-	// Configure KMS encryption
-	if cfg.KMS.Enabled {
-		encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Restore.KMSRegion)
-		if err != nil {
-			return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	// Find backed up user pools
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		if entry.IsDir() && pattern.MatchString(entry.Name()) {
+			backups = append(backups, entry.Name())
 		}
-		store.SetEncryptor(encryptor)
-		fmt.Printf("KMS encryption enabled (KeyID: %s)\n", cfg.KMS.KeyID)
 	}
 
 	if len(backups) == 0 {
@@ -102,43 +115,99 @@ func Restore(cli *CLI) error {
 
 	fmt.Printf("Backups to restore: %d\n", len(backups))
 
-	// Initialize pool restorer
-	poolRestorer := restore.NewPool(cognitoClient, store)
-	userRestorer := restore.NewUsers(cognitoClient, store)
+	// Initialize restorer
+	opts, err := cli.Restore.RestoreFlags.restoreOptions()
+	if err != nil {
+		return err
+	}
+	restorer := restore.NewRestorer(cognitoClient, opts)
+
+	var subMappings types.SubMappings
 
 	// Restore each backup
 	for _, backupPath := range backups {
 		// Read metadata
-		metadataPath := path.Join(backupPath, "metadata.json")
-		metadataData, err := store.ReadFile(ctx, metadataPath)
+		metadata, err := readBackupMetadata(backupDir, backupPath)
 		if err != nil {
 			fmt.Printf("Warning: Failed to read metadata (%s): %v\n", backupPath, err)
 			continue
 		}
 
-		var metadata types.BackupMetadata
-		if err := json.Unmarshal(metadataData, &metadata); err != nil {
-			fmt.Printf("Warning: Failed to parse metadata (%s): %v\n", backupPath, err)
-			continue
-		}
-
 		fmt.Printf("Starting restoration of user pool %s...\n", metadata.UserPoolID)
 
-		// Restore user pool
-		if err := poolRestorer.RestorePool(ctx, &metadata); err != nil {
-			fmt.Printf("Warning: Failed to restore user pool (%s): %v\n", metadata.UserPoolID, err)
+		// Incremental backups are combined with their parents up to the full backup.
+		// stdin holds a single archive, so parents cannot be loaded from it
+		if loc.IsStdio() && metadata.IsIncremental() {
+			fmt.Printf("Warning: Cannot restore incremental backup from stdin (%s): parent %s is required\n", metadata.UserPoolID, metadata.Parent)
+			continue
+		}
+		source, err := backupSource(ctx, os.Stdout, store, encryptor, backupDir, parentDir, metadata)
+		if err != nil {
+			fmt.Printf("Warning: Failed to load backup chain (%s): %v\n", metadata.UserPoolID, err)
 			continue
 		}
 
-		// Restore user information
-		if err := userRestorer.RestoreUsers(ctx, &metadata); err != nil {
-			fmt.Printf("Warning: Failed to restore user information (%s): %v\n", metadata.UserPoolID, err)
+		// Restore user pool, groups, clients and users
+		result, err := restorer.Restore(ctx, source, "")
+		if result != nil {
+			subMappings.Mappings = append(subMappings.Mappings, result.SubMappings...)
+		}
+		if err != nil {
+			if errors.Is(err, restore.ErrConflict) {
+				return err
+			}
+			fmt.Printf("Warning: Failed to restore user pool (%s): %v\n", metadata.UserPoolID, err)
 			continue
 		}
 
 		fmt.Printf("Restoration of user pool %s completed\n", metadata.UserPoolID)
 	}
 
+	// Save sub mapping
+	if cli.Restore.SubMapping != "" {
+		if err := writeSubMapping(ctx, cli.Restore.SubMapping, cli.storageOptions(cli.Restore.Storage), &subMappings); err != nil {
+			return err
+		}
+		fmt.Printf("Sub mapping saved to %s\n", cli.Restore.SubMapping)
+	}
+
 	fmt.Println("Restoration completed")
 	return nil
 }
+
+// extractBackup extracts the backup archive or repository snapshot at key into dir
+func extractBackup(ctx context.Context, store storage.Storage, key string, encryptor encryption.Encryptor, dir string) error {
+	return extractBackupFiles(ctx, store, key, encryptor, dir, nil)
+}
+
+// extractBackupFiles extracts the files of the backup at key for which match returns true into dir.
+// match receives "<user-pool-id>/<file>" names; a nil match extracts every file
+func extractBackupFiles(ctx context.Context, store storage.Storage, key string, encryptor encryption.Encryptor, dir string, match func(name string) bool) error {
+	if repository.IsSnapshotKey(key) {
+		repo, err := repository.Open(ctx, store, repository.PrefixOf(key), encryptor)
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+		if err := repo.ExtractFiles(ctx, key, dir, match); err != nil {
+			return fmt.Errorf("failed to extract backup: %w", err)
+		}
+		return nil
+	}
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer r.Close()
+
+	ar, err := archive.NewReader(ctx, r, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer ar.Close()
+
+	if err := ar.ExtractFiles(dir, match); err != nil {
+		return fmt.Errorf("failed to extract backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/inspect"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+func Show(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	if cli.Show.User != "" && cli.Show.Config {
+		return fmt.Errorf("--user and --config cannot be used together")
+	}
+
+	// stdout carries the output, so send all logging to stderr
+	out, logOut := os.Stdout, os.Stderr
+
+	// Parse URI
+	loc, err := storage.ParseURI(cli.Show.URI)
+	if err != nil {
+		return err
+	}
+
+	// Validate AWS credentials
+	if loc.Scheme == "s3" {
+		if err := config.ValidateAWSCredentials(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	pattern, err := regexp.Compile(cli.Show.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, cli.storageOptions(cli.Show.Storage))
+	if err != nil {
+		return err
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Show.KMSRegion, cli.kmsOptions(cli.Show.KMS))
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	// Extract backup to a temporary directory so that it is read without holding it in memory
+	backupDir, err := os.MkdirTemp("", "acb-show-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	pools, err := loadBackups(ctx, logOut, store, loc.Path, encryptor, backupDir, pattern.MatchString)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return fmt.Errorf("no backups found matching the specified pattern")
+	}
+
+	switch {
+	case cli.Show.User != "":
+		return showUser(ctx, out, logOut, pools, cli.Show.User)
+	case cli.Show.Config:
+		return showConfig(ctx, out, logOut, pools)
+	}
+
+	type poolSummary struct {
+		*inspect.Summary
+		Archive   string `json:"archive"`
+		RunID     string `json:"run_id"`
+		Timestamp string `json:"timestamp"`
+		Parent    string `json:"parent,omitempty"`
+	}
+	var summaries []poolSummary
+	for _, pool := range pools {
+		summary, err := inspect.Summarize(ctx, pool.Source)
+		if err != nil {
+			return fmt.Errorf("failed to read backup of %s: %w", pool.Metadata.UserPoolID, err)
+		}
+		summaries = append(summaries, poolSummary{
+			Summary:   summary,
+			Archive:   pool.Archive,
+			RunID:     pool.Metadata.RunID,
+			Timestamp: pool.Metadata.Timestamp,
+			Parent:    pool.Metadata.Parent,
+		})
+	}
+
+	if cli.Show.Format == "json" {
+		return writeJSON(out, summaries)
+	}
+
+	for i, s := range summaries {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "User pool:\t%s (%s)\n", s.UserPoolID, s.Name)
+		fmt.Fprintf(w, "Backup:\t%s\n", s.Archive)
+		fmt.Fprintf(w, "Taken at:\t%s (run %s)\n", s.Timestamp, orDash(s.RunID))
+		if s.Parent != "" {
+			fmt.Fprintf(w, "Type:\tincremental (parent: %s)\n", s.Parent)
+		} else {
+			fmt.Fprintf(w, "Type:\tfull\n")
+		}
+		fmt.Fprintf(w, "Users:\t%d (enabled: %d, disabled: %d)\n", s.Users, s.EnabledUsers, s.DisabledUsers)
+		fmt.Fprintf(w, "User statuses:\t%s\n", formatCounts(s.UserStatuses))
+		fmt.Fprintf(w, "Groups:\t%d%s\n", len(s.Groups), formatList(s.Groups))
+		fmt.Fprintf(w, "App clients:\t%d%s\n", len(s.Clients), formatList(s.Clients))
+		fmt.Fprintf(w, "MFA:\t%s\n", orDash(s.Config.MFA))
+		fmt.Fprintf(w, "Password policy:\t%s\n", orDash(s.Config.PasswordPolicy))
+		fmt.Fprintf(w, "Username attributes:\t%s\n", orDash(strings.Join(s.Config.UsernameAttributes, ", ")))
+		fmt.Fprintf(w, "Alias attributes:\t%s\n", orDash(strings.Join(s.Config.AliasAttributes, ", ")))
+		fmt.Fprintf(w, "Auto-verified attributes:\t%s\n", orDash(strings.Join(s.Config.AutoVerifiedAttributes, ", ")))
+		fmt.Fprintf(w, "Custom attributes:\t%s\n", orDash(strings.Join(s.Config.CustomAttributes, ", ")))
+		fmt.Fprintf(w, "Lambda triggers:\t%s\n", orDash(strings.Join(s.Config.Triggers, ", ")))
+		fmt.Fprintf(w, "Deletion protection:\t%s\n", orDash(s.Config.DeletionProtection))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// showUser prints the record of the user in each user pool backup as JSON
+func showUser(ctx context.Context, out, logOut io.Writer, pools []backupPool, username string) error {
+	found := false
+	for _, pool := range pools {
+		user, err := inspect.FindUser(ctx, pool.Source, username)
+		if err != nil {
+			return fmt.Errorf("failed to read users of %s: %w", pool.Metadata.UserPoolID, err)
+		}
+		if user == nil {
+			continue
+		}
+		found = true
+		fmt.Fprintf(logOut, "User %s found in user pool %s (%s)\n", username, pool.Metadata.UserPoolID, pool.Archive)
+		if err := writeJSON(out, user); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("user %s not found in the backup", username)
+	}
+	return nil
+}
+
+// showConfig prints the configuration of each user pool backup as JSON
+func showConfig(ctx context.Context, out, logOut io.Writer, pools []backupPool) error {
+	for _, pool := range pools {
+		poolConfig, err := pool.Source.PoolConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read backup of %s: %w", pool.Metadata.UserPoolID, err)
+		}
+		fmt.Fprintf(logOut, "Configuration of user pool %s (%s)\n", pool.Metadata.UserPoolID, pool.Archive)
+		if err := writeJSON(out, poolConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSON writes v to out as indented JSON
+func writeJSON(out io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// formatCounts formats counts as "KEY: N" pairs sorted by key
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	return orDash(strings.Join(parts, ", "))
+}
+
+// formatList formats names in parentheses after a space, or an empty string if there are none
+func formatList(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(names, ", ") + ")"
+}
+
+// orDash returns "-" for an empty value
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/replication"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+func Sync(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Initialize storage
+	store, loc, err := openStorage(ctx, cli.Sync.State, cli.storageOptions(cli.Sync.Storage))
+	if err != nil {
+		return fmt.Errorf("failed to open state path: %w", err)
+	}
+	if loc.IsStdio() {
+		return fmt.Errorf("sync state must be saved to a persistent location")
+	}
+
+	// Initialize Cognito clients
+	sourceOpts := cli.cognitoOptions(cli.Sync.Source)
+	sourceOpts.Region = aws.RegionFromUserPoolID(cli.Sync.SourcePool)
+	sourceClient, err := aws.NewCognitoClient(ctx, sourceOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize source Cognito client: %w", err)
+	}
+
+	targetOpts := cli.cognitoOptions(cli.Sync.Target)
+	targetOpts.Region = cli.Sync.TargetRegion
+	if targetOpts.Region == "" {
+		targetOpts.Region = aws.RegionFromUserPoolID(cli.Sync.TargetPool)
+	}
+	targetClient, err := aws.NewCognitoClient(ctx, targetOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize target Cognito client: %w", err)
+	}
+
+	syncer := replication.NewSyncer(sourceClient, targetClient, cli.Sync.SourcePool, cli.Sync.TargetPool)
+	full := cli.Sync.Full
+	for {
+		if err := syncOnce(ctx, syncer, store, loc.Path, full); err != nil {
+			return err
+		}
+		// Only the first sync applies all users; later ones continue from the cursor
+		full = false
+
+		if cli.Sync.Interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cli.Sync.Interval):
+		}
+	}
+}
+
+// syncOnce runs a single incremental sync, or a full sync if full is set, and saves the cursor
+func syncOnce(ctx context.Context, syncer *replication.Syncer, store storage.Storage, statePath string, full bool) error {
+	// Load state
+	var state replication.State
+	data, err := store.ReadFile(ctx, statePath)
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed to parse state: %w", err)
+		}
+	}
+
+	if state.Cursor.IsZero() {
+		fmt.Println("No previous sync found, starting full sync")
+	} else if full {
+		fmt.Println("Starting full sync")
+	} else {
+		fmt.Printf("Syncing users modified since %s\n", state.Cursor.Format(time.RFC3339))
+	}
+
+	syncer.SetFull(full)
+	result, err := syncer.Sync(ctx, &state)
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+
+	// Save state
+	data, err = json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := store.WriteFile(ctx, statePath, data); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	if result.Failed > 0 {
+		fmt.Printf("Warning: %d users could not be synced and will be retried on the next sync\n", result.Failed)
+	}
+	fmt.Printf("Sync completed (Total: %d, Applied: %d, Failed: %d, Deleted: %d)\n", result.Total, result.Applied, result.Failed, result.Deleted)
+	return nil
+}
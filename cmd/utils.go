@@ -2,71 +2,369 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 
+	"github.com/takaishi/acb/internal/archive"
 	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/backup"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/restore"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
 )
 
-// URIからストレージ情報を解析する
-type storageInfo struct {
-	storageType string // "s3" または "file"
-	bucket      string // S3の場合のバケット名
-	path        string // ファイルパス
+// ExitError is returned by commands whose exit status carries a result, such as diff.
+// Err is printed unless it is nil
+type ExitError struct {
+	Code int
+	Err  error
 }
 
-func parseStorageURI(uri string) (*storageInfo, error) {
-	if uri == "" {
-		return nil, fmt.Errorf("URI is not specified")
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// openStorage parses the URI and initializes the storage of the backend registered for its scheme
+func openStorage(ctx context.Context, uri string, opts storage.Options) (storage.Storage, *storage.Location, error) {
+	loc, err := storage.ParseURI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := storage.Open(ctx, loc, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, loc, nil
+}
+
+// requireCapabilities returns an error if the backend of loc does not support the operations needed by command
+func requireCapabilities(loc *storage.Location, command string, need storage.Capabilities) error {
+	has := loc.Capabilities()
+	switch {
+	case need.List && !has.List:
+		return fmt.Errorf("%s requires listing, which is not supported by %s", command, loc.URI)
+	case need.Delete && !has.Delete:
+		return fmt.Errorf("%s requires deleting, which is not supported by %s", command, loc.URI)
+	case need.Streaming && !has.Streaming:
+		return fmt.Errorf("%s requires streaming, which is not supported by %s", command, loc.URI)
+	}
+	return nil
+}
+
+// clientOptions converts the flags into options for AWS clients
+func (f AssumeRoleFlags) clientOptions() aws.ClientOptions {
+	return aws.ClientOptions{
+		RoleARN:     f.RoleARN,
+		ExternalID:  f.ExternalID,
+		SessionName: f.SessionName,
+	}
+}
+
+// storageOptions returns the options for storage backends, including the global S3, GCS and Azure endpoint settings
+func (cli *CLI) storageOptions(f AssumeRoleFlags) storage.Options {
+	opts := f.clientOptions()
+	opts.Region = cli.S3Region
+	opts.Endpoint = cli.S3Endpoint
+	opts.UsePathStyle = cli.S3PathStyle
+	return storage.Options{
+		AWS: opts,
+		GCS: storage.GCSOptions{Endpoint: cli.GCSEndpoint},
+		Azure: storage.AzureOptions{
+			Account:  cli.AzureAccount,
+			Endpoint: cli.AzureEndpoint,
+		},
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+	}
+}
+
+// cognitoOptions returns the options for Cognito clients, including the global Cognito endpoint
+func (cli *CLI) cognitoOptions(f AssumeRoleFlags) aws.ClientOptions {
+	opts := f.clientOptions()
+	opts.Endpoint = cli.CognitoEndpoint
+	return opts
+}
+
+// kmsOptions returns the options for KMS clients, including the global KMS endpoint
+func (cli *CLI) kmsOptions(f AssumeRoleFlags) aws.ClientOptions {
+	opts := f.clientOptions()
+	opts.Endpoint = cli.KMSEndpoint
+	return opts
+}
+
+// writeOptions converts the flags into options for objects written to S3
+func (f S3WriteFlags) writeOptions() (storage.S3WriteOptions, error) {
+	retention, err := backup.ParseRetention(f.ObjectLockRetention)
+	if err != nil {
+		return storage.S3WriteOptions{}, fmt.Errorf("invalid --s3-object-lock-retention: %w", err)
+	}
+
+	opts := storage.S3WriteOptions{
+		SSEKMSKeyID:         f.SSEKMSKeyID,
+		BucketKey:           f.BucketKey,
+		StorageClass:        f.StorageClass,
+		ObjectLockMode:      f.ObjectLockMode,
+		ObjectLockRetention: retention,
+		Tags:                f.Tag,
+		ChecksumAlgorithm:   f.ChecksumAlgorithm,
+	}
+	if err := opts.Validate(); err != nil {
+		return storage.S3WriteOptions{}, fmt.Errorf("invalid S3 write options: %w", err)
+	}
+	return opts, nil
+}
+
+// restoreOptions converts the flags into options for the restorer
+func (f RestoreFlags) restoreOptions() (restore.Options, error) {
+	userPattern, err := regexp.Compile(f.UserPattern)
+	if err != nil {
+		return restore.Options{}, fmt.Errorf("invalid user pattern: %w", err)
+	}
+
+	return restore.Options{
+		Conflict:           restore.ConflictPolicy(f.OnConflict),
+		UserPattern:        userPattern,
+		SkipGroups:         f.SkipGroups,
+		SkipClients:        f.SkipClients,
+		SkipUsers:          f.SkipUsers,
+		LegacySubAttribute: f.LegacySubAttribute,
+	}, nil
+}
+
+// writeSubMapping saves the old-to-new sub mapping to the specified URI
+func writeSubMapping(ctx context.Context, uri string, opts storage.Options, subMappings *types.SubMappings) error {
+	store, loc, err := openStorage(ctx, uri, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open sub mapping path: %w", err)
+	}
+
+	data, err := json.MarshalIndent(subMappings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sub mapping: %w", err)
+	}
+
+	if err := store.WriteFile(ctx, loc.Path, data); err != nil {
+		return fmt.Errorf("failed to save sub mapping: %w", err)
+	}
+	return nil
+}
+
+// logOutput returns where progress messages are written: stderr when stdout carries the output of the command, stdout otherwise
+func logOutput(stdoutIsOutput bool) io.Writer {
+	if stdoutIsOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// backupArchives returns the keys of the archives referenced by key.
+// key is either an archive, a repository snapshot, a latest.json pointer, a backup destination containing latest.json, or "-" for stdin.
+// Archives listed in the pointer are filtered by user pool ID with match
+func backupArchives(ctx context.Context, store storage.Storage, key string, match func(userPoolID string) bool) ([]string, error) {
+	if key == storage.StdioURI || archive.IsArchiveKey(key) || repository.IsSnapshotKey(key) {
+		return []string{key}, nil
 	}
 
-	if strings.HasPrefix(uri, "s3://") {
-		// S3 URI (s3://bucket/prefix/file.tar.gz)
-		uri = strings.TrimPrefix(uri, "s3://")
-		parts := strings.SplitN(uri, "/", 2)
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid S3 URI format: %s", uri)
+	latestKey := key
+	if path.Base(key) != backup.LatestFile {
+		latestKey = backup.LatestKey(key)
+	}
+
+	latest, err := backup.ReadLatest(ctx, store, latestKey)
+	if err != nil {
+		// Not a backup destination, so treat the key as an archive
+		if storage.IsNotExist(err) && latestKey != key {
+			return []string{key}, nil
 		}
-		bucket := parts[0]
-		key := parts[1]
+		return nil, fmt.Errorf("failed to read latest backup: %w", err)
+	}
 
-		return &storageInfo{
-			storageType: "s3",
-			bucket:      bucket,
-			path:        key,
-		}, nil
-	} else if strings.HasPrefix(uri, "file://") {
-		// File URI (file:///path/to/file.tar.gz)
-		path := strings.TrimPrefix(uri, "file://")
-		return &storageInfo{
-			storageType: "file",
-			path:        path,
-		}, nil
+	seen := make(map[string]bool)
+	var keys []string
+	for userPoolID, archiveKey := range latest.Archives {
+		if !match(userPoolID) || seen[archiveKey] {
+			continue
+		}
+		seen[archiveKey] = true
+		keys = append(keys, archiveKey)
 	}
+	sort.Strings(keys)
+	return keys, nil
+}
 
-	return nil, fmt.Errorf("invalid URI format: %s", uri)
+// readBackupMetadata reads the metadata of the user pool backup extracted into dir
+func readBackupMetadata(dir, userPoolID string) (*types.BackupMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, userPoolID, "metadata.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata types.BackupMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	return &metadata, nil
 }
 
-func readDataKey(info *storageInfo) ([]byte, error) {
-	if info.storageType == "s3" {
-		client, err := aws.NewS3Client(context.TODO())
-		if err != nil {
-			return nil, err
+// backupSource returns the restore source of the user pool backup extracted into dir.
+// The parents of an incremental backup are extracted into parentDir, and the chain up to the full backup is combined.
+// Progress messages and warnings are written to logOut
+func backupSource(ctx context.Context, logOut io.Writer, store storage.Storage, encryptor encryption.Encryptor, dir, parentDir string, metadata *types.BackupMetadata) (restore.Source, error) {
+	source := restore.NewBackupSource(os.DirFS(dir), metadata)
+	if !metadata.IsIncremental() {
+		return source, nil
+	}
+
+	sources := []*restore.BackupSource{source}
+	seen := make(map[string]bool)
+	for metadata.IsIncremental() {
+		key := metadata.Parent
+		if seen[key] {
+			return nil, fmt.Errorf("backup chain of %s refers to %s more than once", metadata.UserPoolID, key)
+		}
+		seen[key] = true
+
+		// Archives holding several user pools are extracted only once
+		archiveDir := filepath.Join(parentDir, fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+		if _, err := os.Stat(archiveDir); err != nil {
+			fmt.Fprintf(logOut, "Extracting parent backup %s...\n", key)
+			if err := extractBackup(ctx, store, key, encryptor, archiveDir); err != nil {
+				return nil, fmt.Errorf("failed to extract parent backup of %s: %w", metadata.UserPoolID, err)
+			}
 		}
-		encryptedDataKey, err := client.GetObject(context.TODO(), info.bucket, info.path)
+
+		parent, err := readBackupMetadata(archiveDir, metadata.UserPoolID)
 		if err != nil {
+			return nil, fmt.Errorf("failed to read parent backup %s: %w", key, err)
+		}
+		sources = append(sources, restore.NewBackupSource(os.DirFS(archiveDir), parent))
+		metadata = parent
+	}
+	chain := restore.NewChainSource(sources)
+	chain.SetLog(logOut)
+	return chain, nil
+}
+
+// backupPool is a user pool backup loaded from an archive or repository snapshot
+type backupPool struct {
+	Archive  string
+	Metadata *types.BackupMetadata
+	Source   restore.Source
+}
+
+// settingsFiles are the files of a user pool backup read to compare its settings, app clients and identity providers
+var settingsFiles = map[string]bool{
+	"metadata.json":           true,
+	"pool-config.json":        true,
+	"clients.json":            true,
+	"identity-providers.json": true,
+}
+
+// loadBackups extracts the backups referenced by key into dir and returns the user pools matching match, sorted by user pool ID.
+// Incremental backups are combined with their parents, which cannot be loaded from stdin. Progress messages and warnings are written to logOut
+func loadBackups(ctx context.Context, logOut io.Writer, store storage.Storage, key string, encryptor encryption.Encryptor, dir string, match func(userPoolID string) bool) ([]backupPool, error) {
+	return loadBackupPools(ctx, logOut, store, key, encryptor, dir, match, false)
+}
+
+// loadBackupSettings is like loadBackups, but extracts only the settings, app clients and identity providers.
+// Every backup, incremental or not, holds the full settings, so parents are not loaded and users cannot be read from the sources
+func loadBackupSettings(ctx context.Context, logOut io.Writer, store storage.Storage, key string, encryptor encryption.Encryptor, dir string, match func(userPoolID string) bool) ([]backupPool, error) {
+	return loadBackupPools(ctx, logOut, store, key, encryptor, dir, match, true)
+}
+
+func loadBackupPools(ctx context.Context, logOut io.Writer, store storage.Storage, key string, encryptor encryption.Encryptor, dir string, match func(userPoolID string) bool, settingsOnly bool) ([]backupPool, error) {
+	archives, err := backupArchives(ctx, store, key, match)
+	if err != nil {
+		return nil, err
+	}
+
+	var files func(name string) bool
+	if settingsOnly {
+		files = func(name string) bool {
+			return match(path.Dir(name)) && settingsFiles[path.Base(name)]
+		}
+	}
+
+	var pools []backupPool
+	for i, archiveKey := range archives {
+		archiveDir := filepath.Join(dir, fmt.Sprintf("%d", i))
+		if err := extractBackupFiles(ctx, store, archiveKey, encryptor, archiveDir, files); err != nil {
 			return nil, err
 		}
-		return encryptedDataKey, nil
-	} else if info.storageType == "file" {
-		data, err := os.ReadFile(info.path)
+
+		entries, err := os.ReadDir(archiveDir)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read data key file: %w", err)
+			return nil, fmt.Errorf("failed to read backup: %w", err)
 		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !match(entry.Name()) {
+				continue
+			}
+
+			metadata, err := readBackupMetadata(archiveDir, entry.Name())
+			if err != nil {
+				fmt.Fprintf(logOut, "Warning: Failed to read metadata (%s): %v\n", entry.Name(), err)
+				continue
+			}
+			if settingsOnly {
+				pools = append(pools, backupPool{
+					Archive:  archiveKey,
+					Metadata: metadata,
+					Source:   restore.NewBackupSource(os.DirFS(archiveDir), metadata),
+				})
+				continue
+			}
+			if key == storage.StdioURI && metadata.IsIncremental() {
+				fmt.Fprintf(logOut, "Warning: Cannot load incremental backup from stdin (%s): parent %s is required\n", metadata.UserPoolID, metadata.Parent)
+				continue
+			}
+			source, err := backupSource(ctx, logOut, store, encryptor, archiveDir, filepath.Join(dir, "parents"), metadata)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load backup chain of %s: %w", metadata.UserPoolID, err)
+			}
+			pools = append(pools, backupPool{
+				Archive:  archiveKey,
+				Metadata: metadata,
+				Source:   source,
+			})
+		}
+	}
+
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Metadata.UserPoolID < pools[j].Metadata.UserPoolID
+	})
+	return pools, nil
+}
+
+// readDataKey reads the data key file at the specified URI
+func readDataKey(ctx context.Context, uri string, opts storage.Options) ([]byte, error) {
+	store, loc, err := openStorage(ctx, uri, opts)
+	if err != nil {
+		return nil, err
+	}
+	if loc.IsStdio() {
+		return nil, fmt.Errorf("data key cannot be read from stdin")
+	}
 
-		return data, nil
-	} else {
-		return nil, fmt.Errorf("invalid storage type: %s", info.storageType)
+	data, err := store.ReadFile(ctx, loc.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data key file: %w", err)
 	}
+	return encryption.ParseDataKey(data)
 }
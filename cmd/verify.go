@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"syscall"
+
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/backup"
+	"github.com/takaishi/acb/internal/config"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/internal/verify"
+)
+
+func Verify(cli *CLI) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Parse URI
+	loc, err := storage.ParseURI(cli.Verify.URI)
+	if err != nil {
+		return err
+	}
+
+	// Validate AWS credentials
+	if loc.Scheme == "s3" {
+		if err := config.ValidateAWSCredentials(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	pattern, err := regexp.Compile(cli.Verify.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	// Initialize storage
+	store, err := storage.Open(ctx, loc, cli.storageOptions(cli.Verify.Storage))
+	if err != nil {
+		return err
+	}
+
+	// Initialize KMS decryption
+	// Encrypted backups carry their encrypted data key, so the key ID is optional here
+	encryptor, err := encryption.NewKMSEncryptor(ctx, cfg.KMS.KeyID, cli.Verify.KMSRegion, cli.kmsOptions(cli.Verify.KMS))
+	if err != nil {
+		return fmt.Errorf("failed to initialize KMS encryption: %w", err)
+	}
+
+	archives, err := backupArchives(ctx, store, loc.Path, pattern.MatchString)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no backups found matching the specified pattern")
+	}
+
+	// Archive checksums and user counts are recorded in the catalog of the destination,
+	// which can only be read from backends that list keys
+	catalog := loc.Capabilities().List
+	expected := make(map[string]*verify.Expected)
+	if catalog && !archive.IsArchiveKey(loc.Path) && !repository.IsSnapshotKey(loc.Path) {
+		prefix := loc.Path
+		if path.Base(prefix) == backup.LatestFile {
+			prefix = path.Dir(prefix)
+		}
+		expected, err = catalogExpectations(ctx, store, prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, key := range archives {
+		fmt.Printf("Verifying backup %s...\n", key)
+		if expected[key] == nil && catalog {
+			fmt.Printf("Warning: %s is not recorded in the catalog; the archive checksum is not checked\n", key)
+		}
+
+		report, err := verifyArchive(ctx, store, key, encryptor, expected[key])
+		if err != nil {
+			fmt.Printf("FAILED  %s: %v\n", key, err)
+			failed++
+			continue
+		}
+		if !report.OK() {
+			for _, problem := range report.Problems {
+				fmt.Printf("FAILED  %s: %s\n", key, problem)
+			}
+			failed++
+			continue
+		}
+		fmt.Printf("OK      %s (%d user pools, %d files)\n", key, len(report.Pools), report.Files)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verification failed for %d of %d backups", failed, len(archives))
+	}
+	fmt.Println("Verification completed")
+	return nil
+}
+
+// verifyArchive reads the backup archive or repository snapshot at key and checks its integrity
+func verifyArchive(ctx context.Context, store storage.Storage, key string, encryptor encryption.Encryptor, expected *verify.Expected) (*verify.Report, error) {
+	if repository.IsSnapshotKey(key) {
+		repo, err := repository.Open(ctx, store, repository.PrefixOf(key), encryptor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repository: %w", err)
+		}
+		data, err := store.ReadFile(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		return verify.Snapshot(ctx, repo, data, expected)
+	}
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer r.Close()
+
+	return verify.Archive(ctx, r, encryptor, expected)
+}
+
+// catalogExpectations returns the archive checksums and user counts recorded in the catalog, keyed by archive
+func catalogExpectations(ctx context.Context, store storage.Storage, prefix string) (map[string]*verify.Expected, error) {
+	manifests, err := backup.ReadCatalog(ctx, store, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	expected := make(map[string]*verify.Expected)
+	for _, manifest := range manifests {
+		for _, pool := range manifest.Pools {
+			e, ok := expected[pool.Archive]
+			if !ok {
+				e = &verify.Expected{
+					Size:   pool.Size,
+					SHA256: pool.SHA256,
+					Users:  make(map[string]int),
+				}
+				expected[pool.Archive] = e
+			}
+			e.Users[pool.UserPoolID] = pool.Users
+		}
+	}
+	return expected, nil
+}
@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec はアーカイブの圧縮方式を表す
+type Codec string
+
+const (
+	Gzip Codec = "gzip"
+	Zstd Codec = "zstd"
+	Xz   Codec = "xz"
+	None Codec = "none"
+)
+
+// Codecs は使用できる圧縮方式の一覧
+var Codecs = []Codec{Gzip, Zstd, Xz, None}
+
+// 各圧縮方式のデータの先頭のバイト列
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// tarマジックの位置（POSIX ustar形式とGNU形式のどちらも "ustar" で始まる）
+const (
+	tarMagicOffset = 257
+	tarMagic       = "ustar"
+)
+
+// detectHeaderSize は圧縮方式の判定に必要なデータの先頭のバイト数
+const detectHeaderSize = tarMagicOffset + len(tarMagic)
+
+// ParseCodec は圧縮方式の名前を解析する
+func ParseCodec(name string) (Codec, error) {
+	for _, codec := range Codecs {
+		if string(codec) == name {
+			return codec, nil
+		}
+	}
+	return "", fmt.Errorf("unknown compression: %s", name)
+}
+
+// Extension はアーカイブのキーの拡張子を返す
+func (c Codec) Extension() string {
+	switch c {
+	case Zstd:
+		return ".tar.zst"
+	case Xz:
+		return ".tar.xz"
+	case None:
+		return ".tar"
+	}
+	return ".tar.gz"
+}
+
+// ValidateLevel は圧縮レベルが圧縮方式で使用できるかを検証する
+// 0は既定の圧縮レベルを表す
+func (c Codec) ValidateLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+	switch c {
+	case Gzip:
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+		}
+		return nil
+	case Zstd:
+		if level < 1 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+		return nil
+	}
+	return fmt.Errorf("%s does not support compression levels", c)
+}
+
+// IsArchiveKey はキーがいずれかの圧縮方式のアーカイブの拡張子を持つかを返す
+func IsArchiveKey(key string) bool {
+	for _, codec := range Codecs {
+		if strings.HasSuffix(key, codec.Extension()) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectCodec はデータの先頭のバイト列から圧縮方式を判定する
+// 判定できない場合はfalseを返す
+func DetectCodec(header []byte) (Codec, bool) {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip, true
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd, true
+	case bytes.HasPrefix(header, xzMagic):
+		return Xz, true
+	case len(header) >= detectHeaderSize && string(header[tarMagicOffset:detectHeaderSize]) == tarMagic:
+		return None, true
+	}
+	return "", false
+}
+
+// newCompressor はwに圧縮したデータを書き込むライターを返す
+func newCompressor(w io.Writer, codec Codec, level int) (io.WriteCloser, error) {
+	switch codec {
+	case Gzip, "":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+	case Xz:
+		return xz.NewWriter(w)
+	case None:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression: %s", codec)
+}
+
+// newDecompressor は圧縮方式を判定し、rを展開するリーダーを返す
+func newDecompressor(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReaderSize(r, detectHeaderSize)
+	header, err := br.Peek(detectHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	codec, ok := DetectCodec(header)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown archive format")
+	}
+
+	switch codec {
+	case Gzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gr, codec, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), codec, nil
+	case Xz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return io.NopCloser(xr), codec, nil
+	}
+	return io.NopCloser(br), codec, nil
+}
+
+// nopWriteCloser は何もしないCloseを持つライター
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
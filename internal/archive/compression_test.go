@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// tarData はファイルを1つ含むtarアーカイブを返す
+func tarData(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte(`{"Username":"user"}` + "\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "users.jsonl", Mode: 0o644, Size: int64(len(content))}); err != nil {
+		t.Fatalf("failed to write tar header: %v", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatalf("failed to write tar entry: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// compressed はdataをcodecで圧縮したデータを返す
+func compressed(t *testing.T, codec Codec, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newCompressor(&buf, codec, 0)
+	if err != nil {
+		t.Fatalf("newCompressor() error = %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close compressor: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectCodec(t *testing.T) {
+	archive := tarData(t)
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   Codec
+		ok     bool
+	}{
+		{name: "gzip", header: compressed(t, Gzip, archive), want: Gzip, ok: true},
+		{name: "zstd", header: compressed(t, Zstd, archive), want: Zstd, ok: true},
+		{name: "xz", header: compressed(t, Xz, archive), want: Xz, ok: true},
+		{name: "uncompressed tar", header: compressed(t, None, archive), want: None, ok: true},
+		{name: "gzip magic only", header: gzipMagic, want: Gzip, ok: true},
+		{name: "truncated magic", header: zstdMagic[:3]},
+		{name: "tar header too short", header: archive[:detectHeaderSize-1]},
+		{name: "empty"},
+		{name: "plain text", header: bytes.Repeat([]byte("text\n"), 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if len(header) > detectHeaderSize {
+				header = header[:detectHeaderSize]
+			}
+			got, ok := DetectCodec(header)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("DetectCodec() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDecompressorRoundTrip(t *testing.T) {
+	archive := tarData(t)
+	for _, codec := range Codecs {
+		t.Run(string(codec), func(t *testing.T) {
+			r, detected, err := newDecompressor(bytes.NewReader(compressed(t, codec, archive)))
+			if err != nil {
+				t.Fatalf("newDecompressor() error = %v", err)
+			}
+			defer r.Close()
+			if detected != codec {
+				t.Errorf("detected codec = %q, want %q", detected, codec)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to decompress: %v", err)
+			}
+			if !bytes.Equal(data, archive) {
+				t.Errorf("decompressed %d bytes, want %d bytes", len(data), len(archive))
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// DigestReader は読み込んだデータのサイズとSHA-256を記録する
+// カタログに記録されたアーカイブのサイズとハッシュを、読み込みながら検証するために使う
+type DigestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+// NewDigestReader はrから読み込むDigestReaderを作成する
+func NewDigestReader(r io.Reader) *DigestReader {
+	return &DigestReader{r: r, hash: sha256.New()}
+}
+
+func (d *DigestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Size はこれまでに読み込んだデータのサイズを返す
+func (d *DigestReader) Size() int64 {
+	return d.size
+}
+
+// SHA256 はこれまでに読み込んだデータのSHA-256を返す
+func (d *DigestReader) SHA256() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// digestWriter は書き込んだデータのサイズとハッシュを記録する
+type digestWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/takaishi/acb/internal/encryption"
+)
+
+// ErrEncrypted はアーカイブが暗号化されていて、復号化の設定がないことを表す
+var ErrEncrypted = errors.New("backup is encrypted; KMS settings are required to read it")
+
+// Decrypt はアーカイブを必要に応じて復号化したリーダーを返す
+// ストリーミング形式の暗号化、旧形式の暗号化、暗号化なしのいずれにも対応する
+// 暗号化されていないデータは、圧縮方式のマジックバイトで判定する
+func Decrypt(ctx context.Context, r io.Reader, encryptor encryption.Encryptor) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, detectHeaderSize)
+	header, err := br.Peek(detectHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	switch {
+	case encryption.IsStream(header):
+		if encryptor == nil {
+			return nil, ErrEncrypted
+		}
+		return encryptor.DecryptStream(ctx, br)
+	}
+	if _, ok := DetectCodec(header); ok {
+		return br, nil
+	}
+
+	// 旧形式の暗号化データはストリーミングで復号化できないため、まとめて復号化する
+	if encryptor == nil {
+		return nil, ErrEncrypted
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	encryptedData, err := encryption.DeserializeEncryptedData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize encrypted data: %w", err)
+	}
+	plaintext, err := encryptor.Decrypt(ctx, encryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// Reader はバックアップアーカイブをストリーミングで読み込む
+type Reader struct {
+	cr    io.ReadCloser
+	tr    *tar.Reader
+	codec Codec
+}
+
+// NewReader はrからアーカイブを読み込む新しいReaderを作成する
+// encryptorがnilの場合、暗号化されたアーカイブは読み込めない
+// 圧縮方式はマジックバイトから自動的に判定する
+func NewReader(ctx context.Context, r io.Reader, encryptor encryption.Encryptor) (*Reader, error) {
+	plain, err := Decrypt(ctx, r, encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	cr, codec, err := newDecompressor(plain)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		cr:    cr,
+		tr:    tar.NewReader(cr),
+		codec: codec,
+	}, nil
+}
+
+// Codec はアーカイブの圧縮方式を返す
+func (r *Reader) Codec() Codec {
+	return r.codec
+}
+
+// Next は次のファイルのエントリに進む
+// エントリ名は "<ユーザープールID>/<ファイル名>" の形式
+func (r *Reader) Next() (*tar.Header, error) {
+	for {
+		header, err := r.tr.Next()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			return header, nil
+		}
+	}
+}
+
+// Read は現在のエントリの内容を読み込む
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+// Find はnameのエントリまで読み進める
+func (r *Reader) Find(name string) error {
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in backup: %w", name, fs.ErrNotExist)
+		}
+		if err != nil {
+			return err
+		}
+		if path.Clean(header.Name) == name {
+			return nil
+		}
+	}
+}
+
+// Extract はアーカイブのファイルをdirに展開する
+func (r *Reader) Extract(dir string) error {
+	return r.ExtractFiles(dir, nil)
+}
+
+// ExtractFiles はアーカイブのファイルのうち、matchがtrueを返すものだけをdirに展開する
+// matchにはエントリ名を渡す。matchがnilの場合はすべてのファイルを展開する
+func (r *Reader) ExtractFiles(dir string, match func(name string) bool) error {
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file name in backup: %s", header.Name)
+		}
+		if match != nil && !match(name) {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := extractFile(target, r.tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Close はアーカイブの読み込みを終了する
+func (r *Reader) Close() error {
+	return r.cr.Close()
+}
+
+// extractFile はrの内容をファイルに書き込む
+func extractFile(target string, r io.Reader) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", target, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,233 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// MaxEntrySize はエントリ1つの最大サイズ
+// エントリの内容は一時ファイルに書き込むため、一時ディレクトリにはバックアップ中のユーザープールごとに
+// 書き込み中のエントリ（ユーザー情報のシャードと増分バックアップのユーザー一覧）の合計サイズの空きが必要になる
+// ユーザー情報はシャードに分けて保存するため、通常はこの上限よりはるかに小さい
+const MaxEntrySize int64 = 4 << 30
+
+// Writer はバックアップアーカイブをストリーミングで書き込む
+// エントリは tar → 圧縮 → 暗号化 の順に処理され、そのまま保存先に書き込まれる
+type Writer struct {
+	mu      sync.Mutex
+	dest    storage.Writer
+	digest  *digestWriter
+	enc     io.WriteCloser // 暗号化しない場合はnil
+	cw      io.WriteCloser
+	tw      *tar.Writer
+	modTime time.Time
+	save    func(name string, r io.Reader) error // NewFuncWriterの場合のエントリの保存先
+
+	checksums map[string]string // エントリ名 -> SHA-256
+}
+
+// NewWriter は保存先に書き込む新しいWriterを作成する
+// encryptorがnilの場合は暗号化しない。levelが0の場合は圧縮方式の既定の圧縮レベルを使用する
+func NewWriter(ctx context.Context, dest storage.Writer, encryptor encryption.Encryptor, codec Codec, level int) (*Writer, error) {
+	w := &Writer{
+		dest:      dest,
+		digest:    &digestWriter{w: dest, hash: sha256.New()},
+		modTime:   time.Now(),
+		checksums: make(map[string]string),
+	}
+
+	var out io.Writer = w.digest
+	if encryptor != nil {
+		enc, err := encryptor.EncryptStream(ctx, w.digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start encryption: %w", err)
+		}
+		w.enc = enc
+		out = enc
+	}
+
+	cw, err := newCompressor(out, codec, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start compression: %w", err)
+	}
+	w.cw = cw
+	w.tw = tar.NewWriter(w.cw)
+	return w, nil
+}
+
+// NewFuncWriter はエントリをtarにまとめずに、1つずつsaveに渡すWriterを作成する
+// リポジトリのようにエントリを個別に保存する場合に使用し、Size、SHA256は常にゼロ値を返す
+func NewFuncWriter(save func(name string, r io.Reader) error) *Writer {
+	return &Writer{
+		save:      save,
+		checksums: make(map[string]string),
+	}
+}
+
+// Create はnameのエントリを作成する
+// tarヘッダーにはサイズが必要なため、エントリの内容はいったん一時ファイルに書き込み、Entry.Closeでアーカイブに追加する
+// 一時ファイルはMaxEntrySizeまでで、それを超えて書き込むとエラーになる
+// 複数のgoroutineから並行してエントリを作成できる
+func (w *Writer) Create(name string) (*Entry, error) {
+	f, err := os.CreateTemp("", "acb-entry-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &Entry{
+		w:    w,
+		name: name,
+		file: f,
+		hash: sha256.New(),
+	}, nil
+}
+
+// WriteJSON はvをJSONにエンコードし、nameのエントリとして追加する
+func (w *Writer) WriteJSON(name string, v interface{}) error {
+	entry, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		entry.Discard()
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return entry.Close()
+}
+
+// Close はアーカイブを閉じて保存を確定する
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.save != nil {
+		return nil
+	}
+
+	if err := w.tw.Close(); err != nil {
+		w.dest.Abort()
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := w.cw.Close(); err != nil {
+		w.dest.Abort()
+		return fmt.Errorf("failed to close compression writer: %w", err)
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			w.dest.Abort()
+			return fmt.Errorf("failed to close encryption stream: %w", err)
+		}
+	}
+	return w.dest.Close()
+}
+
+// Abort はアーカイブの書き込みを中止する
+func (w *Writer) Abort() error {
+	if w.save != nil {
+		return nil
+	}
+	return w.dest.Abort()
+}
+
+// Size は保存先に書き込んだアーカイブのサイズを返す
+func (w *Writer) Size() int64 {
+	if w.digest == nil {
+		return 0
+	}
+	return w.digest.size
+}
+
+// SHA256 は保存先に書き込んだアーカイブのSHA-256を返す
+func (w *Writer) SHA256() string {
+	if w.digest == nil {
+		return ""
+	}
+	return hex.EncodeToString(w.digest.hash.Sum(nil))
+}
+
+// Checksum はアーカイブに追加したnameのエントリのSHA-256を返す
+// エントリが追加されていない場合は空文字列を返す
+func (w *Writer) Checksum(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.checksums[name]
+}
+
+// add はrの内容をnameのエントリとしてアーカイブに追加する
+func (w *Writer) add(name string, r io.Reader, size int64, checksum string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.save != nil {
+		if err := w.save(name, r); err != nil {
+			return fmt.Errorf("failed to save %s: %w", name, err)
+		}
+		w.checksums[name] = checksum
+		return nil
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Size:    size,
+		Mode:    0644,
+		ModTime: w.modTime,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := io.Copy(w.tw, r); err != nil {
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+	w.checksums[name] = checksum
+	return nil
+}
+
+// Entry はアーカイブに追加するエントリを表す
+type Entry struct {
+	w    *Writer
+	name string
+	file *os.File
+	size int64
+	hash hash.Hash
+}
+
+// Write はエントリにデータを書き込む
+func (e *Entry) Write(p []byte) (int, error) {
+	if e.size+int64(len(p)) > MaxEntrySize {
+		return 0, fmt.Errorf("entry %s exceeds the maximum size of %d bytes", e.name, MaxEntrySize)
+	}
+	n, err := e.file.Write(p)
+	e.hash.Write(p[:n])
+	e.size += int64(n)
+	return n, err
+}
+
+// Close はエントリをアーカイブに追加し、一時ファイルを削除する
+func (e *Entry) Close() error {
+	defer e.Discard()
+
+	if _, err := e.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	return e.w.add(e.name, e.file, e.size, hex.EncodeToString(e.hash.Sum(nil)))
+}
+
+// Discard はエントリをアーカイブに追加せずに一時ファイルを削除する
+func (e *Entry) Discard() {
+	e.file.Close()
+	os.Remove(e.file.Name())
+}
@@ -2,10 +2,13 @@ package aws
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"regexp"
+	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	cognito "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 )
@@ -16,17 +19,26 @@ type CognitoClient struct {
 }
 
 // NewCognitoClient は新しいCognitoClientを作成する
-func NewCognitoClient(ctx context.Context) (*CognitoClient, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func NewCognitoClient(ctx context.Context, opts ClientOptions) (*CognitoClient, error) {
+	cfg, err := LoadConfig(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 	}
 
 	return &CognitoClient{
-		client: cognito.NewFromConfig(cfg),
+		client: cognito.NewFromConfig(cfg, func(o *cognito.Options) {
+			if opts.Endpoint != "" {
+				o.BaseEndpoint = opts.BaseEndpoint()
+			}
+		}),
 	}, nil
 }
 
+// Region はクライアントのリージョンを返す
+func (c *CognitoClient) Region() string {
+	return c.client.Options().Region
+}
+
 // ListUserPools は指定されたパターンに一致するユーザープールの一覧を取得する
 func (c *CognitoClient) ListUserPools(ctx context.Context, pattern string) ([]types.UserPoolDescriptionType, error) {
 	var userPools []types.UserPoolDescriptionType
@@ -82,6 +94,18 @@ func (c *CognitoClient) GetUserPoolConfiguration(ctx context.Context, userPoolID
 // ListUsers はユーザープール内のすべてのユーザーを取得する
 func (c *CognitoClient) ListUsers(ctx context.Context, userPoolID string) ([]types.UserType, error) {
 	var users []types.UserType
+	err := c.ListUsersPages(ctx, userPoolID, func(page []types.UserType) error {
+		users = append(users, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsersPages はユーザープール内のユーザーをページ単位で取得し、fnに渡す
+func (c *CognitoClient) ListUsersPages(ctx context.Context, userPoolID string, fn func([]types.UserType) error) error {
 	var nextToken *string
 
 	for {
@@ -92,10 +116,12 @@ func (c *CognitoClient) ListUsers(ctx context.Context, userPoolID string) ([]typ
 
 		output, err := c.client.ListUsers(ctx, input)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get user list: %w", err)
+			return fmt.Errorf("failed to get user list: %w", err)
 		}
 
-		users = append(users, output.Users...)
+		if err := fn(output.Users); err != nil {
+			return err
+		}
 
 		if output.PaginationToken == nil {
 			break
@@ -103,7 +129,7 @@ func (c *CognitoClient) ListUsers(ctx context.Context, userPoolID string) ([]typ
 		nextToken = output.PaginationToken
 	}
 
-	return users, nil
+	return nil
 }
 
 // ListUserGroups はユーザーが所属するグループの一覧を取得する
@@ -163,103 +189,313 @@ func (c *CognitoClient) CreateUserPool(ctx context.Context, input *cognito.Creat
 	return output, nil
 }
 
-// ToPoolPolicies はマップからCognitoのポリシー設定に変換する
-func ToPoolPolicies(policies map[string]interface{}) *types.UserPoolPolicyType {
-	if policies == nil {
-		return nil
+// AuthenticateUser はユーザー名とパスワードでユーザープールに認証し、パスワードを検証する
+// クライアントではALLOW_ADMIN_USER_PASSWORD_AUTHを有効にしておく必要がある
+func (c *CognitoClient) AuthenticateUser(ctx context.Context, userPoolID, clientID, clientSecret, username, password string) error {
+	params := map[string]string{
+		"USERNAME": username,
+		"PASSWORD": password,
+	}
+	if clientSecret != "" {
+		params["SECRET_HASH"] = secretHash(clientID, clientSecret, username)
 	}
 
-	return &types.UserPoolPolicyType{
-		PasswordPolicy: toPasswordPolicy(policies["password_policy"].(map[string]interface{})),
+	input := &cognito.AdminInitiateAuthInput{
+		UserPoolId:     &userPoolID,
+		ClientId:       &clientID,
+		AuthFlow:       types.AuthFlowTypeAdminUserPasswordAuth,
+		AuthParameters: params,
 	}
+
+	// MFAなどのチャレンジはパスワード検証後に返されるため、エラーがなければ認証成功とみなす
+	if _, err := c.client.AdminInitiateAuth(ctx, input); err != nil {
+		return fmt.Errorf("failed to authenticate user: %w", err)
+	}
+	return nil
 }
 
-// toPasswordPolicy はマップからパスワードポリシーに変換する
-func toPasswordPolicy(policy map[string]interface{}) *types.PasswordPolicyType {
-	if policy == nil {
-		return nil
+// secretHash はクライアントシークレットを使用したSECRET_HASHを計算する
+func secretHash(clientID, clientSecret, username string) string {
+	mac := hmac.New(sha256.New, []byte(clientSecret))
+	mac.Write([]byte(username + clientID))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EnsureCustomAttribute はユーザープールにカスタム属性が存在しない場合に追加する
+func (c *CognitoClient) EnsureCustomAttribute(ctx context.Context, userPoolID, name string) error {
+	poolConfig, err := c.GetUserPoolConfiguration(ctx, userPoolID)
+	if err != nil {
+		return err
 	}
 
-	minimumLength := int32(policy["minimum_length"].(float64))
-	requireUppercase := policy["require_uppercase"].(bool)
-	requireLowercase := policy["require_lowercase"].(bool)
-	requireNumbers := policy["require_numbers"].(bool)
-	requireSymbols := policy["require_symbols"].(bool)
+	// スキーマ上のカスタム属性は "custom:" プレフィックス付きで返される
+	attrName := strings.TrimPrefix(name, "custom:")
+	for _, attr := range poolConfig.UserPool.SchemaAttributes {
+		if attr.Name != nil && *attr.Name == "custom:"+attrName {
+			return nil
+		}
+	}
 
-	return &types.PasswordPolicyType{
-		MinimumLength:    &minimumLength,
-		RequireUppercase: requireUppercase,
-		RequireLowercase: requireLowercase,
-		RequireNumbers:   requireNumbers,
-		RequireSymbols:   requireSymbols,
+	mutable := true
+	input := &cognito.AddCustomAttributesInput{
+		UserPoolId: &userPoolID,
+		CustomAttributes: []types.SchemaAttributeType{
+			{
+				Name:              &attrName,
+				AttributeDataType: types.AttributeDataTypeString,
+				Mutable:           &mutable,
+			},
+		},
+	}
+	if _, err := c.client.AddCustomAttributes(ctx, input); err != nil {
+		return fmt.Errorf("failed to add custom attribute %s: %w", name, err)
 	}
+	return nil
 }
 
-// ToMFAConfig はマップからMFA設定に変換する
-func ToMFAConfig(config map[string]interface{}) types.UserPoolMfaType {
-	if config == nil {
-		return types.UserPoolMfaTypeOff
+// ListGroups はユーザープール内のすべてのグループを取得する
+func (c *CognitoClient) ListGroups(ctx context.Context, userPoolID string) ([]types.GroupType, error) {
+	var groups []types.GroupType
+	var nextToken *string
+
+	for {
+		input := &cognito.ListGroupsInput{
+			UserPoolId: &userPoolID,
+			NextToken:  nextToken,
+		}
+
+		output, err := c.client.ListGroups(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group list: %w", err)
+		}
+
+		groups = append(groups, output.Groups...)
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
 	}
 
-	mfaType := config["mfa_type"].(string)
-	switch mfaType {
-	case "OFF":
-		return types.UserPoolMfaTypeOff
-	case "ON":
-		return types.UserPoolMfaTypeOn
-	case "OPTIONAL":
-		return types.UserPoolMfaTypeOptional
-	default:
-		return types.UserPoolMfaTypeOff
+	return groups, nil
+}
+
+// ListUsersInGroup はグループに所属するユーザー名の一覧を取得する
+func (c *CognitoClient) ListUsersInGroup(ctx context.Context, userPoolID, groupName string) ([]string, error) {
+	var usernames []string
+	var nextToken *string
+
+	for {
+		input := &cognito.ListUsersInGroupInput{
+			UserPoolId: &userPoolID,
+			GroupName:  &groupName,
+			NextToken:  nextToken,
+		}
+
+		output, err := c.client.ListUsersInGroup(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get users in group: %w", err)
+		}
+
+		for _, user := range output.Users {
+			usernames = append(usernames, *user.Username)
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
 	}
+
+	return usernames, nil
 }
 
-// ToSchemaAttributes はカスタム属性の配列からスキーマ属性に変換する
-func ToSchemaAttributes(attributes []interface{}) []types.SchemaAttributeType {
-	if attributes == nil {
-		return nil
+// ListUserPoolClients はユーザープール内のすべてのアプリクライアントの設定を取得する
+func (c *CognitoClient) ListUserPoolClients(ctx context.Context, userPoolID string) ([]types.UserPoolClientType, error) {
+	var clients []types.UserPoolClientType
+	var nextToken *string
+
+	var maxResults int32 = 60
+	for {
+		input := &cognito.ListUserPoolClientsInput{
+			UserPoolId: &userPoolID,
+			MaxResults: &maxResults,
+			NextToken:  nextToken,
+		}
+
+		output, err := c.client.ListUserPoolClients(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user pool client list: %w", err)
+		}
+
+		// 一覧には設定が含まれないため個別に取得する
+		for _, client := range output.UserPoolClients {
+			describeOutput, err := c.client.DescribeUserPoolClient(ctx, &cognito.DescribeUserPoolClientInput{
+				UserPoolId: &userPoolID,
+				ClientId:   client.ClientId,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get user pool client configuration: %w", err)
+			}
+			clients = append(clients, *describeOutput.UserPoolClient)
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
 	}
 
-	var schemaAttrs []types.SchemaAttributeType
-	for _, attr := range attributes {
-		attrMap := attr.(map[string]interface{})
-		name := attrMap["name"].(string)
-		attrType := attrMap["type"].(string)
-		mutable := attrMap["mutable"].(bool)
-		required := attrMap["required"].(bool)
+	return clients, nil
+}
+
+// ListIdentityProviders はユーザープール内のすべての外部IDプロバイダーの設定を取得する
+func (c *CognitoClient) ListIdentityProviders(ctx context.Context, userPoolID string) ([]types.IdentityProviderType, error) {
+	var providers []types.IdentityProviderType
+	var nextToken *string
 
-		schemaAttr := types.SchemaAttributeType{
-			Name:              &name,
-			AttributeDataType: types.AttributeDataType(attrType),
-			Mutable:           &mutable,
-			Required:          &required,
+	var maxResults int32 = 60
+	for {
+		input := &cognito.ListIdentityProvidersInput{
+			UserPoolId: &userPoolID,
+			MaxResults: &maxResults,
+			NextToken:  nextToken,
+		}
+
+		output, err := c.client.ListIdentityProviders(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get identity provider list: %w", err)
 		}
-		schemaAttrs = append(schemaAttrs, schemaAttr)
+
+		// 一覧には設定が含まれないため個別に取得する
+		for _, provider := range output.Providers {
+			describeOutput, err := c.client.DescribeIdentityProvider(ctx, &cognito.DescribeIdentityProviderInput{
+				UserPoolId:   &userPoolID,
+				ProviderName: provider.ProviderName,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get identity provider configuration: %w", err)
+			}
+			providers = append(providers, *describeOutput.IdentityProvider)
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
 	}
 
-	return schemaAttrs
+	return providers, nil
 }
 
-// ToLambdaConfig はマップからLambda設定に変換する
-func ToLambdaConfig(config map[string]interface{}) *types.LambdaConfigType {
-	if config == nil {
-		return nil
+// CreateGroup は新しいグループを作成する
+func (c *CognitoClient) CreateGroup(ctx context.Context, input *cognito.CreateGroupInput) (*cognito.CreateGroupOutput, error) {
+	output, err := c.client.CreateGroup(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+	return output, nil
+}
+
+// UpdateGroup はグループを更新する
+func (c *CognitoClient) UpdateGroup(ctx context.Context, input *cognito.UpdateGroupInput) (*cognito.UpdateGroupOutput, error) {
+	output, err := c.client.UpdateGroup(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update group: %w", err)
+	}
+	return output, nil
+}
+
+// CreateUserPoolClient は新しいアプリクライアントを作成する
+func (c *CognitoClient) CreateUserPoolClient(ctx context.Context, input *cognito.CreateUserPoolClientInput) (*cognito.CreateUserPoolClientOutput, error) {
+	output, err := c.client.CreateUserPoolClient(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user pool client: %w", err)
 	}
+	return output, nil
+}
 
-	lambdaConfig := &types.LambdaConfigType{}
+// UpdateUserPoolClient はアプリクライアントを更新する
+func (c *CognitoClient) UpdateUserPoolClient(ctx context.Context, input *cognito.UpdateUserPoolClientInput) (*cognito.UpdateUserPoolClientOutput, error) {
+	output, err := c.client.UpdateUserPoolClient(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user pool client: %w", err)
+	}
+	return output, nil
+}
 
-	if preSignUp, ok := config["pre_sign_up"].(string); ok {
-		lambdaConfig.PreSignUp = &preSignUp
+// UpdateUserAttributes はユーザーの属性を更新する
+func (c *CognitoClient) UpdateUserAttributes(ctx context.Context, input *cognito.AdminUpdateUserAttributesInput) (*cognito.AdminUpdateUserAttributesOutput, error) {
+	output, err := c.client.AdminUpdateUserAttributes(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user attributes: %w", err)
 	}
-	if postConfirmation, ok := config["post_confirmation"].(string); ok {
-		lambdaConfig.PostConfirmation = &postConfirmation
+	return output, nil
+}
+
+// GetUser はユーザーの情報を取得する
+func (c *CognitoClient) GetUser(ctx context.Context, userPoolID, username string) (*cognito.AdminGetUserOutput, error) {
+	output, err := c.client.AdminGetUser(ctx, &cognito.AdminGetUserInput{
+		UserPoolId: &userPoolID,
+		Username:   &username,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
-	if preAuthentication, ok := config["pre_authentication"].(string); ok {
-		lambdaConfig.PreAuthentication = &preAuthentication
+	return output, nil
+}
+
+// DeleteUser はユーザーを削除する
+func (c *CognitoClient) DeleteUser(ctx context.Context, userPoolID, username string) error {
+	_, err := c.client.AdminDeleteUser(ctx, &cognito.AdminDeleteUserInput{
+		UserPoolId: &userPoolID,
+		Username:   &username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
 	}
-	if postAuthentication, ok := config["post_authentication"].(string); ok {
-		lambdaConfig.PostAuthentication = &postAuthentication
+	return nil
+}
+
+// DisableUser はユーザーを無効化する
+func (c *CognitoClient) DisableUser(ctx context.Context, userPoolID, username string) error {
+	_, err := c.client.AdminDisableUser(ctx, &cognito.AdminDisableUserInput{
+		UserPoolId: &userPoolID,
+		Username:   &username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable user: %w", err)
 	}
+	return nil
+}
+
+// EnableUser はユーザーを有効化する
+func (c *CognitoClient) EnableUser(ctx context.Context, userPoolID, username string) error {
+	_, err := c.client.AdminEnableUser(ctx, &cognito.AdminEnableUserInput{
+		UserPoolId: &userPoolID,
+		Username:   &username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable user: %w", err)
+	}
+	return nil
+}
+
+// RemoveUserFromGroup はユーザーをグループから削除する
+func (c *CognitoClient) RemoveUserFromGroup(ctx context.Context, userPoolID, username, groupName string) error {
+	_, err := c.client.AdminRemoveUserFromGroup(ctx, &cognito.AdminRemoveUserFromGroupInput{
+		UserPoolId: &userPoolID,
+		Username:   &username,
+		GroupName:  &groupName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove user from group: %w", err)
+	}
+	return nil
+}
 
-	return lambdaConfig
+// RegionFromUserPoolID はユーザープールIDからリージョンを取得する
+// ユーザープールIDは "<リージョン>_<ID>" の形式
+func RegionFromUserPoolID(userPoolID string) string {
+	return strings.SplitN(userPoolID, "_", 2)[0]
 }
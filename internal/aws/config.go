@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"context"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// DefaultSessionName はAssumeRole時のデフォルトのセッション名
+const DefaultSessionName = "acb"
+
+// ClientOptions はAWSクライアントごとの認証情報の設定を表す
+type ClientOptions struct {
+	Region      string // リージョン（空の場合は環境のリージョンを使用）
+	RoleARN     string // AssumeRoleするロールのARN（空の場合は環境の認証情報を使用）
+	ExternalID  string // AssumeRole時の外部ID
+	SessionName string // AssumeRole時のセッション名
+
+	Endpoint     string // エンドポイントのURL（空の場合はAWSのエンドポイントを使用）
+	UsePathStyle bool   // S3でパス形式のアドレスを使用する（S3互換ストレージ向け）
+}
+
+// BaseEndpoint はエンドポイントが指定されている場合にそのURLを返す
+func (o ClientOptions) BaseEndpoint() *string {
+	if o.Endpoint == "" {
+		return nil
+	}
+	return awssdk.String(o.Endpoint)
+}
+
+// S3Options はS3クライアントにエンドポイントとアドレス形式を設定する
+func (o ClientOptions) S3Options(s3Options *s3.Options) {
+	if o.Endpoint != "" {
+		s3Options.BaseEndpoint = o.BaseEndpoint()
+	}
+	s3Options.UsePathStyle = o.UsePathStyle
+}
+
+// LoadConfig はAWS設定を読み込み、ロールが指定されている場合はAssumeRoleした認証情報を設定する
+func LoadConfig(ctx context.Context, opts ClientOptions, optFns ...func(*config.LoadOptions) error) (awssdk.Config, error) {
+	if opts.Region != "" {
+		optFns = append([]func(*config.LoadOptions) error{config.WithRegion(opts.Region)}, optFns...)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return awssdk.Config{}, err
+	}
+
+	if opts.RoleARN == "" {
+		return cfg, nil
+	}
+
+	sessionName := opts.SessionName
+	if sessionName == "" {
+		sessionName = DefaultSessionName
+	}
+
+	// 環境の認証情報でSTSを呼び出し、AssumeRoleした認証情報に差し替える
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if opts.ExternalID != "" {
+			o.ExternalID = awssdk.String(opts.ExternalID)
+		}
+	})
+	cfg.Credentials = awssdk.NewCredentialsCache(provider)
+
+	return cfg, nil
+}
@@ -0,0 +1,176 @@
+package aws
+
+import (
+	"strings"
+
+	cognito "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// ToUserInfo はCognitoのユーザー情報をバックアップ用のユーザー情報に変換する
+func ToUserInfo(user types.UserType, groups []string) pkgtypes.UserInfo {
+	enabled := user.Enabled
+	userInfo := pkgtypes.UserInfo{
+		Username:             *user.Username,
+		Groups:               groups,
+		Enabled:              &enabled,
+		UserStatus:           string(user.UserStatus),
+		UserCreateDate:       user.UserCreateDate,
+		UserLastModifiedDate: user.UserLastModifiedDate,
+	}
+
+	// 属性の変換
+	attributes := make([]map[string]interface{}, len(user.Attributes))
+	for i, attr := range user.Attributes {
+		attributes[i] = map[string]interface{}{
+			"Name":  *attr.Name,
+			"Value": *attr.Value,
+		}
+	}
+	userInfo.Attributes = attributes
+
+	return userInfo
+}
+
+// ToCreateUserPoolInput はバックアップしたユーザープールの設定からユーザープールの作成リクエストに変換する
+func ToCreateUserPoolInput(pool *types.UserPoolType, poolName string) *cognito.CreateUserPoolInput {
+	return &cognito.CreateUserPoolInput{
+		PoolName:                    &poolName,
+		AccountRecoverySetting:      pool.AccountRecoverySetting,
+		AdminCreateUserConfig:       pool.AdminCreateUserConfig,
+		AliasAttributes:             pool.AliasAttributes,
+		AutoVerifiedAttributes:      pool.AutoVerifiedAttributes,
+		DeletionProtection:          pool.DeletionProtection,
+		DeviceConfiguration:         pool.DeviceConfiguration,
+		EmailConfiguration:          pool.EmailConfiguration,
+		EmailVerificationMessage:    pool.EmailVerificationMessage,
+		EmailVerificationSubject:    pool.EmailVerificationSubject,
+		LambdaConfig:                pool.LambdaConfig,
+		MfaConfiguration:            pool.MfaConfiguration,
+		Policies:                    pool.Policies,
+		Schema:                      ToSchemaAttributes(pool.SchemaAttributes),
+		SmsAuthenticationMessage:    pool.SmsAuthenticationMessage,
+		SmsConfiguration:            pool.SmsConfiguration,
+		SmsVerificationMessage:      pool.SmsVerificationMessage,
+		UserAttributeUpdateSettings: pool.UserAttributeUpdateSettings,
+		UserPoolAddOns:              pool.UserPoolAddOns,
+		UserPoolTags:                pool.UserPoolTags,
+		UserPoolTier:                pool.UserPoolTier,
+		UsernameAttributes:          pool.UsernameAttributes,
+		UsernameConfiguration:       pool.UsernameConfiguration,
+		VerificationMessageTemplate: pool.VerificationMessageTemplate,
+	}
+}
+
+// ToSchemaAttributes はユーザープールのスキーマから作成時に指定するスキーマ属性に変換する
+// 標準属性は必須のもののみ、カスタム属性は "custom:" プレフィックスを除いて指定する
+func ToSchemaAttributes(attributes []types.SchemaAttributeType) []types.SchemaAttributeType {
+	var schemaAttrs []types.SchemaAttributeType
+	for _, attr := range attributes {
+		if attr.Name == nil {
+			continue
+		}
+
+		if strings.HasPrefix(*attr.Name, "custom:") {
+			name := strings.TrimPrefix(*attr.Name, "custom:")
+			attr.Name = &name
+			// カスタム属性は必須にできない
+			attr.Required = nil
+			schemaAttrs = append(schemaAttrs, attr)
+			continue
+		}
+
+		if attr.Required != nil && *attr.Required {
+			schemaAttrs = append(schemaAttrs, attr)
+		}
+	}
+	return schemaAttrs
+}
+
+// ToCreateGroupInput はバックアップしたグループからグループの作成リクエストに変換する
+func ToCreateGroupInput(group *types.GroupType, userPoolID string) *cognito.CreateGroupInput {
+	return &cognito.CreateGroupInput{
+		UserPoolId:  &userPoolID,
+		GroupName:   group.GroupName,
+		Description: group.Description,
+		Precedence:  group.Precedence,
+		RoleArn:     group.RoleArn,
+	}
+}
+
+// ToUpdateGroupInput はバックアップしたグループからグループの更新リクエストに変換する
+func ToUpdateGroupInput(group *types.GroupType, userPoolID string) *cognito.UpdateGroupInput {
+	return &cognito.UpdateGroupInput{
+		UserPoolId:  &userPoolID,
+		GroupName:   group.GroupName,
+		Description: group.Description,
+		Precedence:  group.Precedence,
+		RoleArn:     group.RoleArn,
+	}
+}
+
+// ToCreateUserPoolClientInput はバックアップしたアプリクライアントからアプリクライアントの作成リクエストに変換する
+// クライアントIDとシークレットは新しく発行される
+func ToCreateUserPoolClientInput(client *types.UserPoolClientType, userPoolID string) *cognito.CreateUserPoolClientInput {
+	input := &cognito.CreateUserPoolClientInput{
+		UserPoolId:                               &userPoolID,
+		ClientName:                               client.ClientName,
+		AccessTokenValidity:                      client.AccessTokenValidity,
+		AllowedOAuthFlows:                        client.AllowedOAuthFlows,
+		AllowedOAuthScopes:                       client.AllowedOAuthScopes,
+		AnalyticsConfiguration:                   client.AnalyticsConfiguration,
+		AuthSessionValidity:                      client.AuthSessionValidity,
+		CallbackURLs:                             client.CallbackURLs,
+		DefaultRedirectURI:                       client.DefaultRedirectURI,
+		EnablePropagateAdditionalUserContextData: client.EnablePropagateAdditionalUserContextData,
+		EnableTokenRevocation:                    client.EnableTokenRevocation,
+		ExplicitAuthFlows:                        client.ExplicitAuthFlows,
+		GenerateSecret:                           client.ClientSecret != nil,
+		IdTokenValidity:                          client.IdTokenValidity,
+		LogoutURLs:                               client.LogoutURLs,
+		PreventUserExistenceErrors:               client.PreventUserExistenceErrors,
+		ReadAttributes:                           client.ReadAttributes,
+		RefreshTokenRotation:                     client.RefreshTokenRotation,
+		RefreshTokenValidity:                     client.RefreshTokenValidity,
+		SupportedIdentityProviders:               client.SupportedIdentityProviders,
+		TokenValidityUnits:                       client.TokenValidityUnits,
+		WriteAttributes:                          client.WriteAttributes,
+	}
+	if client.AllowedOAuthFlowsUserPoolClient != nil {
+		input.AllowedOAuthFlowsUserPoolClient = *client.AllowedOAuthFlowsUserPoolClient
+	}
+	return input
+}
+
+// ToUpdateUserPoolClientInput はバックアップしたアプリクライアントから既存のアプリクライアントの更新リクエストに変換する
+func ToUpdateUserPoolClientInput(client *types.UserPoolClientType, userPoolID, clientID string) *cognito.UpdateUserPoolClientInput {
+	input := &cognito.UpdateUserPoolClientInput{
+		UserPoolId:                               &userPoolID,
+		ClientId:                                 &clientID,
+		ClientName:                               client.ClientName,
+		AccessTokenValidity:                      client.AccessTokenValidity,
+		AllowedOAuthFlows:                        client.AllowedOAuthFlows,
+		AllowedOAuthScopes:                       client.AllowedOAuthScopes,
+		AnalyticsConfiguration:                   client.AnalyticsConfiguration,
+		AuthSessionValidity:                      client.AuthSessionValidity,
+		CallbackURLs:                             client.CallbackURLs,
+		DefaultRedirectURI:                       client.DefaultRedirectURI,
+		EnablePropagateAdditionalUserContextData: client.EnablePropagateAdditionalUserContextData,
+		EnableTokenRevocation:                    client.EnableTokenRevocation,
+		ExplicitAuthFlows:                        client.ExplicitAuthFlows,
+		IdTokenValidity:                          client.IdTokenValidity,
+		LogoutURLs:                               client.LogoutURLs,
+		PreventUserExistenceErrors:               client.PreventUserExistenceErrors,
+		ReadAttributes:                           client.ReadAttributes,
+		RefreshTokenRotation:                     client.RefreshTokenRotation,
+		RefreshTokenValidity:                     client.RefreshTokenValidity,
+		SupportedIdentityProviders:               client.SupportedIdentityProviders,
+		TokenValidityUnits:                       client.TokenValidityUnits,
+		WriteAttributes:                          client.WriteAttributes,
+	}
+	if client.AllowedOAuthFlowsUserPoolClient != nil {
+		input.AllowedOAuthFlowsUserPoolClient = *client.AllowedOAuthFlowsUserPoolClient
+	}
+	return input
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"path"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -17,82 +15,40 @@ import (
 // S3Client はS3操作のためのクライアントを表す
 type S3Client struct {
 	client *s3.Client
+	opts   ClientOptions
 }
 
 // NewS3Client は新しいS3Clientを作成する
-func NewS3Client(ctx context.Context) (*S3Client, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func NewS3Client(ctx context.Context, opts ClientOptions) (*S3Client, error) {
+	cfg, err := LoadConfig(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 	}
 
 	return &S3Client{
-		client: s3.NewFromConfig(cfg),
+		client: s3.NewFromConfig(cfg, opts.S3Options),
+		opts:   opts,
 	}, nil
 }
 
-// ListBackups は指定されたパターンに一致するバックアップの一覧を取得する
-func (c *S3Client) ListBackups(ctx context.Context, bucket, prefix, pattern string) ([]string, error) {
-	var backups []string
-	var continuationToken *string
-
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("invalid regex pattern: %w", err)
-	}
-
-	for {
-		input := &s3.ListObjectsV2Input{
-			Bucket:            &bucket,
-			Prefix:            &prefix,
-			ContinuationToken: continuationToken,
-		}
-
-		output, err := c.client.ListObjectsV2(ctx, input)
+func (c *S3Client) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	// リージョンが指定されていない場合はバケットのリージョンに切り替える
+	if c.opts.Region == "" {
+		bucketLocation, err := c.GetBucketLocation(ctx, bucket)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get backup list: %w", err)
+			return nil, err
 		}
 
-		for _, obj := range output.Contents {
-			// metadata.jsonファイルを探す
-			if strings.HasSuffix(*obj.Key, "/metadata.json") {
-				// ユーザープールIDを抽出
-				parts := strings.Split(*obj.Key, "/")
-				if len(parts) >= 2 {
-					userPoolID := parts[len(parts)-2]
-					if regex.MatchString(userPoolID) {
-						backups = append(backups, path.Dir(*obj.Key))
-					}
-				}
+		if c.client.Options().Region != bucketLocation {
+			cfg, err := LoadConfig(ctx, c.opts, config.WithRegion(bucketLocation))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 			}
+			c.client = s3.NewFromConfig(cfg, c.opts.S3Options)
 		}
-
-		if output.IsTruncated == nil || !*output.IsTruncated {
-			break
-		}
-		continuationToken = output.NextContinuationToken
-	}
-
-	return backups, nil
-}
-
-func (c *S3Client) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
-	bucketLocation, err := c.GetBucketLocation(ctx, bucket)
-	if err != nil {
-		return nil, err
-	}
-
-	if c.client.Options().Region != bucketLocation {
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(bucketLocation))
-		if err != nil {
-			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
-		}
-		c.client = s3.NewFromConfig(cfg)
 	}
 
-	fmt.Printf("region: %s, bucketLocation: %s\n", c.client.Options().Region, bucketLocation)
-
-	getObjectOutput, err := c.client.GetObject(context.TODO(), &s3.GetObjectInput{
+	getObjectOutput, err := c.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
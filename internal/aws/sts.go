@@ -0,0 +1,22 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// GetAccountID は認証情報のAWSアカウントIDを取得する
+func GetAccountID(ctx context.Context, opts ClientOptions) (string, error) {
+	cfg, err := LoadConfig(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	output, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return *output.Account, nil
+}
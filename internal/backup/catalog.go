@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// CatalogDir は実行ごとの記録を保存するディレクトリ名
+const CatalogDir = "catalog"
+
+// ManifestKey は今回の実行の記録のキーを返す
+func (l *Layout) ManifestKey() string {
+	return ManifestKey(l.Prefix, l.RunID())
+}
+
+// Record は今回の実行の記録をカタログに保存し、最新のバックアップを指すポインタを更新する
+// 記録は変更しないため、同じ実行IDの記録がすでにある場合はエラーにする
+func (l *Layout) Record(ctx context.Context, store storage.Storage, manifest *types.BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	_, err = store.ReadFile(ctx, l.ManifestKey())
+	if err == nil {
+		return fmt.Errorf("manifest of run %s already exists: %s", manifest.RunID, l.ManifestKey())
+	}
+	if !storage.IsNotExist(err) {
+		return fmt.Errorf("failed to check manifest: %w", err)
+	}
+	if err := store.WriteFile(ctx, l.ManifestKey(), data); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	// 同じ保存先に並行して実行したバックアップが互いの更新を上書きしても、次の実行で元に戻るようカタログから作り直す
+	return RebuildLatest(ctx, store, l.Prefix)
+}
+
+// CatalogKey はprefixにあるカタログのディレクトリのキーを返す
+func CatalogKey(prefix string) string {
+	return cleanKey(path.Join(strings.TrimSuffix(prefix, "/"), CatalogDir), prefix)
+}
+
+// ManifestKey はprefixにある実行の記録のキーを返す
+func ManifestKey(prefix, runID string) string {
+	return cleanKey(path.Join(CatalogKey(prefix), runID+".json"), prefix)
+}
+
+// ReadCatalog はprefixにあるカタログから実行ごとの記録を読み込み、古い順に返す
+func ReadCatalog(ctx context.Context, store storage.Storage, prefix string) ([]types.BackupManifest, error) {
+	keys, err := store.List(ctx, CatalogKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []types.BackupManifest
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+
+		data, err := store.ReadFile(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		var manifest types.BackupManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", key, err)
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Timestamp < manifests[j].Timestamp
+	})
+	return manifests, nil
+}
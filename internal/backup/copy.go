@@ -0,0 +1,492 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// CopySelection はコピーするバックアップの条件を表す
+type CopySelection struct {
+	UserPoolID string    // 空の場合はすべてのユーザープール
+	Since      time.Time // ゼロ値の場合は制限しない
+	Until      time.Time // ゼロ値の場合は制限しない
+	Latest     bool      // ユーザープールごとに最新のバックアップのみをコピーする
+}
+
+// SelectCopies はカタログの記録から条件に合うバックアップを古い順に返す
+// 増分バックアップを復元できるよう、条件に合わない親のバックアップも完全バックアップまで含める
+func SelectCopies(manifests []types.BackupManifest, selection CopySelection) ([]PoolBackup, error) {
+	var all []PoolBackup
+	for _, manifest := range manifests {
+		timestamp, err := time.Parse(time.RFC3339, manifest.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in run %s: %s", manifest.RunID, manifest.Timestamp)
+		}
+		for _, pool := range manifest.Pools {
+			all = append(all, PoolBackup{
+				ManifestPool: pool,
+				RunID:        manifest.RunID,
+				Timestamp:    timestamp,
+			})
+		}
+	}
+
+	index := make(map[string]int)  // "<実行ID>/<ユーザープールID>" -> allでの位置
+	latest := make(map[string]int) // ユーザープールID -> 最新のバックアップのallでの位置
+	for i, b := range all {
+		index[b.RunID+"/"+b.UserPoolID] = i
+		if j, ok := latest[b.UserPoolID]; !ok || !all[j].Timestamp.After(b.Timestamp) {
+			latest[b.UserPoolID] = i
+		}
+	}
+
+	selected := make(map[int]bool)
+	var queue []int
+	for i, b := range all {
+		if selection.UserPoolID != "" && b.UserPoolID != selection.UserPoolID {
+			continue
+		}
+		if (!selection.Since.IsZero() && b.Timestamp.Before(selection.Since)) || (!selection.Until.IsZero() && b.Timestamp.After(selection.Until)) {
+			continue
+		}
+		if selection.Latest && latest[b.UserPoolID] != i {
+			continue
+		}
+		all[i].Reasons = append(all[i].Reasons, "selected")
+		selected[i] = true
+		queue = append(queue, i)
+	}
+
+	// 増分バックアップの親をたどる
+	for len(queue) > 0 {
+		b := all[queue[0]]
+		queue = queue[1:]
+		if b.ParentRunID == "" {
+			continue
+		}
+		i, ok := index[b.ParentRunID+"/"+b.UserPoolID]
+		if !ok {
+			return nil, fmt.Errorf("parent %s of backup %s/%s is not in the catalog", b.ParentRunID, b.RunID, b.UserPoolID)
+		}
+		if selected[i] {
+			continue
+		}
+		all[i].Reasons = append(all[i].Reasons, "parent of "+b.RunID)
+		selected[i] = true
+		queue = append(queue, i)
+	}
+
+	var backups []PoolBackup
+	for i, b := range all {
+		if selected[i] {
+			backups = append(backups, b)
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Timestamp.Before(backups[j].Timestamp)
+	})
+	return backups, nil
+}
+
+// CopyKey はsrcPrefix以下のキーを、dstPrefix以下の同じ相対位置のキーに変換する
+func CopyKey(key, srcPrefix, dstPrefix string) (string, error) {
+	rel := key
+	if prefix := strings.TrimSuffix(srcPrefix, "/"); prefix != "" {
+		var ok bool
+		rel, ok = strings.CutPrefix(key, prefix+"/")
+		if !ok {
+			return "", fmt.Errorf("%s is not under %s", key, srcPrefix)
+		}
+	}
+	return cleanKey(path.Join(dstPrefix, rel), dstPrefix), nil
+}
+
+// Copier はバックアップのアーカイブを別の保存先にコピーし、チェックサムを検証する
+// 再暗号化する場合や、増分バックアップの親のキーが変わる場合は、アーカイブを展開して作り直す
+type Copier struct {
+	src       storage.Storage
+	srcPrefix string
+	dst       storage.Storage
+	dstPrefix string
+
+	decryptor    encryption.Encryptor                             // コピー元のアーカイブの復号化に使用する
+	encryptor    encryption.Encryptor                             // 再暗号化する場合のみ設定する
+	keyID        string                                           // 再暗号化に使用するKMSキーのID
+	keyEncryptor func(keyID string) (encryption.Encryptor, error) // 再暗号化せずに作り直す場合に、元のKMSキーで暗号化するEncryptorを返す
+	copied       map[string]*copiedArchive                        // コピー元のキー -> コピーしたアーカイブ
+}
+
+// copiedArchive はコピーしたアーカイブを表す
+type copiedArchive struct {
+	key    string
+	size   int64
+	sha256 string
+}
+
+// NewCopier はsrcのsrcPrefix以下のバックアップを、dstのdstPrefix以下にコピーする新しいCopierを作成する
+func NewCopier(src storage.Storage, srcPrefix string, dst storage.Storage, dstPrefix string) *Copier {
+	return &Copier{
+		src:       src,
+		srcPrefix: srcPrefix,
+		dst:       dst,
+		dstPrefix: dstPrefix,
+		copied:    make(map[string]*copiedArchive),
+	}
+}
+
+// SetDecryptor はコピー元のアーカイブの復号化に使用するEncryptorを設定する
+func (c *Copier) SetDecryptor(decryptor encryption.Encryptor) {
+	c.decryptor = decryptor
+}
+
+// SetEncryptor は再暗号化に使用するEncryptorとKMSキーのIDを設定する
+// 設定した場合は、すべてのアーカイブを復号化してこのキーで暗号化し直す
+func (c *Copier) SetEncryptor(encryptor encryption.Encryptor, keyID string) {
+	c.encryptor = encryptor
+	c.keyID = keyID
+}
+
+// SetKeyEncryptor は再暗号化せずにアーカイブを作り直す場合に、元のKMSキーで暗号化するEncryptorを返す関数を設定する
+func (c *Copier) SetKeyEncryptor(f func(keyID string) (encryption.Encryptor, error)) {
+	c.keyEncryptor = f
+}
+
+// CopyRun はmanifestのうちuserPoolIDsのユーザープールのバックアップをコピーし、コピー先の記録を返す
+// 複数のユーザープールをまとめたアーカイブは1度だけコピーし、リポジトリのスナップショットはコピー先の記録に含めない
+func (c *Copier) CopyRun(ctx context.Context, manifest types.BackupManifest, userPoolIDs map[string]bool) (*types.BackupManifest, error) {
+	copied := manifest
+	copied.Pools = nil
+	if c.encryptor != nil {
+		copied.KMSKeyID = c.keyID
+	}
+
+	for _, pool := range manifest.Pools {
+		if !userPoolIDs[pool.UserPoolID] {
+			continue
+		}
+		// リポジトリのスナップショットはチャンクを共有するためコピーできない。同じ実行の他のユーザープールはコピーする
+		if repository.IsSnapshotKey(pool.Archive) {
+			continue
+		}
+
+		ar, err := c.copyArchive(ctx, manifest, pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", pool.Archive, err)
+		}
+
+		pool.Archive = ar.key
+		pool.Size = ar.size
+		pool.SHA256 = ar.sha256
+		if pool.Parent != "" {
+			if pool.Parent, err = CopyKey(pool.Parent, c.srcPrefix, c.dstPrefix); err != nil {
+				return nil, err
+			}
+		}
+		copied.Pools = append(copied.Pools, pool)
+	}
+	return &copied, nil
+}
+
+// copyArchive はpoolのアーカイブをコピーする
+func (c *Copier) copyArchive(ctx context.Context, manifest types.BackupManifest, pool types.ManifestPool) (*copiedArchive, error) {
+	if ar, ok := c.copied[pool.Archive]; ok {
+		return ar, nil
+	}
+
+	dstKey, err := CopyKey(pool.Archive, c.srcPrefix, c.dstPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// アーカイブに含まれる増分バックアップのメタデータは親のキーを持つため、キーが変わる場合は書き換える
+	rewrite := false
+	for _, p := range manifest.Pools {
+		if p.Archive == pool.Archive && p.Parent != "" {
+			parent, err := CopyKey(p.Parent, c.srcPrefix, c.dstPrefix)
+			if err != nil {
+				return nil, err
+			}
+			rewrite = rewrite || parent != p.Parent
+		}
+	}
+
+	var ar *copiedArchive
+	if c.encryptor != nil || rewrite {
+		encryptor := c.encryptor
+		if encryptor == nil && manifest.KMSKeyID != "" {
+			if c.keyEncryptor == nil {
+				return nil, fmt.Errorf("re-encryption is required to rewrite the parent of an incremental backup")
+			}
+			if encryptor, err = c.keyEncryptor(manifest.KMSKeyID); err != nil {
+				return nil, err
+			}
+		}
+		ar, err = c.rebuild(ctx, pool, dstKey, encryptor)
+	} else {
+		ar, err = c.transfer(ctx, pool, dstKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// コピー先から読み直して、書き込んだ内容を検証する
+	if err := c.verify(ctx, ar); err != nil {
+		return nil, err
+	}
+	c.copied[pool.Archive] = ar
+	return ar, nil
+}
+
+// transfer はアーカイブをそのままコピーする
+// コピー元のチェックサムが記録と一致しない場合は、コピーを確定しない
+func (c *Copier) transfer(ctx context.Context, pool types.ManifestPool, dstKey string) (*copiedArchive, error) {
+	r, err := c.src.Open(ctx, pool.Archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	w, err := c.dst.Create(ctx, dstKey)
+	if err != nil {
+		return nil, err
+	}
+	digest := archive.NewDigestReader(r)
+	if _, err := io.Copy(w, digest); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := checkSource(pool, digest); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &copiedArchive{
+		key:    dstKey,
+		size:   digest.Size(),
+		sha256: digest.SHA256(),
+	}, nil
+}
+
+// rebuild はアーカイブを展開してencryptorで暗号化し直し、増分バックアップの親のキーを書き換えてコピーする
+// encryptorがnilの場合は暗号化しない。各ファイルのチェックサムはメタデータの記録と照合する
+func (c *Copier) rebuild(ctx context.Context, pool types.ManifestPool, dstKey string, encryptor encryption.Encryptor) (*copiedArchive, error) {
+	r, err := c.src.Open(ctx, pool.Archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	digest := archive.NewDigestReader(r)
+	reader, err := archive.NewReader(ctx, digest, c.decryptor)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	w, err := c.dst.Create(ctx, dstKey)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := archive.NewWriter(ctx, w, encryptor, reader.Codec(), 0)
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := c.rewriteEntries(reader, ar); err != nil {
+		ar.Abort()
+		return nil, err
+	}
+
+	// 圧縮や暗号化の末尾まで読み込んでから、コピー元のチェックサムを検証する
+	if _, err := io.Copy(io.Discard, digest); err != nil {
+		ar.Abort()
+		return nil, err
+	}
+	if err := checkSource(pool, digest); err != nil {
+		ar.Abort()
+		return nil, err
+	}
+	if err := ar.Close(); err != nil {
+		return nil, err
+	}
+
+	return &copiedArchive{
+		key:    dstKey,
+		size:   ar.Size(),
+		sha256: ar.SHA256(),
+	}, nil
+}
+
+// rewriteEntries はreaderのエントリをarにコピーし、メタデータの親のキーをコピー先のキーに書き換える
+func (c *Copier) rewriteEntries(reader *archive.Reader, ar *archive.Writer) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+
+		if path.Base(name) != "metadata.json" {
+			entry, err := ar.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(entry, reader); err != nil {
+				entry.Discard()
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			if err := entry.Close(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// メタデータはユーザープールの最後のエントリのため、ここでユーザープールのファイルを検証する
+		var metadata types.BackupMetadata
+		if err := json.NewDecoder(reader).Decode(&metadata); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		userPoolID := path.Dir(name)
+		for file, expected := range metadata.Checksums {
+			if actual := ar.Checksum(path.Join(userPoolID, file)); actual != expected {
+				return fmt.Errorf("checksum mismatch for %s/%s: expected %s, got %s", userPoolID, file, expected, actual)
+			}
+		}
+		if metadata.Parent != "" {
+			if metadata.Parent, err = CopyKey(metadata.Parent, c.srcPrefix, c.dstPrefix); err != nil {
+				return err
+			}
+		}
+		if err := ar.WriteJSON(name, metadata); err != nil {
+			return err
+		}
+	}
+}
+
+// verify はコピー先のアーカイブを読み直し、サイズとチェックサムを検証する
+func (c *Copier) verify(ctx context.Context, ar *copiedArchive) error {
+	r, err := c.dst.Open(ctx, ar.key)
+	if err != nil {
+		return fmt.Errorf("failed to read copied archive: %w", err)
+	}
+	defer r.Close()
+
+	digest := archive.NewDigestReader(r)
+	if _, err := io.Copy(io.Discard, digest); err != nil {
+		return fmt.Errorf("failed to read copied archive: %w", err)
+	}
+	if digest.Size() != ar.size || digest.SHA256() != ar.sha256 {
+		return fmt.Errorf("copied archive %s does not match: expected %d bytes (sha256 %s), got %d bytes (sha256 %s)", ar.key, ar.size, ar.sha256, digest.Size(), digest.SHA256())
+	}
+	return nil
+}
+
+// checkSource はコピー元から読み込んだアーカイブのサイズとチェックサムを、カタログの記録と照合する
+// 古いバージョンの記録にはチェックサムがないため、その場合は照合しない
+func checkSource(pool types.ManifestPool, digest *archive.DigestReader) error {
+	if pool.SHA256 == "" {
+		return nil
+	}
+	if digest.Size() != pool.Size || digest.SHA256() != pool.SHA256 {
+		return fmt.Errorf("source archive does not match the catalog: expected %d bytes (sha256 %s), got %d bytes (sha256 %s)", pool.Size, pool.SHA256, digest.Size(), digest.SHA256())
+	}
+	return nil
+}
+
+// RecordCopy はコピーしたバックアップの記録をカタログに保存する
+// 同じ実行の記録がすでにある場合は、ユーザープールを追加・更新する
+func RecordCopy(ctx context.Context, store storage.Storage, prefix string, manifest *types.BackupManifest) error {
+	key := ManifestKey(prefix, manifest.RunID)
+	data, err := store.ReadFile(ctx, key)
+	switch {
+	case err == nil:
+		var existing types.BackupManifest
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("failed to parse manifest %s: %w", key, err)
+		}
+		pools := manifest.Pools
+		for _, pool := range existing.Pools {
+			if !hasPool(manifest.Pools, pool.UserPoolID) {
+				pools = append(pools, pool)
+			}
+		}
+		sort.Slice(pools, func(i, j int) bool {
+			return pools[i].UserPoolID < pools[j].UserPoolID
+		})
+		manifest.Pools = pools
+	case !storage.IsNotExist(err):
+		return fmt.Errorf("failed to read manifest %s: %w", key, err)
+	}
+
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := store.WriteFile(ctx, key, data); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	return nil
+}
+
+// RebuildLatest はカタログの記録から、ユーザープールごとに最新のバックアップを指すポインタを作り直す
+// 古いバックアップをコピーしても、最新のバックアップを指すポインタが古いバックアップに戻らないようにする
+func RebuildLatest(ctx context.Context, store storage.Storage, prefix string) error {
+	manifests, err := ReadCatalog(ctx, store, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+	if len(manifests) == 0 {
+		return nil
+	}
+	parents, err := FindParents(manifests)
+	if err != nil {
+		return err
+	}
+
+	last := manifests[len(manifests)-1]
+	latest := types.LatestPointer{
+		RunID:     last.RunID,
+		Timestamp: last.Timestamp,
+		Archives:  make(map[string]string, len(parents)),
+	}
+	for userPoolID, parent := range parents {
+		latest.Archives[userPoolID] = parent.Archive
+	}
+
+	data, err := json.MarshalIndent(latest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", LatestFile, err)
+	}
+	if err := store.WriteFile(ctx, LatestKey(prefix), data); err != nil {
+		return fmt.Errorf("failed to update %s: %w", LatestFile, err)
+	}
+	return nil
+}
+
+// hasPool はpoolsにユーザープールが含まれるかを返す
+func hasPool(pools []types.ManifestPool, userPoolID string) bool {
+	for _, pool := range pools {
+		if pool.UserPoolID == userPoolID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takaishi/acb/pkg/types"
+)
+
+func TestSelectCopies(t *testing.T) {
+	manifests := []types.BackupManifest{
+		testManifest("r1", "2024-01-01T00:00:00Z", fullPool("a", "r1"), fullPool("b", "r1")),
+		testManifest("r2", "2024-01-02T00:00:00Z", incrementalPool("a", "r2", "r1"), fullPool("b", "r2")),
+		testManifest("r3", "2024-01-03T00:00:00Z", incrementalPool("a", "r3", "r2")),
+	}
+
+	// selected は選ばれたバックアップの "<実行ID>/<ユーザープールID>" -> 選ばれた理由 を古い順に並べたもの
+	type selected struct {
+		key     string
+		reasons []string
+	}
+
+	tests := []struct {
+		name      string
+		selection CopySelection
+		want      []selected
+	}{
+		{
+			name: "all backups",
+			want: []selected{
+				{"r1/a", []string{"selected"}},
+				{"r1/b", []string{"selected"}},
+				{"r2/a", []string{"selected"}},
+				{"r2/b", []string{"selected"}},
+				{"r3/a", []string{"selected"}},
+			},
+		},
+		{
+			name:      "user pool",
+			selection: CopySelection{UserPoolID: "b"},
+			want: []selected{
+				{"r1/b", []string{"selected"}},
+				{"r2/b", []string{"selected"}},
+			},
+		},
+		{
+			name:      "latest includes the parents",
+			selection: CopySelection{Latest: true},
+			want: []selected{
+				{"r1/a", []string{"parent of r2"}},
+				{"r2/a", []string{"parent of r3"}},
+				{"r2/b", []string{"selected"}},
+				{"r3/a", []string{"selected"}},
+			},
+		},
+		{
+			name: "since includes the parents before it",
+			selection: CopySelection{
+				UserPoolID: "a",
+				Since:      time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+			want: []selected{
+				{"r1/a", []string{"parent of r2"}},
+				{"r2/a", []string{"parent of r3"}},
+				{"r3/a", []string{"selected"}},
+			},
+		},
+		{
+			name:      "until",
+			selection: CopySelection{Until: time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC)},
+			want: []selected{
+				{"r1/a", []string{"selected"}},
+				{"r1/b", []string{"selected"}},
+			},
+		},
+		{
+			name: "nothing in the range",
+			selection: CopySelection{
+				Since: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backups, err := SelectCopies(manifests, tt.selection)
+			if err != nil {
+				t.Fatalf("SelectCopies() error = %v", err)
+			}
+			var got []selected
+			for _, b := range backups {
+				got = append(got, selected{b.RunID + "/" + b.UserPoolID, b.Reasons})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectCopies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCopiesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifests []types.BackupManifest
+	}{
+		{
+			name: "invalid timestamp",
+			manifests: []types.BackupManifest{
+				testManifest("r1", "yesterday", fullPool("a", "r1")),
+			},
+		},
+		{
+			name: "parent missing from the catalog",
+			manifests: []types.BackupManifest{
+				testManifest("r2", "2024-01-02T00:00:00Z", incrementalPool("a", "r2", "r1")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SelectCopies(tt.manifests, CopySelection{}); err == nil {
+				t.Fatal("SelectCopies() error = nil, want an error")
+			}
+		})
+	}
+}
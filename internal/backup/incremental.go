@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// UserIndexFile は増分バックアップに保存するすべてのユーザーの一覧のファイル名
+const UserIndexFile = "usernames.json"
+
+// Parent は増分バックアップの親になるバックアップを表す
+type Parent struct {
+	RunID     string
+	Archive   string
+	Timestamp time.Time // 親のバックアップの開始日時。これ以降に変更されたユーザーを保存する
+}
+
+// FindParents はカタログの記録から、ユーザープールごとに最新のバックアップを返す
+func FindParents(manifests []types.BackupManifest) (map[string]Parent, error) {
+	parents := make(map[string]Parent)
+	for _, manifest := range manifests {
+		timestamp, err := time.Parse(time.RFC3339, manifest.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in run %s: %s", manifest.RunID, manifest.Timestamp)
+		}
+		for _, pool := range manifest.Pools {
+			if parent, ok := parents[pool.UserPoolID]; ok && parent.Timestamp.After(timestamp) {
+				continue
+			}
+			parents[pool.UserPoolID] = Parent{
+				RunID:     manifest.RunID,
+				Archive:   pool.Archive,
+				Timestamp: timestamp,
+			}
+		}
+	}
+	return parents, nil
+}
+
+// backupChangedUsers はparentの開始日時以降に変更されたユーザーをシャードに、
+// すべてのユーザーの一覧をusernames.jsonに保存し、シャードのユーザー数とファイル名を返す
+// ユーザーごとにグループを取得する代わりに、グループごとの所属ユーザーから対応表を作成する
+func (b *PoolBackupper) backupChangedUsers(ctx context.Context, ar *archive.Writer, userPoolID string, groups []cognitotypes.GroupType, parent Parent) (int, []string, error) {
+	memberships := make(map[string][]string)
+	for _, group := range groups {
+		usernames, err := b.cognitoClient.ListUsersInGroup(ctx, userPoolID, *group.GroupName)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, username := range usernames {
+			memberships[username] = append(memberships[username], *group.GroupName)
+		}
+	}
+
+	indexEntry, err := ar.Create(path.Join(userPoolID, UserIndexFile))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer indexEntry.Discard()
+	index, err := newUserListWriter(indexEntry)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	users := newUserShardWriter(ar, userPoolID, b.usersPerShard)
+	defer users.Discard()
+
+	err = b.cognitoClient.ListUsersPages(ctx, userPoolID, func(page []cognitotypes.UserType) error {
+		for _, user := range page {
+			enabled := user.Enabled
+			if err := index.Write(types.UserIndexEntry{
+				Username: *user.Username,
+				Groups:   memberships[*user.Username],
+				Enabled:  &enabled,
+			}); err != nil {
+				return err
+			}
+
+			// 親のバックアップの開始後に変更されたユーザーは、親に含まれていない可能性があるため保存する
+			if user.UserLastModifiedDate != nil && user.UserLastModifiedDate.Before(parent.Timestamp) {
+				continue
+			}
+			if err := users.Write(aws.ToUserInfo(user, memberships[*user.Username])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := users.Close(); err != nil {
+		return 0, nil, err
+	}
+	if err := index.Close(); err != nil {
+		return 0, nil, err
+	}
+	if err := indexEntry.Close(); err != nil {
+		return 0, nil, err
+	}
+	return users.count, users.files, nil
+}
+
+// userListWriter は {"users":[...]} 形式のJSONを1件ずつ書き出す
+// ユーザー全体をメモリに保持せずに、UserIndexと同じ形式で書き込める
+type userListWriter struct {
+	w     io.Writer
+	count int
+}
+
+// newUserListWriter はwに書き込む新しいuserListWriterを作成する
+func newUserListWriter(w io.Writer) (*userListWriter, error) {
+	if _, err := io.WriteString(w, `{"users":[`); err != nil {
+		return nil, err
+	}
+	return &userListWriter{w: w}, nil
+}
+
+// Write はvをJSONにエンコードし、配列の要素として書き込む
+func (l *userListWriter) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %w", err)
+	}
+
+	separator := ",\n"
+	if l.count == 0 {
+		separator = "\n"
+	}
+	if _, err := io.WriteString(l.w, separator); err != nil {
+		return err
+	}
+	if _, err := l.w.Write(data); err != nil {
+		return err
+	}
+	l.count++
+	return nil
+}
+
+// Close は配列とオブジェクトを閉じる
+func (l *userListWriter) Close() error {
+	_, err := io.WriteString(l.w, "\n]}\n")
+	return err
+}
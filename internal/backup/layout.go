@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// DefaultKeyTemplate はアーカイブのキーのデフォルトのテンプレート
+const DefaultKeyTemplate = "{prefix}/{date}/{time}/{pool_id}{ext}"
+
+// LatestFile は最新のバックアップを指すポインタのファイル名
+const LatestFile = "latest.json"
+
+// Layout はバックアップの保存先でのアーカイブの配置を表す
+//
+// テンプレートでは次のプレースホルダーを使用できる:
+//
+//	{prefix}    保存先URIのパス
+//	{date}      バックアップの開始日 (YYYY-MM-DD)
+//	{time}      バックアップの開始時刻 (HHMMSS)
+//	{run_id}    バックアップの実行ID
+//	{account}   ユーザープールのAWSアカウントID
+//	{region}    ユーザープールのリージョン
+//	{pool_id}   ユーザープールID（含まない場合はすべてのユーザープールを1つのアーカイブにまとめる）
+//	{ext}       圧縮方式に応じたアーカイブの拡張子（例: .tar.gz, .tar.zst）
+type Layout struct {
+	Template  string
+	Prefix    string
+	Account   string
+	Region    string
+	Extension string
+	Time      time.Time
+
+	runID string
+}
+
+// NewLayout は新しいLayoutを作成する
+// 実行ごとに異なるキーになるよう、テンプレートには {run_id} か、{date} と {time} の両方が必要
+// {time} は時刻のみのため、{date} がないと別の日の同じ時刻に実行したバックアップを上書きする
+func NewLayout(template, prefix string, startedAt time.Time) (*Layout, error) {
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+	hasDateTime := strings.Contains(template, "{date}") && strings.Contains(template, "{time}")
+	if !hasDateTime && !strings.Contains(template, "{run_id}") {
+		return nil, fmt.Errorf("key template must contain {run_id} or both {date} and {time} to give each backup a unique name: %s", template)
+	}
+
+	runID, err := newRunID(startedAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Layout{
+		Template:  template,
+		Prefix:    strings.TrimSuffix(prefix, "/"),
+		Extension: archive.Gzip.Extension(),
+		Time:      startedAt.UTC(),
+		runID:     runID,
+	}, nil
+}
+
+// newRunID は開始日時とランダムな値から実行IDを作成する
+// 同じ秒に開始した別の実行と記録が衝突しないよう、秒単位の開始日時にランダムな値を付ける
+func newRunID(startedAt time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	return startedAt.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// RunID はバックアップの実行IDを返す
+func (l *Layout) RunID() string {
+	return l.runID
+}
+
+// PerPool はユーザープールごとにアーカイブを作成するかを返す
+func (l *Layout) PerPool() bool {
+	return strings.Contains(l.Template, "{pool_id}")
+}
+
+// NeedsAccount はテンプレートがAWSアカウントIDを使用するかを返す
+func (l *Layout) NeedsAccount() bool {
+	return strings.Contains(l.Template, "{account}")
+}
+
+// Key はユーザープールのアーカイブのキーを返す
+func (l *Layout) Key(userPoolID string) string {
+	region := l.Region
+	if userPoolID != "" {
+		region = aws.RegionFromUserPoolID(userPoolID)
+	}
+
+	replacer := strings.NewReplacer(
+		"{prefix}", l.Prefix,
+		"{date}", l.Time.Format("2006-01-02"),
+		"{time}", l.Time.Format("150405"),
+		"{run_id}", l.RunID(),
+		"{account}", l.Account,
+		"{region}", region,
+		"{pool_id}", userPoolID,
+		"{ext}", l.Extension,
+	)
+	return cleanKey(replacer.Replace(l.Template), l.Prefix)
+}
+
+// LatestKey は最新のバックアップを指すポインタのキーを返す
+func (l *Layout) LatestKey() string {
+	return LatestKey(l.Prefix)
+}
+
+// LatestKey はprefixにある最新のバックアップを指すポインタのキーを返す
+func LatestKey(prefix string) string {
+	return cleanKey(path.Join(prefix, LatestFile), prefix)
+}
+
+// ReadLatest は最新のバックアップを指すポインタを読み込む
+func ReadLatest(ctx context.Context, store storage.Storage, key string) (*types.LatestPointer, error) {
+	data, err := store.ReadFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest types.LatestPointer
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+	}
+	return &latest, nil
+}
+
+// cleanKey は空のプレースホルダーによる重複したスラッシュを取り除く
+// S3のキーはスラッシュで始まらないため、prefixが相対パスの場合は先頭のスラッシュも取り除く
+func cleanKey(key, prefix string) string {
+	key = path.Clean(key)
+	if !strings.HasPrefix(prefix, "/") {
+		key = strings.TrimPrefix(key, "/")
+	}
+	return key
+}
@@ -2,11 +2,19 @@ package backup
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path"
+	"sort"
 	"sync"
 	"time"
 
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/archive"
 	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
 	"github.com/takaishi/acb/internal/storage"
 	"github.com/takaishi/acb/pkg/types"
 )
@@ -15,40 +23,143 @@ import (
 type PoolBackupper struct {
 	cognitoClient *aws.CognitoClient
 	storage       storage.Storage
+	layout        *Layout
+	encryptor     encryption.Encryptor
+	codec         archive.Codec
+	level         int
+	parents       map[string]Parent // 増分バックアップの親（ユーザープールID -> 親のバックアップ）
+	repository    *repository.Repository
+	usersPerShard int
 }
 
 // NewPoolBackupper は新しいPoolBackupperを作成する
-func NewPoolBackupper(cognitoClient *aws.CognitoClient, storage storage.Storage) *PoolBackupper {
+func NewPoolBackupper(cognitoClient *aws.CognitoClient, storage storage.Storage, layout *Layout) *PoolBackupper {
 	return &PoolBackupper{
 		cognitoClient: cognitoClient,
 		storage:       storage,
+		layout:        layout,
+		codec:         archive.Gzip,
+		usersPerShard: DefaultUsersPerShard,
 	}
 }
 
-// BackupPools は指定されたパターンに一致するユーザープールをバックアップする
-func (b *PoolBackupper) BackupPools(ctx context.Context, pattern, prefix string) error {
+// SetEncryptor はアーカイブの暗号化処理を設定する
+func (b *PoolBackupper) SetEncryptor(encryptor encryption.Encryptor) {
+	b.encryptor = encryptor
+}
+
+// SetCompression はアーカイブの圧縮方式と圧縮レベルを設定する
+// levelが0の場合は圧縮方式の既定の圧縮レベルを使用する
+func (b *PoolBackupper) SetCompression(codec archive.Codec, level int) {
+	b.codec = codec
+	b.level = level
+}
+
+// SetUsersPerShard はユーザー情報の1つのシャードに保存する最大ユーザー数を設定する
+func (b *PoolBackupper) SetUsersPerShard(users int) {
+	b.usersPerShard = users
+}
+
+// SetParents は増分バックアップの親を設定する
+// 親が設定されたユーザープールは、親のバックアップ以降に変更されたユーザーのみを保存する
+func (b *PoolBackupper) SetParents(parents map[string]Parent) {
+	b.parents = parents
+}
+
+// SetRepository はバックアップの保存先のリポジトリを設定する
+// リポジトリが設定された場合は、アーカイブの代わりにチャンクとスナップショットとしてリポジトリに保存する
+func (b *PoolBackupper) SetRepository(repo *repository.Repository) {
+	b.repository = repo
+}
+
+// BackupPools は指定されたパターンに一致するユーザープールをバックアップし、
+// バックアップに成功したユーザープールの記録を返す
+// 一部のユーザープールが失敗した場合も、成功したユーザープールの記録とエラーを返す
+func (b *PoolBackupper) BackupPools(ctx context.Context, pattern string) (*types.BackupManifest, error) {
 	// ユーザープールの一覧を取得
 	pools, err := b.cognitoClient.ListUserPools(ctx, pattern)
 	if err != nil {
-		return fmt.Errorf("failed to get user pool list: %w", err)
+		return nil, fmt.Errorf("failed to get user pool list: %w", err)
 	}
 
 	if len(pools) == 0 {
-		return fmt.Errorf("no user pools found matching pattern: %s", pattern)
+		return nil, fmt.Errorf("no user pools found matching pattern: %s", pattern)
+	}
+
+	// リポジトリの場合は、すべてのユーザープールを1つのスナップショットにまとめる
+	var snapshot *types.Snapshot
+	if b.repository != nil {
+		snapshot = repository.NewSnapshot(b.layout.RunID(), b.layout.Time.Format(time.RFC3339))
+
+		// スナップショットを保存するまで、再利用するチャンクがGCで削除されないようロックする
+		lock, err := b.repository.LockBackup(ctx, b.layout.RunID())
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Release(context.WithoutCancel(ctx))
+	}
+
+	// テンプレートにユーザープールIDを含まない場合は1つのアーカイブにまとめる
+	var shared *archive.Writer
+	if snapshot == nil && !b.layout.PerPool() {
+		shared, err = b.createArchive(ctx, b.layout.Key(""))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// 並行処理用のエラーチャネル
 	errCh := make(chan error, len(pools))
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	manifest := &types.BackupManifest{
+		RunID:       b.layout.RunID(),
+		Timestamp:   b.layout.Time.Format(time.RFC3339),
+		Compression: string(b.codec),
+	}
+	if snapshot != nil {
+		manifest.Compression = b.repository.Compression()
+	}
 
 	// 各ユーザープールを並行してバックアップ
 	for _, pool := range pools {
 		wg.Add(1)
 		go func(pool string) {
 			defer wg.Done()
-			if err := b.backupSinglePool(ctx, pool, prefix); err != nil {
+
+			record := types.ManifestPool{
+				UserPoolID: pool,
+				Archive:    b.layout.Key(pool),
+			}
+			if parent, ok := b.parents[pool]; ok {
+				record.Parent = parent.Archive
+				record.ParentRunID = parent.RunID
+			}
+			var snapshotPool *types.SnapshotPool
+			var err error
+			switch {
+			case snapshot != nil:
+				record.Archive = repository.SnapshotKey(b.layout.Prefix, snapshot.RunID)
+				snapshotPool, err = b.backupToRepository(ctx, pool)
+				if err == nil {
+					record.Users = snapshotPool.Users
+				}
+			case shared != nil:
+				record.Users, err = b.backupSinglePool(ctx, shared, pool)
+			default:
+				err = b.backupToArchive(ctx, &record)
+			}
+			if err != nil {
 				errCh <- fmt.Errorf("failed to backup user pool %s: %w", pool, err)
+				return
 			}
+
+			mu.Lock()
+			manifest.Pools = append(manifest.Pools, record)
+			if snapshotPool != nil {
+				snapshot.Pools = append(snapshot.Pools, *snapshotPool)
+			}
+			mu.Unlock()
 		}(*pool.Id)
 	}
 
@@ -62,75 +173,205 @@ func (b *PoolBackupper) BackupPools(ctx context.Context, pattern, prefix string)
 		errors = append(errors, err)
 	}
 
+	if shared != nil {
+		// 一部のユーザープールが欠けたアーカイブは残さない
+		if len(errors) > 0 {
+			shared.Abort()
+			return nil, fmt.Errorf("%d errors occurred during backup: %v", len(errors), errors)
+		}
+		if err := shared.Close(); err != nil {
+			return nil, fmt.Errorf("failed to save backup: %w", err)
+		}
+		for i := range manifest.Pools {
+			manifest.Pools[i].Size = shared.Size()
+			manifest.Pools[i].SHA256 = shared.SHA256()
+		}
+	}
+
+	// 成功したユーザープールのみをスナップショットに記録する
+	// 失敗したユーザープールのチャンクは、どのスナップショットからも参照されないためGCで削除される
+	if snapshot != nil && len(snapshot.Pools) > 0 {
+		_, data, err := b.repository.SaveSnapshot(ctx, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		for i := range manifest.Pools {
+			manifest.Pools[i].Size = int64(len(data))
+			manifest.Pools[i].SHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+
+	sort.Slice(manifest.Pools, func(i, j int) bool {
+		return manifest.Pools[i].UserPoolID < manifest.Pools[j].UserPoolID
+	})
+
 	if len(errors) > 0 {
-		return fmt.Errorf("%d errors occurred during backup: %v", len(errors), errors)
+		return manifest, fmt.Errorf("%d errors occurred during backup: %v", len(errors), errors)
 	}
 
+	return manifest, nil
+}
+
+// backupToArchive は単一のユーザープールをrecordのアーカイブにバックアップし、recordにアーカイブの情報を記録する
+func (b *PoolBackupper) backupToArchive(ctx context.Context, record *types.ManifestPool) error {
+	ar, err := b.createArchive(ctx, record.Archive)
+	if err != nil {
+		return err
+	}
+
+	record.Users, err = b.backupSinglePool(ctx, ar, record.UserPoolID)
+	if err != nil {
+		ar.Abort()
+		return err
+	}
+
+	if err := ar.Close(); err != nil {
+		return fmt.Errorf("failed to save backup: %w", err)
+	}
+	record.Size = ar.Size()
+	record.SHA256 = ar.SHA256()
 	return nil
 }
 
-// backupSinglePool は単一のユーザープールをバックアップする
-func (b *PoolBackupper) backupSinglePool(ctx context.Context, userPoolID, prefix string) error {
+// backupToRepository は単一のユーザープールをリポジトリに保存し、スナップショットに記録するユーザープールを返す
+func (b *PoolBackupper) backupToRepository(ctx context.Context, userPoolID string) (*types.SnapshotPool, error) {
+	w, pool := b.repository.NewPoolWriter(ctx, userPoolID)
+	users, err := b.backupSinglePool(ctx, w, userPoolID)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to save backup: %w", err)
+	}
+	pool.Users = users
+	return pool, nil
+}
+
+// createArchive はkeyに書き込むアーカイブを作成する
+func (b *PoolBackupper) createArchive(ctx context.Context, key string) (*archive.Writer, error) {
+	dest, err := b.storage.Create(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	ar, err := archive.NewWriter(ctx, dest, b.encryptor, b.codec, b.level)
+	if err != nil {
+		dest.Abort()
+		return nil, err
+	}
+	return ar, nil
+}
+
+// backupSinglePool は単一のユーザープールをバックアップし、ユーザー数を返す
+func (b *PoolBackupper) backupSinglePool(ctx context.Context, ar *archive.Writer, userPoolID string) (int, error) {
 	// プール設定の取得
 	poolConfig, err := b.cognitoClient.GetUserPoolConfiguration(ctx, userPoolID)
 	if err != nil {
-		return fmt.Errorf("failed to get user pool configuration: %w", err)
+		return 0, fmt.Errorf("failed to get user pool configuration: %w", err)
 	}
 
-	// ユーザー一覧の取得
-	users, err := b.cognitoClient.ListUsers(ctx, userPoolID)
+	// グループの取得
+	groups, err := b.cognitoClient.ListGroups(ctx, userPoolID)
 	if err != nil {
-		return fmt.Errorf("failed to get user list: %w", err)
+		return 0, fmt.Errorf("failed to get group list: %w", err)
 	}
 
-	// ユーザー情報の詳細を取得
-	var usersBackup types.UsersBackup
-	for _, user := range users {
-		groups, err := b.cognitoClient.ListUserGroups(ctx, userPoolID, *user.Username)
-		if err != nil {
-			return fmt.Errorf("failed to get user groups: %w", err)
-		}
+	// アプリクライアントの取得
+	clients, err := b.cognitoClient.ListUserPoolClients(ctx, userPoolID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user pool client list: %w", err)
+	}
 
-		userInfo := types.UserInfo{
-			Username: *user.Username,
-			Groups:   groups,
-		}
+	// 外部IDプロバイダーの取得
+	providers, err := b.cognitoClient.ListIdentityProviders(ctx, userPoolID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get identity provider list: %w", err)
+	}
 
-		// 属性の変換
-		attributes := make([]map[string]interface{}, len(user.Attributes))
-		for i, attr := range user.Attributes {
-			attributes[i] = map[string]interface{}{
-				"Name":  *attr.Name,
-				"Value": *attr.Value,
-			}
-		}
-		userInfo.Attributes = attributes
+	// アーカイブへの保存
+	if err := ar.WriteJSON(path.Join(userPoolID, "pool-config.json"), poolConfig.UserPool); err != nil {
+		return 0, fmt.Errorf("failed to save pool configuration: %w", err)
+	}
 
-		usersBackup.Users = append(usersBackup.Users, userInfo)
+	if err := ar.WriteJSON(path.Join(userPoolID, "groups.json"), types.GroupsBackup{Groups: groups}); err != nil {
+		return 0, fmt.Errorf("failed to save groups: %w", err)
+	}
+
+	if err := ar.WriteJSON(path.Join(userPoolID, "clients.json"), types.NewClientsBackup(clients)); err != nil {
+		return 0, fmt.Errorf("failed to save clients: %w", err)
+	}
+
+	if err := ar.WriteJSON(path.Join(userPoolID, "identity-providers.json"), types.IdentityProvidersBackup{IdentityProviders: providers}); err != nil {
+		return 0, fmt.Errorf("failed to save identity providers: %w", err)
+	}
+
+	files := []string{"pool-config.json", "groups.json", "clients.json", "identity-providers.json"}
+	parent, incremental := b.parents[userPoolID]
+	var users int
+	var shards []string
+	if incremental {
+		users, shards, err = b.backupChangedUsers(ctx, ar, userPoolID, groups, parent)
+		files = append(files, UserIndexFile)
+	} else {
+		users, shards, err = b.backupUsers(ctx, ar, userPoolID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to save user information: %w", err)
 	}
+	files = append(files, shards...)
 
 	// メタデータの作成
+	// 各ファイルのチェックサムとユーザー数を記録するため、メタデータは最後に保存する
 	metadata := types.BackupMetadata{
 		Version:       "1.0",
+		RunID:         b.layout.RunID(),
 		Timestamp:     time.Now().UTC().Format(time.RFC3339),
-		SourceAccount: "", // TODO: Get from session
-		SourceRegion:  "", // TODO: Get from session
+		SourceAccount: b.layout.Account,
+		SourceRegion:  aws.RegionFromUserPoolID(userPoolID),
 		UserPoolID:    userPoolID,
-		BackupFiles:   []string{"pool-config.json", "users.json"},
+		BackupFiles:   files,
+		Users:         users,
+		Checksums:     make(map[string]string),
 	}
-
-	// ストレージへの保存
-	if err := b.storage.SaveJSON(ctx, prefix, userPoolID, "metadata.json", metadata); err != nil {
-		return fmt.Errorf("failed to save metadata: %w", err)
+	if incremental {
+		metadata.Parent = parent.Archive
+		metadata.ParentRunID = parent.RunID
+		metadata.Since = parent.Timestamp.Format(time.RFC3339)
+	}
+	for _, file := range metadata.BackupFiles {
+		metadata.Checksums[file] = ar.Checksum(path.Join(userPoolID, file))
 	}
 
-	if err := b.storage.SaveJSON(ctx, prefix, userPoolID, "pool-config.json", poolConfig.UserPool); err != nil {
-		return fmt.Errorf("failed to save pool configuration: %w", err)
+	if err := ar.WriteJSON(path.Join(userPoolID, "metadata.json"), metadata); err != nil {
+		return 0, fmt.Errorf("failed to save metadata: %w", err)
 	}
 
-	if err := b.storage.SaveJSON(ctx, prefix, userPoolID, "users.json", usersBackup); err != nil {
-		return fmt.Errorf("failed to save user information: %w", err)
+	return users, nil
+}
+
+// backupUsers はユーザー情報をページ単位で取得してシャードに保存し、ユーザー数とシャードのファイル名を返す
+func (b *PoolBackupper) backupUsers(ctx context.Context, ar *archive.Writer, userPoolID string) (int, []string, error) {
+	users := newUserShardWriter(ar, userPoolID, b.usersPerShard)
+	err := b.cognitoClient.ListUsersPages(ctx, userPoolID, func(page []cognitotypes.UserType) error {
+		for _, user := range page {
+			groups, err := b.cognitoClient.ListUserGroups(ctx, userPoolID, *user.Username)
+			if err != nil {
+				return fmt.Errorf("failed to get user groups: %w", err)
+			}
+			if err := users.Write(aws.ToUserInfo(user, groups)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		users.Discard()
+		return 0, nil, err
 	}
 
-	return nil
+	if err := users.Close(); err != nil {
+		return 0, nil, err
+	}
+	return users.count, users.files, nil
 }
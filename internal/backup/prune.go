@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// RetentionPolicy はユーザープールごとのバックアップの保持ポリシーを表す
+type RetentionPolicy struct {
+	Daily   int           // 直近の日ごとに残すバックアップ数
+	Weekly  int           // 直近の週ごとに残すバックアップ数
+	Monthly int           // 直近の月ごとに残すバックアップ数
+	Within  time.Duration // この期間内のバックアップはすべて残す
+}
+
+// IsZero は保持ポリシーが指定されていないかを返す
+func (p RetentionPolicy) IsZero() bool {
+	return p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 && p.Within == 0
+}
+
+// PoolBackup はカタログに記録された単一のユーザープールのバックアップを表す
+type PoolBackup struct {
+	types.ManifestPool
+	RunID     string
+	Timestamp time.Time
+	Reasons   []string // 残す理由
+}
+
+// PrunePlan は保持ポリシーに基づいて残すバックアップと削除するバックアップを表す
+type PrunePlan struct {
+	Keep   []PoolBackup
+	Delete []PoolBackup
+}
+
+// PlanPrune はカタログの記録から、ユーザープールごとに保持ポリシーに基づいて削除するバックアップを決める
+// ユーザープールの最新のバックアップは保持ポリシーにかかわらず残す
+func PlanPrune(manifests []types.BackupManifest, policy RetentionPolicy, now time.Time) (*PrunePlan, error) {
+	// ユーザープールごとにバックアップをまとめる
+	byPool := make(map[string][]PoolBackup)
+	for _, manifest := range manifests {
+		timestamp, err := time.Parse(time.RFC3339, manifest.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in run %s: %s", manifest.RunID, manifest.Timestamp)
+		}
+		for _, pool := range manifest.Pools {
+			byPool[pool.UserPoolID] = append(byPool[pool.UserPoolID], PoolBackup{
+				ManifestPool: pool,
+				RunID:        manifest.RunID,
+				Timestamp:    timestamp,
+			})
+		}
+	}
+
+	userPoolIDs := make([]string, 0, len(byPool))
+	for userPoolID := range byPool {
+		userPoolIDs = append(userPoolIDs, userPoolID)
+	}
+	sort.Strings(userPoolIDs)
+
+	plan := &PrunePlan{}
+	for _, userPoolID := range userPoolIDs {
+		backups := byPool[userPoolID]
+		// 新しい順に並べる
+		sort.Slice(backups, func(i, j int) bool {
+			return backups[i].Timestamp.After(backups[j].Timestamp)
+		})
+
+		buckets := []struct {
+			name   string
+			count  int
+			period func(time.Time) string
+		}{
+			{"daily", policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+			{"weekly", policy.Weekly, func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-%02d", year, week)
+			}},
+			{"monthly", policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		}
+		lastPeriods := make([]string, len(buckets))
+
+		for i, b := range backups {
+			if i == 0 {
+				b.Reasons = append(b.Reasons, "latest")
+			}
+			if policy.Within > 0 && now.Sub(b.Timestamp) <= policy.Within {
+				b.Reasons = append(b.Reasons, "within")
+			}
+			// 各期間で最も新しいバックアップを、指定された期間の数だけ残す
+			for j := range buckets {
+				period := buckets[j].period(b.Timestamp.UTC())
+				if buckets[j].count > 0 && period != lastPeriods[j] {
+					b.Reasons = append(b.Reasons, buckets[j].name)
+					buckets[j].count--
+					lastPeriods[j] = period
+				}
+			}
+
+			if len(b.Reasons) > 0 {
+				plan.Keep = append(plan.Keep, b)
+			} else {
+				plan.Delete = append(plan.Delete, b)
+			}
+		}
+	}
+
+	plan.keepParents()
+	return plan, nil
+}
+
+// keepParents は残す増分バックアップが参照する親のバックアップを、削除せずに残すように変更する
+// 増分バックアップの復元には、完全バックアップまでのすべての親が必要になる
+func (p *PrunePlan) keepParents() {
+	deleting := make(map[string]int) // "<実行ID>/<ユーザープールID>" -> Deleteでの位置
+	for i, b := range p.Delete {
+		deleting[b.RunID+"/"+b.UserPoolID] = i
+	}
+
+	kept := make(map[int]bool)
+	queue := append([]PoolBackup(nil), p.Keep...)
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if b.ParentRunID == "" {
+			continue
+		}
+		i, ok := deleting[b.ParentRunID+"/"+b.UserPoolID]
+		if !ok || kept[i] {
+			continue
+		}
+		kept[i] = true
+		p.Delete[i].Reasons = append(p.Delete[i].Reasons, "parent of "+b.RunID)
+		queue = append(queue, p.Delete[i])
+	}
+
+	var deletes []PoolBackup
+	for i, b := range p.Delete {
+		if kept[i] {
+			p.Keep = append(p.Keep, b)
+		} else {
+			deletes = append(deletes, b)
+		}
+	}
+	p.Delete = deletes
+}
+
+// Prune は計画に従ってバックアップを削除し、カタログから取り除く
+// 複数のユーザープールをまとめたアーカイブは、残すバックアップが含まれない場合にのみ削除する
+func Prune(ctx context.Context, store storage.Storage, prefix string, manifests []types.BackupManifest, plan *PrunePlan) error {
+	deleted := make(map[string]bool) // "<実行ID>/<ユーザープールID>"
+	for _, b := range plan.Delete {
+		deleted[b.RunID+"/"+b.UserPoolID] = true
+	}
+	kept := make(map[string]bool) // アーカイブのキー
+	for _, b := range plan.Keep {
+		kept[b.Archive] = true
+	}
+	removed := make(map[string]bool)
+
+	for _, manifest := range manifests {
+		var pools []types.ManifestPool
+		for _, pool := range manifest.Pools {
+			if deleted[manifest.RunID+"/"+pool.UserPoolID] {
+				continue
+			}
+			pools = append(pools, pool)
+		}
+		if len(pools) == len(manifest.Pools) {
+			continue
+		}
+
+		// アーカイブを削除
+		for _, pool := range manifest.Pools {
+			if !deleted[manifest.RunID+"/"+pool.UserPoolID] || kept[pool.Archive] || removed[pool.Archive] {
+				continue
+			}
+			if err := store.Delete(ctx, pool.Archive); err != nil && !storage.IsNotExist(err) {
+				return fmt.Errorf("failed to delete %s: %w", pool.Archive, err)
+			}
+			removed[pool.Archive] = true
+		}
+
+		// カタログを更新
+		key := ManifestKey(prefix, manifest.RunID)
+		if len(pools) == 0 {
+			if err := store.Delete(ctx, key); err != nil && !storage.IsNotExist(err) {
+				return fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+			continue
+		}
+		manifest.Pools = pools
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest: %w", err)
+		}
+		if err := store.WriteFile(ctx, key, data); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ParseRetention は保持期間を解析する
+// time.ParseDurationの単位に加えて、日 (d) と週 (w) を指定できる（例: 30d, 2w, 72h）
+func ParseRetention(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration: %s", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return d, nil
+}
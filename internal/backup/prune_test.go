@@ -0,0 +1,352 @@
+package backup
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// testManifest はテスト用のカタログの記録を作成する
+func testManifest(runID, timestamp string, pools ...types.ManifestPool) types.BackupManifest {
+	return types.BackupManifest{RunID: runID, Timestamp: timestamp, Pools: pools}
+}
+
+// fullPool は完全バックアップの記録を作成する
+func fullPool(userPoolID, runID string) types.ManifestPool {
+	return types.ManifestPool{UserPoolID: userPoolID, Archive: "backups/" + runID + ".tar.gz"}
+}
+
+// incrementalPool は親を持つ増分バックアップの記録を作成する
+func incrementalPool(userPoolID, runID, parentRunID string) types.ManifestPool {
+	pool := fullPool(userPoolID, runID)
+	pool.Parent = "backups/" + parentRunID + ".tar.gz"
+	pool.ParentRunID = parentRunID
+	return pool
+}
+
+// keptReasons は残すバックアップを "<実行ID>/<ユーザープールID>" -> 残す理由 の対応表にする
+func keptReasons(backups []PoolBackup) map[string][]string {
+	kept := make(map[string][]string, len(backups))
+	for _, b := range backups {
+		kept[b.RunID+"/"+b.UserPoolID] = b.Reasons
+	}
+	return kept
+}
+
+// backupKeys はバックアップの "<実行ID>/<ユーザープールID>" を並べて返す
+func backupKeys(backups []PoolBackup) []string {
+	var keys []string
+	for _, b := range backups {
+		keys = append(keys, b.RunID+"/"+b.UserPoolID)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPlanPrune(t *testing.T) {
+	// 日をまたぐバックアップ（同じ日に2回実行している）
+	daily := []types.BackupManifest{
+		testManifest("d1a", "2024-01-01T10:00:00Z", fullPool("pool", "d1a")),
+		testManifest("d1b", "2024-01-01T20:00:00Z", fullPool("pool", "d1b")),
+		testManifest("d2", "2024-01-02T10:00:00Z", fullPool("pool", "d2")),
+		testManifest("d3", "2024-01-03T10:00:00Z", fullPool("pool", "d3")),
+	}
+
+	tests := []struct {
+		name      string
+		manifests []types.BackupManifest
+		policy    RetentionPolicy
+		now       time.Time
+		keep      map[string][]string
+		delete    []string
+	}{
+		{
+			name:      "latest is kept without a policy",
+			manifests: daily,
+			keep: map[string][]string{
+				"d3/pool": {"latest"},
+			},
+			delete: []string{"d1a/pool", "d1b/pool", "d2/pool"},
+		},
+		{
+			name:      "daily keeps the newest backup of each day",
+			manifests: daily,
+			policy:    RetentionPolicy{Daily: 2},
+			keep: map[string][]string{
+				"d3/pool": {"latest", "daily"},
+				"d2/pool": {"daily"},
+			},
+			delete: []string{"d1a/pool", "d1b/pool"},
+		},
+		{
+			name:      "daily does not keep two backups of the same day",
+			manifests: daily,
+			policy:    RetentionPolicy{Daily: 5},
+			keep: map[string][]string{
+				"d3/pool":  {"latest", "daily"},
+				"d2/pool":  {"daily"},
+				"d1b/pool": {"daily"},
+			},
+			delete: []string{"d1a/pool"},
+		},
+		{
+			name: "daily buckets by UTC date",
+			manifests: []types.BackupManifest{
+				testManifest("utc", "2024-01-01T10:00:00Z", fullPool("pool", "utc")),
+				// 2024-01-01T23:00:00Z
+				testManifest("jst", "2024-01-02T08:00:00+09:00", fullPool("pool", "jst")),
+			},
+			policy: RetentionPolicy{Daily: 2},
+			keep: map[string][]string{
+				"jst/pool": {"latest", "daily"},
+			},
+			delete: []string{"utc/pool"},
+		},
+		{
+			name: "weekly buckets by ISO week",
+			manifests: []types.BackupManifest{
+				testManifest("w1", "2024-01-03T00:00:00Z", fullPool("pool", "w1")),
+				testManifest("w2a", "2024-01-08T00:00:00Z", fullPool("pool", "w2a")),
+				testManifest("w2b", "2024-01-10T00:00:00Z", fullPool("pool", "w2b")),
+				testManifest("w3", "2024-01-15T00:00:00Z", fullPool("pool", "w3")),
+			},
+			policy: RetentionPolicy{Weekly: 2},
+			keep: map[string][]string{
+				"w3/pool":  {"latest", "weekly"},
+				"w2b/pool": {"weekly"},
+			},
+			delete: []string{"w1/pool", "w2a/pool"},
+		},
+		{
+			name: "weekly treats an ISO week across years as one week",
+			manifests: []types.BackupManifest{
+				// 2024-W52
+				testManifest("sat", "2024-12-28T00:00:00Z", fullPool("pool", "sat")),
+				// 2025-W01
+				testManifest("mon", "2024-12-30T00:00:00Z", fullPool("pool", "mon")),
+				testManifest("thu", "2025-01-02T00:00:00Z", fullPool("pool", "thu")),
+			},
+			policy: RetentionPolicy{Weekly: 2},
+			keep: map[string][]string{
+				"thu/pool": {"latest", "weekly"},
+				"sat/pool": {"weekly"},
+			},
+			delete: []string{"mon/pool"},
+		},
+		{
+			name: "monthly keeps the newest backup of each month",
+			manifests: []types.BackupManifest{
+				testManifest("jan", "2024-01-31T00:00:00Z", fullPool("pool", "jan")),
+				testManifest("feb1", "2024-02-01T00:00:00Z", fullPool("pool", "feb1")),
+				testManifest("feb20", "2024-02-20T00:00:00Z", fullPool("pool", "feb20")),
+				testManifest("mar", "2024-03-05T00:00:00Z", fullPool("pool", "mar")),
+			},
+			policy: RetentionPolicy{Monthly: 2},
+			keep: map[string][]string{
+				"mar/pool":   {"latest", "monthly"},
+				"feb20/pool": {"monthly"},
+			},
+			delete: []string{"feb1/pool", "jan/pool"},
+		},
+		{
+			name: "buckets are counted independently",
+			manifests: []types.BackupManifest{
+				testManifest("jan5", "2024-01-05T00:00:00Z", fullPool("pool", "jan5")),
+				testManifest("jan20", "2024-01-20T00:00:00Z", fullPool("pool", "jan20")),
+				testManifest("feb", "2024-02-10T00:00:00Z", fullPool("pool", "feb")),
+			},
+			policy: RetentionPolicy{Daily: 1, Weekly: 1, Monthly: 2},
+			keep: map[string][]string{
+				"feb/pool":   {"latest", "daily", "weekly", "monthly"},
+				"jan20/pool": {"monthly"},
+			},
+			delete: []string{"jan5/pool"},
+		},
+		{
+			name: "within includes the boundary",
+			manifests: []types.BackupManifest{
+				testManifest("old", "2024-01-08T00:00:00Z", fullPool("pool", "old")),
+				testManifest("edge", "2024-01-08T12:00:00Z", fullPool("pool", "edge")),
+				testManifest("new", "2024-01-10T00:00:00Z", fullPool("pool", "new")),
+			},
+			policy: RetentionPolicy{Within: 48 * time.Hour},
+			now:    time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC),
+			keep: map[string][]string{
+				"new/pool":  {"latest", "within"},
+				"edge/pool": {"within"},
+			},
+			delete: []string{"old/pool"},
+		},
+		{
+			name: "user pools are planned independently",
+			manifests: []types.BackupManifest{
+				testManifest("r1", "2024-01-01T00:00:00Z", fullPool("a", "r1"), fullPool("b", "r1")),
+				testManifest("r2", "2024-01-02T00:00:00Z", fullPool("a", "r2")),
+			},
+			policy: RetentionPolicy{Daily: 1},
+			keep: map[string][]string{
+				"r2/a": {"latest", "daily"},
+				"r1/b": {"latest", "daily"},
+			},
+			delete: []string{"r1/a"},
+		},
+		{
+			name: "parents of a kept incremental backup are kept",
+			manifests: []types.BackupManifest{
+				testManifest("r1", "2024-01-01T00:00:00Z", fullPool("pool", "r1")),
+				testManifest("r2", "2024-01-02T00:00:00Z", incrementalPool("pool", "r2", "r1")),
+				testManifest("r3", "2024-01-03T00:00:00Z", incrementalPool("pool", "r3", "r2")),
+			},
+			policy: RetentionPolicy{Daily: 1},
+			keep: map[string][]string{
+				"r3/pool": {"latest", "daily"},
+				"r2/pool": {"parent of r3"},
+				"r1/pool": {"parent of r2"},
+			},
+		},
+		{
+			name: "chains that are not referenced are deleted",
+			manifests: []types.BackupManifest{
+				testManifest("r1", "2024-01-01T00:00:00Z", fullPool("pool", "r1")),
+				testManifest("r2", "2024-01-02T00:00:00Z", incrementalPool("pool", "r2", "r1")),
+				testManifest("r3", "2024-01-03T00:00:00Z", fullPool("pool", "r3")),
+				testManifest("r4", "2024-01-04T00:00:00Z", incrementalPool("pool", "r4", "r3")),
+			},
+			policy: RetentionPolicy{Daily: 1},
+			keep: map[string][]string{
+				"r4/pool": {"latest", "daily"},
+				"r3/pool": {"parent of r4"},
+			},
+			delete: []string{"r1/pool", "r2/pool"},
+		},
+		{
+			name: "parents are looked up within the same user pool",
+			manifests: []types.BackupManifest{
+				testManifest("r1", "2024-01-01T00:00:00Z", fullPool("a", "r1"), fullPool("b", "r1")),
+				testManifest("r2", "2024-01-02T00:00:00Z", incrementalPool("a", "r2", "r1"), fullPool("b", "r2")),
+			},
+			policy: RetentionPolicy{Daily: 1},
+			keep: map[string][]string{
+				"r2/a": {"latest", "daily"},
+				"r1/a": {"parent of r2"},
+				"r2/b": {"latest", "daily"},
+			},
+			delete: []string{"r1/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := PlanPrune(tt.manifests, tt.policy, tt.now)
+			if err != nil {
+				t.Fatalf("PlanPrune() error = %v", err)
+			}
+			if got := keptReasons(plan.Keep); !reflect.DeepEqual(got, tt.keep) {
+				t.Errorf("keep = %v, want %v", got, tt.keep)
+			}
+			if got := backupKeys(plan.Delete); !reflect.DeepEqual(got, tt.delete) {
+				t.Errorf("delete = %v, want %v", got, tt.delete)
+			}
+		})
+	}
+}
+
+func TestPlanPruneInvalidTimestamp(t *testing.T) {
+	manifests := []types.BackupManifest{
+		testManifest("r1", "2024-01-01", fullPool("pool", "r1")),
+	}
+	if _, err := PlanPrune(manifests, RetentionPolicy{Daily: 1}, time.Time{}); err == nil {
+		t.Fatal("PlanPrune() error = nil, want an error")
+	}
+}
+
+func TestKeepParents(t *testing.T) {
+	backup := func(runID string, pool types.ManifestPool, reasons ...string) PoolBackup {
+		return PoolBackup{ManifestPool: pool, RunID: runID, Reasons: reasons}
+	}
+
+	tests := []struct {
+		name   string
+		plan   PrunePlan
+		keep   map[string][]string
+		delete []string
+	}{
+		{
+			name: "nothing to move",
+			plan: PrunePlan{
+				Keep:   []PoolBackup{backup("r2", fullPool("pool", "r2"), "latest")},
+				Delete: []PoolBackup{backup("r1", fullPool("pool", "r1"))},
+			},
+			keep:   map[string][]string{"r2/pool": {"latest"}},
+			delete: []string{"r1/pool"},
+		},
+		{
+			name: "parent already kept",
+			plan: PrunePlan{
+				Keep: []PoolBackup{
+					backup("r2", incrementalPool("pool", "r2", "r1"), "latest"),
+					backup("r1", fullPool("pool", "r1"), "daily"),
+				},
+			},
+			keep: map[string][]string{
+				"r2/pool": {"latest"},
+				"r1/pool": {"daily"},
+			},
+		},
+		{
+			name: "parent missing from the catalog",
+			plan: PrunePlan{
+				Keep: []PoolBackup{backup("r2", incrementalPool("pool", "r2", "r1"), "latest")},
+			},
+			keep: map[string][]string{"r2/pool": {"latest"}},
+		},
+		{
+			name: "shared parent is moved once",
+			plan: PrunePlan{
+				Keep: []PoolBackup{
+					backup("r3", incrementalPool("pool", "r3", "r1"), "latest"),
+					backup("r2", incrementalPool("pool", "r2", "r1"), "daily"),
+				},
+				Delete: []PoolBackup{backup("r1", fullPool("pool", "r1"))},
+			},
+			keep: map[string][]string{
+				"r3/pool": {"latest"},
+				"r2/pool": {"daily"},
+				"r1/pool": {"parent of r3"},
+			},
+		},
+		{
+			name: "whole chain is moved",
+			plan: PrunePlan{
+				Keep: []PoolBackup{backup("r3", incrementalPool("pool", "r3", "r2"), "latest")},
+				Delete: []PoolBackup{
+					backup("r2", incrementalPool("pool", "r2", "r1")),
+					backup("r1", fullPool("pool", "r1")),
+					backup("r0", fullPool("pool", "r0")),
+				},
+			},
+			keep: map[string][]string{
+				"r3/pool": {"latest"},
+				"r2/pool": {"parent of r3"},
+				"r1/pool": {"parent of r2"},
+			},
+			delete: []string{"r0/pool"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := tt.plan
+			plan.keepParents()
+			if got := keptReasons(plan.Keep); !reflect.DeepEqual(got, tt.keep) {
+				t.Errorf("keep = %v, want %v", got, tt.keep)
+			}
+			if got := backupKeys(plan.Delete); !reflect.DeepEqual(got, tt.delete) {
+				t.Errorf("delete = %v, want %v", got, tt.delete)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// DefaultUsersPerShard はユーザー情報の1つのシャードに保存する既定の最大ユーザー数
+const DefaultUsersPerShard = 10000
+
+// userShardWriter はユーザー情報を1行1件のJSONとして、最大ユーザー数ごとにシャードに分けてアーカイブに書き込む
+// 読み込み側は1行ずつデコードできるため、ユーザー全体をメモリに展開せずに済む
+type userShardWriter struct {
+	ar         *archive.Writer
+	userPoolID string
+	limit      int
+
+	entry *archive.Entry // 書き込み中のシャード
+	files []string       // 作成したシャードのファイル名
+	users int            // 書き込み中のシャードのユーザー数
+	count int            // すべてのシャードのユーザー数
+}
+
+// newUserShardWriter はユーザープールのシャードをarに書き込む新しいuserShardWriterを作成する
+func newUserShardWriter(ar *archive.Writer, userPoolID string, limit int) *userShardWriter {
+	if limit <= 0 {
+		limit = DefaultUsersPerShard
+	}
+	return &userShardWriter{
+		ar:         ar,
+		userPoolID: userPoolID,
+		limit:      limit,
+	}
+}
+
+// Write はユーザーを1行書き込む。シャードが最大ユーザー数に達した場合は次のシャードを作成する
+func (w *userShardWriter) Write(user types.UserInfo) error {
+	if w.entry == nil || w.users >= w.limit {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %w", err)
+	}
+	if _, err := w.entry.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	w.users++
+	w.count++
+	return nil
+}
+
+// next は書き込み中のシャードをアーカイブに追加し、次のシャードを作成する
+func (w *userShardWriter) next() error {
+	if err := w.closeEntry(); err != nil {
+		return err
+	}
+
+	file := types.UserShardFile(len(w.files) + 1)
+	entry, err := w.ar.Create(path.Join(w.userPoolID, file))
+	if err != nil {
+		return err
+	}
+	w.entry = entry
+	w.files = append(w.files, file)
+	w.users = 0
+	return nil
+}
+
+// closeEntry は書き込み中のシャードをアーカイブに追加する
+func (w *userShardWriter) closeEntry() error {
+	if w.entry == nil {
+		return nil
+	}
+	entry := w.entry
+	w.entry = nil
+	return entry.Close()
+}
+
+// Close は書き込み中のシャードをアーカイブに追加する
+// ユーザーがいない場合も、バックアップの形式を判別できるよう空のシャードを1つ作成する
+func (w *userShardWriter) Close() error {
+	if len(w.files) == 0 {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	return w.closeEntry()
+}
+
+// Discard は書き込み中のシャードをアーカイブに追加せずに破棄する
+func (w *userShardWriter) Discard() {
+	if w.entry != nil {
+		w.entry.Discard()
+		w.entry = nil
+	}
+}
@@ -49,6 +49,49 @@ func LoadConfig() (*Config, error) {
 	}, nil
 }
 
+// MigrationConfig はユーザー移行トリガーの設定を表す
+type MigrationConfig struct {
+	BackupURI          string // 移行元ユーザープールのバックアップのURI
+	SourceUserPoolID   string // 移行元のユーザープールID
+	SourceClientID     string // 移行元で認証に使用するアプリクライアントID
+	SourceClientSecret string // 移行元のアプリクライアントのシークレット
+	SourceRoleARN      string // 移行元のユーザープールを操作する際にAssumeRoleするロールのARN
+	SourceExternalID   string // AssumeRole時の外部ID
+	LegacySubAttribute string // 移行元のsubを保存するカスタム属性名
+	KMS                KMSConfig
+}
+
+// LoadMigrationConfig は環境変数からユーザー移行トリガーの設定を読み込む
+func LoadMigrationConfig() (*MigrationConfig, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	migrationConfig := &MigrationConfig{
+		BackupURI:          os.Getenv("ACB_BACKUP_URI"),
+		SourceUserPoolID:   os.Getenv("ACB_SOURCE_USER_POOL_ID"),
+		SourceClientID:     os.Getenv("ACB_SOURCE_CLIENT_ID"),
+		SourceClientSecret: os.Getenv("ACB_SOURCE_CLIENT_SECRET"),
+		SourceRoleARN:      os.Getenv("ACB_SOURCE_ROLE_ARN"),
+		SourceExternalID:   os.Getenv("ACB_SOURCE_EXTERNAL_ID"),
+		LegacySubAttribute: os.Getenv("ACB_LEGACY_SUB_ATTRIBUTE"),
+		KMS:                cfg.KMS,
+	}
+
+	if migrationConfig.BackupURI == "" {
+		return nil, fmt.Errorf("ACB_BACKUP_URI is not set")
+	}
+	if migrationConfig.SourceUserPoolID == "" {
+		return nil, fmt.Errorf("ACB_SOURCE_USER_POOL_ID is not set")
+	}
+	if migrationConfig.SourceClientID == "" {
+		return nil, fmt.Errorf("ACB_SOURCE_CLIENT_ID is not set")
+	}
+
+	return migrationConfig, nil
+}
+
 // ValidateAWSCredentials はAWS認証情報が有効かどうかを確認する
 func ValidateAWSCredentials(ctx context.Context) error {
 	_, err := config.LoadDefaultConfig(ctx)
@@ -0,0 +1,312 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/restore"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// VolatileFields はユーザープールの設定、グループ、アプリクライアント、外部IDプロバイダーで、変更がなくても値が変わるため比較しないフィールド名
+var VolatileFields = []string{"LastModifiedDate", "CreationDate", "EstimatedNumberOfUsers"}
+
+// ignoredItemFields はグループ、アプリクライアント、外部IDプロバイダーで比較しないフィールド名
+// ユーザープールIDは比較するユーザープールごとに異なり、クライアントシークレットは出力に含めない
+var ignoredItemFields = append([]string{"UserPoolId", "ClientSecret", "client_secret"}, VolatileFields...)
+
+// poolIdentityFields は別のユーザープールどうしを比較する場合に比較しない、ユーザープールの設定のフィールド名
+// ID、ARN、ドメインはユーザープールごとに異なるため、復元先と復元元を比較すると常に差分になる
+var poolIdentityFields = []string{"Id", "Arn", "Domain", "CustomDomain"}
+
+// itemIdentityFields は別のユーザープールどうしを比較する場合に比較しない、グループ、アプリクライアント、外部IDプロバイダーのフィールド名
+// クライアントIDは復元時に新しく割り当てられる
+var itemIdentityFields = []string{"ClientId"}
+
+// Result はユーザープールの2つの状態の差分を表す
+type Result struct {
+	A                 string   `json:"a"` // 比較元のユーザープールID
+	B                 string   `json:"b"` // 比較先のユーザープールID
+	Config            []Change `json:"config"`
+	Groups            Items    `json:"groups"`
+	Clients           Items    `json:"clients"`
+	IdentityProviders Items    `json:"identity_providers"`
+	Users             Items    `json:"users"`
+}
+
+// Items は名前で識別する要素の集合の差分を表す
+type Items struct {
+	Added     []string     `json:"added"`
+	Removed   []string     `json:"removed"`
+	Changed   []ItemChange `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+}
+
+// ItemChange は要素の変更を表す
+type ItemChange struct {
+	Name    string   `json:"name"`
+	Changes []Change `json:"changes"`
+}
+
+// HasChanges は差分があるかを返す
+func (r *Result) HasChanges() bool {
+	return len(r.Config) > 0 || r.Groups.HasChanges() || r.Clients.HasChanges() || r.IdentityProviders.HasChanges() || r.Users.HasChanges()
+}
+
+// HasChanges は要素の追加、削除、変更があるかを返す
+func (i *Items) HasChanges() bool {
+	return len(i.Added) > 0 || len(i.Removed) > 0 || len(i.Changed) > 0
+}
+
+// Pools はaとbのユーザープールの設定、グループ、アプリクライアント、外部IDプロバイダー、ユーザーを比較する
+// aのユーザーは比較のため正規化したJSONとしてメモリに保持し、bのユーザーは1件ずつ比較する
+func Pools(ctx context.Context, a, b restore.Source) (*Result, error) {
+	result, err := Settings(ctx, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Groups, err = groups(ctx, a, b, samePool(a, b)); err != nil {
+		return nil, err
+	}
+	if result.Users, err = users(ctx, a, b, samePool(a, b)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Settings はaとbのユーザープールの設定、アプリクライアント、外部IDプロバイダーを比較する
+// グループとユーザーは比較しない。別のユーザープールどうしの場合はユーザープールのID、ARN、ドメインを比較しない
+func Settings(ctx context.Context, a, b restore.Source) (*Result, error) {
+	result := &Result{
+		A: a.UserPoolID(),
+		B: b.UserPoolID(),
+	}
+
+	configA, err := a.PoolConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configB, err := b.PoolConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ignore := VolatileFields
+	if !samePool(a, b) {
+		ignore = append(append([]string(nil), VolatileFields...), poolIdentityFields...)
+	}
+	if result.Config, err = Values(configA, configB, ignore...); err != nil {
+		return nil, err
+	}
+
+	if result.Clients, err = clients(ctx, a, b, samePool(a, b)); err != nil {
+		return nil, err
+	}
+	if result.IdentityProviders, err = identityProviders(ctx, a, b, samePool(a, b)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// samePool はaとbが同じユーザープールかを返す
+func samePool(a, b restore.Source) bool {
+	return a.UserPoolID() == b.UserPoolID()
+}
+
+// groups はグループをグループ名で対応づけて比較する
+func groups(ctx context.Context, a, b restore.Source, same bool) (Items, error) {
+	groupsA, err := a.Groups(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	groupsB, err := b.Groups(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	return compareItems(groupsByName(groupsA), groupsByName(groupsB), same)
+}
+
+// clients はアプリクライアントをクライアント名で対応づけて比較する
+// 別のユーザープールに復元したクライアントはIDが変わるため、IDではなく名前で対応づける
+func clients(ctx context.Context, a, b restore.Source, same bool) (Items, error) {
+	clientsA, err := a.Clients(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	clientsB, err := b.Clients(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	return compareItems(clientsByName(clientsA), clientsByName(clientsB), same)
+}
+
+// identityProviders は外部IDプロバイダーをプロバイダー名で対応づけて比較する
+func identityProviders(ctx context.Context, a, b restore.Source, same bool) (Items, error) {
+	providersA, err := a.IdentityProviders(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	providersB, err := b.IdentityProviders(ctx)
+	if err != nil {
+		return Items{}, err
+	}
+	return compareItems(providersByName(providersA), providersByName(providersB), same)
+}
+
+// groupsByName はグループをグループ名で引ける対応表を作成する
+func groupsByName(groups []types.GroupType) map[string]interface{} {
+	m := make(map[string]interface{}, len(groups))
+	for _, group := range groups {
+		if group.GroupName != nil {
+			m[*group.GroupName] = group
+		}
+	}
+	return m
+}
+
+// clientsByName はアプリクライアントをクライアント名で引ける対応表を作成する
+func clientsByName(clients []types.UserPoolClientType) map[string]interface{} {
+	m := make(map[string]interface{}, len(clients))
+	for _, client := range clients {
+		if client.ClientName != nil {
+			m[*client.ClientName] = client
+		}
+	}
+	return m
+}
+
+// providersByName は外部IDプロバイダーをプロバイダー名で引ける対応表を作成する
+func providersByName(providers []types.IdentityProviderType) map[string]interface{} {
+	m := make(map[string]interface{}, len(providers))
+	for _, provider := range providers {
+		if provider.ProviderName != nil {
+			m[*provider.ProviderName] = provider
+		}
+	}
+	return m
+}
+
+// compareItems は名前で対応づけた要素を比較する
+// sameがfalseの場合は、別のユーザープールどうしで常に異なるフィールドを比較しない
+func compareItems(a, b map[string]interface{}, same bool) (Items, error) {
+	ignore := ignoredItemFields
+	if !same {
+		ignore = append(append([]string(nil), ignoredItemFields...), itemIdentityFields...)
+	}
+
+	var items Items
+	for name, itemA := range a {
+		itemB, ok := b[name]
+		if !ok {
+			items.Removed = append(items.Removed, name)
+			continue
+		}
+		changes, err := Values(itemA, itemB, ignore...)
+		if err != nil {
+			return Items{}, err
+		}
+		if len(changes) == 0 {
+			items.Unchanged++
+			continue
+		}
+		items.Changed = append(items.Changed, ItemChange{Name: name, Changes: changes})
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			items.Added = append(items.Added, name)
+		}
+	}
+	items.sort()
+	return items, nil
+}
+
+// users はユーザーをユーザー名で対応づけて比較する
+// 別のユーザープールどうしの場合は、sameがfalseでユーザープールごとに割り当てられる値を比較しない
+func users(ctx context.Context, a, b restore.Source, same bool) (Items, error) {
+	usersA := make(map[string][]byte)
+	err := a.Users(ctx, func(user *pkgtypes.UserInfo) error {
+		data, err := json.Marshal(normalizeUser(user, same))
+		if err != nil {
+			return fmt.Errorf("failed to encode user: %w", err)
+		}
+		usersA[user.Username] = data
+		return nil
+	})
+	if err != nil {
+		return Items{}, err
+	}
+
+	var items Items
+	err = b.Users(ctx, func(user *pkgtypes.UserInfo) error {
+		data, ok := usersA[user.Username]
+		if !ok {
+			items.Added = append(items.Added, user.Username)
+			return nil
+		}
+		delete(usersA, user.Username)
+
+		var before interface{}
+		if err := json.Unmarshal(data, &before); err != nil {
+			return fmt.Errorf("failed to decode user: %w", err)
+		}
+		changes, err := Values(before, normalizeUser(user, same))
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			items.Unchanged++
+			return nil
+		}
+		items.Changed = append(items.Changed, ItemChange{Name: user.Username, Changes: changes})
+		return nil
+	})
+	if err != nil {
+		return Items{}, err
+	}
+	for username := range usersA {
+		items.Removed = append(items.Removed, username)
+	}
+	items.sort()
+	return items, nil
+}
+
+// normalizeUser は比較のためにユーザーを正規化する
+// 属性は名前で引けるようにし、グループは順番を揃える。最終更新日時は変更のたびに変わるため比較しない
+// sameがfalseの場合は、復元時に割り当て直されるsub属性と作成日時を含めない
+func normalizeUser(user *pkgtypes.UserInfo, same bool) map[string]interface{} {
+	attributes := make(map[string]interface{}, len(user.Attributes))
+	for _, attr := range user.Attributes {
+		if name, ok := attr["Name"].(string); ok {
+			if name == "sub" && !same {
+				continue
+			}
+			attributes[name] = attr["Value"]
+		}
+	}
+	groups := append([]string(nil), user.Groups...)
+	sort.Strings(groups)
+
+	normalized := map[string]interface{}{
+		"attributes":   attributes,
+		"groups":       groups,
+		"enabled":      user.IsEnabled(),
+		"status":       user.UserStatus,
+		"mfa_settings": user.MFASettings,
+	}
+	if user.UserCreateDate != nil && same {
+		normalized["create_date"] = user.UserCreateDate
+	}
+	return normalized
+}
+
+// sort は要素を名前の順に並べる
+func (i *Items) sort() {
+	sort.Strings(i.Added)
+	sort.Strings(i.Removed)
+	sort.Slice(i.Changed, func(x, y int) bool {
+		return i.Changed[x].Name < i.Changed[y].Name
+	})
+}
@@ -0,0 +1,157 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change はフィールドの値の変更を表す
+// 追加されたフィールドはOld、削除されたフィールドはNewがnilになる
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// keyFields はオブジェクトの配列で、要素を識別するフィールド名の候補
+// 順番が変わっただけの要素を変更として報告しないよう、位置ではなくこのフィールドの値で要素を対応づける
+var keyFields = []string{"Name", "AttributeName", "ProviderName", "GroupName", "ClientName", "ClientId"}
+
+// Values はaとbをJSONの値として比較し、変更されたフィールドをフィールド名の順に返す
+// ignoreに含まれるフィールド名は、どの階層にあっても比較しない
+// nullと空の配列は、フィールドがない場合と同じとみなす
+func Values(a, b interface{}, ignore ...string) ([]Change, error) {
+	skip := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		skip[name] = true
+	}
+
+	before, err := flattenValue(a, skip)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flattenValue(b, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool, len(before)+len(after))
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, field := range names {
+		if reflect.DeepEqual(before[field], after[field]) {
+			continue
+		}
+		changes = append(changes, Change{
+			Field: field,
+			Old:   before[field],
+			New:   after[field],
+		})
+	}
+	return changes, nil
+}
+
+// flattenValue はvをJSONとして解釈し、フィールドのパス -> 値 の対応表に変換する
+func flattenValue(v interface{}, skip map[string]bool) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+
+	out := make(map[string]interface{})
+	flatten("", generic, skip, out)
+	return out, nil
+}
+
+// flatten はvをprefix以下のパスに展開してoutに追加する
+// オブジェクトは "a.b"、識別できるオブジェクトの配列は "a[名前]"、それ以外のオブジェクトの配列は "a[0]" で表し、
+// スカラー値の配列は配列全体を1つの値として扱う
+func flatten(prefix string, v interface{}, skip map[string]bool, out map[string]interface{}) {
+	switch value := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, child := range value {
+			if skip[key] {
+				continue
+			}
+			flatten(joinField(prefix, key), child, skip, out)
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			return
+		}
+		if key, ok := elementKey(value); ok {
+			for _, element := range value {
+				name := element.(map[string]interface{})[key].(string)
+				flatten(fmt.Sprintf("%s[%s]", prefix, name), element, skip, out)
+			}
+			return
+		}
+		if hasObject(value) {
+			for i, element := range value {
+				flatten(fmt.Sprintf("%s[%d]", prefix, i), element, skip, out)
+			}
+			return
+		}
+		out[prefix] = value
+	default:
+		out[prefix] = value
+	}
+}
+
+// elementKey はオブジェクトの配列の要素を識別するフィールド名を返す
+func elementKey(elements []interface{}) (string, bool) {
+	for _, key := range keyFields {
+		seen := make(map[string]bool, len(elements))
+		ok := true
+		for _, element := range elements {
+			object, isObject := element.(map[string]interface{})
+			name, isString := object[key].(string)
+			if !isObject || !isString || seen[name] {
+				ok = false
+				break
+			}
+			seen[name] = true
+		}
+		if ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// hasObject は配列にオブジェクトか配列が含まれるかを返す
+func hasObject(elements []interface{}) bool {
+	for _, element := range elements {
+		switch element.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// joinField はフィールドのパスをつなげる
+func joinField(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+// object はテスト用のJSONオブジェクト
+type object = map[string]interface{}
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   interface{}
+		ignore []string
+		want   []Change
+	}{
+		{
+			name: "equal",
+			a:    object{"a": 1, "b": "x"},
+			b:    object{"b": "x", "a": 1},
+		},
+		{
+			name: "changed, added and removed fields in field order",
+			a:    object{"c": "old", "a": 1},
+			b:    object{"c": "new", "b": true},
+			want: []Change{
+				{Field: "a", Old: float64(1)},
+				{Field: "b", New: true},
+				{Field: "c", Old: "old", New: "new"},
+			},
+		},
+		{
+			name: "nested objects",
+			a:    object{"a": object{"b": object{"c": "x"}}},
+			b:    object{"a": object{"b": object{"c": "y"}}},
+			want: []Change{{Field: "a.b.c", Old: "x", New: "y"}},
+		},
+		{
+			name:   "ignored fields at any depth",
+			a:      object{"Date": 1, "a": object{"Date": 1, "b": "x"}},
+			b:      object{"Date": 2, "a": object{"Date": 2, "b": "x"}},
+			ignore: []string{"Date"},
+		},
+		{
+			name: "null and empty arrays equal missing fields",
+			a:    object{"a": nil, "b": []interface{}{}},
+			b:    object{},
+		},
+		{
+			name: "keyed arrays ignore the order",
+			a: object{"attrs": []interface{}{
+				object{"Name": "email", "Value": "a@example.com"},
+				object{"Name": "phone", "Value": "1"},
+			}},
+			b: object{"attrs": []interface{}{
+				object{"Name": "phone", "Value": "2"},
+				object{"Name": "email", "Value": "a@example.com"},
+			}},
+			want: []Change{{Field: "attrs[phone].Value", Old: "1", New: "2"}},
+		},
+		{
+			name: "keyed array elements added",
+			a:    object{"groups": []interface{}{object{"GroupName": "admin"}}},
+			b:    object{"groups": []interface{}{object{"GroupName": "admin"}, object{"GroupName": "dev"}}},
+			want: []Change{{Field: "groups[dev].GroupName", New: "dev"}},
+		},
+		{
+			name: "duplicate names fall back to positions",
+			a:    object{"a": []interface{}{object{"Name": "x", "v": 1}, object{"Name": "x", "v": 2}}},
+			b:    object{"a": []interface{}{object{"Name": "x", "v": 1}, object{"Name": "x", "v": 3}}},
+			want: []Change{{Field: "a[1].v", Old: float64(2), New: float64(3)}},
+		},
+		{
+			name: "scalar arrays are compared as a whole",
+			a:    object{"a": []string{"x", "y"}},
+			b:    object{"a": []string{"y", "x"}},
+			want: []Change{{Field: "a", Old: []interface{}{"x", "y"}, New: []interface{}{"y", "x"}}},
+		},
+		{
+			name: "structs are compared by their JSON fields",
+			a: struct {
+				Name  string `json:"name"`
+				Count int    `json:"count,omitempty"`
+			}{Name: "x"},
+			b: struct {
+				Name  string `json:"name"`
+				Count int    `json:"count,omitempty"`
+			}{Name: "x", Count: 2},
+			want: []Change{{Field: "count", New: float64(2)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Values(tt.a, tt.b, tt.ignore...)
+			if err != nil {
+				t.Fatalf("Values() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValuesUnencodable(t *testing.T) {
+	if _, err := Values(object{"a": make(chan int)}, object{}); err == nil {
+		t.Fatal("Values() error = nil, want an error")
+	}
+}
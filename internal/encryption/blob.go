@@ -0,0 +1,85 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// 個別に暗号化したデータのフォーマット:
+//
+//	| 4 bytes | 12 bytes | 残り              |
+//	| マジック | ノンス    | 暗号化データ+タグ |
+//
+// データキーはKMSで一度だけ復号化し、多数の小さなデータの暗号化に使い回す
+// 追加認証データに保存先の名前を使用するため、データの入れ替えを検出できる
+
+// BlobMagic はデータキーで個別に暗号化したデータの先頭のバイト列
+var BlobMagic = []byte("ACB\x03")
+
+// blobIDContext はデータキーからIDの計算に使用する鍵を導出するための文字列
+const blobIDContext = "acb repository blob id"
+
+// BlobCipher は平文のデータキーで小さなデータを個別に暗号化する
+type BlobCipher struct {
+	aead  cipher.AEAD
+	idKey []byte
+}
+
+// NewBlobCipher は平文のデータキーから新しいBlobCipherを作成する
+func NewBlobCipher(plaintextKey []byte) (*BlobCipher, error) {
+	aead, err := newAEAD(plaintextKey)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, plaintextKey)
+	mac.Write([]byte(blobIDContext))
+	return &BlobCipher{
+		aead:  aead,
+		idKey: mac.Sum(nil),
+	}, nil
+}
+
+// ID はデータの内容から決まるIDを返す
+// データキーを知らなければ内容からIDを推測できないよう、HMAC-SHA256を使用する
+func (c *BlobCipher) ID(data []byte) string {
+	mac := hmac.New(sha256.New, c.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal はdataを暗号化する。nameは復号化するときに同じ値を指定する
+func (c *BlobCipher) Seal(name string, data []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("IVの生成に失敗しました: %w", err)
+	}
+
+	out := make([]byte, 0, len(BlobMagic)+len(nonce)+len(data)+c.aead.Overhead())
+	out = append(out, BlobMagic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, data, []byte(name)), nil
+}
+
+// Open はSealで暗号化したデータを復号化する
+func (c *BlobCipher) Open(name string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, BlobMagic) {
+		return nil, fmt.Errorf("データが個別に暗号化された形式ではありません")
+	}
+	data = data[len(BlobMagic):]
+
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("data is too short: %d bytes", len(data))
+	}
+	plaintext, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("データの復号化に失敗しました: %w", err)
+	}
+	return plaintext, nil
+}
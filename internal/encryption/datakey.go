@@ -0,0 +1,43 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseDataKey はデータキーファイルの内容から暗号化されたデータキーを取り出す
+// generate-datakey が出力するJSON形式とBase64形式のほか、バイナリのままのデータキーに対応する
+func ParseDataKey(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	// JSON形式
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var dataKey struct {
+			EncryptedDataKey string `json:"encrypted_data_key"`
+		}
+		if err := json.Unmarshal(trimmed, &dataKey); err != nil {
+			return nil, fmt.Errorf("failed to parse data key file: %w", err)
+		}
+		if dataKey.EncryptedDataKey == "" {
+			return nil, fmt.Errorf("encrypted_data_key is not found in data key file")
+		}
+		return base64.StdEncoding.DecodeString(dataKey.EncryptedDataKey)
+	}
+
+	// Base64形式（コメント行を除いた最初の行が暗号化されたデータキー）
+	if bytes.HasPrefix(trimmed, []byte("#")) {
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			return base64.StdEncoding.DecodeString(line)
+		}
+		return nil, fmt.Errorf("encrypted data key is not found in data key file")
+	}
+
+	return data, nil
+}
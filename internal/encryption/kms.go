@@ -8,8 +8,8 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	acbaws "github.com/takaishi/acb/internal/aws"
 )
 
 // EncryptedData は暗号化されたデータを表す
@@ -26,14 +26,16 @@ type KMSEncryptor struct {
 }
 
 // NewKMSEncryptor は新しいKMSEncryptorインスタンスを作成する
-func NewKMSEncryptor(ctx context.Context, keyID string, region string) (*KMSEncryptor, error) {
-	fmt.Printf("region: %s\n", region)
-	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+func NewKMSEncryptor(ctx context.Context, keyID string, region string, opts acbaws.ClientOptions) (*KMSEncryptor, error) {
+	cfg, err := acbaws.LoadConfig(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
 	}
 	client := kms.NewFromConfig(cfg, func(o *kms.Options) {
 		o.Region = region
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = opts.BaseEndpoint()
+		}
 	})
 
 	return &KMSEncryptor{
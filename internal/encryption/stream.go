@@ -0,0 +1,295 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// ストリーミング形式のフォーマット:
+//
+//	| 4 bytes | 4 bytes    | N bytes  | 12 bytes | チャンク... |
+//	| マジック | 暗号化DK長 | 暗号化DK | ノンス    |             |
+//
+// 各チャンク:
+//
+//	| 1 byte | 4 bytes | M bytes          |
+//	| フラグ  | 長さ    | 暗号化データ+タグ |
+//
+// チャンクのノンスはヘッダーのノンスにチャンク番号をXORしたもので、
+// フラグ（最終チャンクは1）を追加認証データとするため、チャンクの入れ替えや切り詰めを検出できる
+const (
+	streamChunkSize = 64 * 1024
+	chunkFlagMore   = 0
+	chunkFlagFinal  = 1
+)
+
+// StreamMagic はストリーミング形式で暗号化されたデータの先頭のバイト列
+var StreamMagic = []byte("ACB\x02")
+
+// IsStream はデータの先頭がストリーミング形式の暗号化データかを判定する
+func IsStream(header []byte) bool {
+	return bytes.HasPrefix(header, StreamMagic)
+}
+
+// EncryptStream はwに暗号化したデータを書き込むライターを返す
+// Closeで最終チャンクが書き込まれる
+func (e *KMSEncryptor) EncryptStream(ctx context.Context, w io.Writer) (io.WriteCloser, error) {
+	plaintextKey, encryptedKey, err := e.DataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(plaintextKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("IVの生成に失敗しました: %w", err)
+	}
+
+	// ヘッダーを書き込み
+	var header bytes.Buffer
+	header.Write(StreamMagic)
+	binary.Write(&header, binary.LittleEndian, uint32(len(encryptedKey)))
+	header.Write(encryptedKey)
+	header.Write(nonce)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+
+	return &encryptWriter{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+// DecryptStream はストリーミング形式で暗号化されたデータを復号化するリーダーを返す
+// データキーはヘッダーに含まれる暗号化されたデータキーをKMSで復号化して取得する
+func (e *KMSEncryptor) DecryptStream(ctx context.Context, r io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(StreamMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !IsStream(magic) {
+		return nil, fmt.Errorf("データがストリーミング形式で暗号化されていません")
+	}
+
+	var encKeyLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &encKeyLen); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data key length: %w", err)
+	}
+	if encKeyLen > 4096 {
+		return nil, fmt.Errorf("encrypted data key is too long: %d bytes", encKeyLen)
+	}
+	encryptedKey := make([]byte, encKeyLen)
+	if _, err := io.ReadFull(r, encryptedKey); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data key: %w", err)
+	}
+
+	plaintextKey, err := e.DecryptDataKey(ctx, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(plaintextKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %w", err)
+	}
+
+	return &decryptReader{
+		r:     r,
+		aead:  aead,
+		nonce: nonce,
+	}, nil
+}
+
+// DataKey は暗号化に使用する平文のデータキーと、ヘッダーに保存する暗号化されたデータキーを返す
+// データキーが設定されていない場合は新しく生成する
+func (e *KMSEncryptor) DataKey(ctx context.Context) ([]byte, []byte, error) {
+	if e.dataKey == nil {
+		output, err := e.GenerateDataKey(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return output.Plaintext, output.CiphertextBlob, nil
+	}
+
+	output, err := e.kmsClient.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(e.keyID),
+		CiphertextBlob: e.dataKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("データキーの復号化に失敗しました: %w", err)
+	}
+	return output.Plaintext, e.dataKey, nil
+}
+
+// DecryptDataKey は暗号化されたデータキーをKMSで復号化する
+// キーIDが設定されていない場合は、暗号化されたデータキーに含まれるキーを使用する
+func (e *KMSEncryptor) DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	input := &kms.DecryptInput{CiphertextBlob: encryptedKey}
+	if e.keyID != "" {
+		input.KeyId = aws.String(e.keyID)
+	}
+	output, err := e.kmsClient.Decrypt(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("データキーの復号化に失敗しました: %w", err)
+	}
+	return output.Plaintext, nil
+}
+
+// newAEAD はデータキーからAES-GCMを初期化する
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("AES暗号化の初期化に失敗しました: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("GCMモードの初期化に失敗しました: %w", err)
+	}
+	return aead, nil
+}
+
+// chunkNonce はチャンク番号に対応するノンスを返す
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	for i := range ctr {
+		nonce[len(nonce)-8+i] ^= ctr[i]
+	}
+	return nonce
+}
+
+// encryptWriter はデータをチャンク単位で暗号化して書き込む
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encryption stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(chunkFlagMore); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close は残りのデータを最終チャンクとして書き込む
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(chunkFlagFinal)
+}
+
+func (w *encryptWriter) flush(flag byte) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.nonce, w.counter), w.buf, []byte{flag})
+	w.counter++
+	w.buf = w.buf[:0]
+
+	var header [5]byte
+	header[0] = flag
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := w.w.Write(header[:]); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	if _, err := w.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	return nil
+}
+
+// decryptReader はチャンク単位で復号化しながら読み込む
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	buf     []byte
+	final   bool
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	var header [5]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("暗号化データが途中で終わっています")
+		}
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	}
+
+	flag := header[0]
+	size := binary.LittleEndian.Uint32(header[1:])
+	if size > streamChunkSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("encrypted chunk is too large: %d bytes", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return fmt.Errorf("暗号化データが途中で終わっています")
+	}
+
+	plaintext, err := r.aead.Open(sealed[:0], chunkNonce(r.nonce, r.counter), sealed, []byte{flag})
+	if err != nil {
+		return fmt.Errorf("データの復号化に失敗しました: %w", err)
+	}
+	r.counter++
+	r.buf = plaintext
+	r.final = flag == chunkFlagFinal
+	return nil
+}
@@ -2,6 +2,7 @@ package encryption
 
 import (
 	"context"
+	"io"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 )
@@ -23,6 +24,18 @@ type Encryptor interface {
 	// 暗号化されたデータを復号化する
 	Decrypt(ctx context.Context, encryptedData *EncryptedData) ([]byte, error)
 
+	// wに暗号化したデータを書き込むライターを返す
+	EncryptStream(ctx context.Context, w io.Writer) (io.WriteCloser, error)
+
+	// ストリーミング形式で暗号化されたデータを復号化するリーダーを返す
+	DecryptStream(ctx context.Context, r io.Reader) (io.Reader, error)
+
+	// 暗号化に使用する平文のデータキーと暗号化されたデータキーを返す
+	DataKey(ctx context.Context) ([]byte, []byte, error)
+
+	// 暗号化されたデータキーを復号化する
+	DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error)
+
 	// データキーを生成する
 	GenerateDataKey(ctx context.Context) (*kms.GenerateDataKeyOutput, error)
 }
@@ -0,0 +1,242 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// Format はユーザーのエクスポート形式
+type Format string
+
+const (
+	CSV              Format = "csv"
+	JSONL            Format = "jsonl"
+	CognitoImportCSV Format = "cognito-import-csv" // Cognitoのユーザーインポートジョブで読み込めるCSV
+)
+
+// userColumns はCSVで属性の前に出力するユーザーの列
+var userColumns = []string{"user_pool_id", "username", "enabled", "status", "created_at", "modified_at", "groups"}
+
+// standardAttributes はスキーマを含まないバックアップで使用するCognitoの標準属性
+// 順番はユーザーインポートジョブのCSVヘッダーと同じ
+var standardAttributes = []string{
+	"name", "given_name", "family_name", "middle_name", "nickname", "preferred_username", "profile", "picture", "website",
+	"email", "email_verified", "gender", "birthdate", "zoneinfo", "locale", "phone_number", "phone_number_verified", "address", "updated_at",
+}
+
+// Writer はユーザーを1件ずつエクスポートする
+type Writer interface {
+	// ユーザーを1件書き込む
+	Write(userPoolID string, user *pkgtypes.UserInfo) error
+
+	// バッファに残った内容を書き込む
+	Flush() error
+}
+
+// NewWriter はformatの形式でwに書き込むWriterを作成する
+// attributesは出力する属性とCSVの列の順番で、CognitoImportCSVではユーザーインポートジョブのCSVヘッダーを指定する
+// CSVのグループはgroupSeparatorでつなげて1つの列にする
+func NewWriter(w io.Writer, format Format, attributes []string, groupSeparator string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, attributes, groupSeparator)
+	case JSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w), attributes: attributes}, nil
+	case CognitoImportCSV:
+		return newImportWriter(w, attributes)
+	}
+	return nil, fmt.Errorf("unknown export format: %s", format)
+}
+
+// Attributes はユーザープールのスキーマの属性名を、最初に現れた順に重複なく返す
+// スキーマがない場合はCognitoの標準属性を返す
+func Attributes(schemas ...[]types.SchemaAttributeType) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, schema := range schemas {
+		for _, attr := range schema {
+			if attr.Name == nil || seen[*attr.Name] || strings.HasPrefix(*attr.Name, "dev:") {
+				continue
+			}
+			seen[*attr.Name] = true
+			names = append(names, *attr.Name)
+		}
+	}
+	if len(names) == 0 {
+		return append([]string{"sub"}, standardAttributes...)
+	}
+	return names
+}
+
+// ImportHeader はユーザーインポートジョブのCSVヘッダーを、GetCSVHeaderと同じ形で返す
+// subとidentitiesはインポートできないため含まず、最後にcognito:mfa_enabledとcognito:usernameを加える
+func ImportHeader(schema []types.SchemaAttributeType) []string {
+	var header []string
+	for _, name := range Attributes(schema) {
+		if name == "sub" || name == "identities" {
+			continue
+		}
+		header = append(header, name)
+	}
+	return append(header, "cognito:mfa_enabled", "cognito:username")
+}
+
+// Importable はユーザーインポートジョブで作成できるユーザーかを返す
+// 外部IDプロバイダーでサインインしたユーザーはインポートできない
+func Importable(user *pkgtypes.UserInfo) bool {
+	return user.UserStatus != string(types.UserStatusTypeExternalProvider)
+}
+
+// csvWriter はユーザーを1行1件のCSVとして書き込む
+type csvWriter struct {
+	w              *csv.Writer
+	attributes     []string
+	groupSeparator string
+}
+
+// newCSVWriter は新しいcsvWriterを作成し、ヘッダーを書き込む
+func newCSVWriter(w io.Writer, attributes []string, groupSeparator string) (*csvWriter, error) {
+	cw := &csvWriter{
+		w:              csv.NewWriter(w),
+		attributes:     attributes,
+		groupSeparator: groupSeparator,
+	}
+	if err := cw.w.Write(append(append([]string(nil), userColumns...), attributes...)); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *csvWriter) Write(userPoolID string, user *pkgtypes.UserInfo) error {
+	row := []string{
+		userPoolID,
+		user.Username,
+		fmt.Sprint(user.IsEnabled()),
+		user.UserStatus,
+		formatTime(user.UserCreateDate),
+		formatTime(user.UserLastModifiedDate),
+		strings.Join(user.Groups, w.groupSeparator),
+	}
+	for _, name := range w.attributes {
+		value, _ := user.Attribute(name)
+		row = append(row, value)
+	}
+	return w.w.Write(row)
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// jsonlRecord はJSONLの1行の形式
+type jsonlRecord struct {
+	UserPoolID string            `json:"user_pool_id"`
+	Username   string            `json:"username"`
+	Enabled    bool              `json:"enabled"`
+	Status     string            `json:"status,omitempty"`
+	CreatedAt  *time.Time        `json:"created_at,omitempty"`
+	ModifiedAt *time.Time        `json:"modified_at,omitempty"`
+	Groups     []string          `json:"groups"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// jsonlWriter はユーザーを1行1件のJSONとして書き込む
+// attributesが空の場合はすべての属性を書き込む
+type jsonlWriter struct {
+	encoder    *json.Encoder
+	attributes []string
+}
+
+func (w *jsonlWriter) Write(userPoolID string, user *pkgtypes.UserInfo) error {
+	record := jsonlRecord{
+		UserPoolID: userPoolID,
+		Username:   user.Username,
+		Enabled:    user.IsEnabled(),
+		Status:     user.UserStatus,
+		CreatedAt:  user.UserCreateDate,
+		ModifiedAt: user.UserLastModifiedDate,
+		Groups:     user.Groups,
+		Attributes: make(map[string]string),
+	}
+	if record.Groups == nil {
+		record.Groups = []string{}
+	}
+	if len(w.attributes) == 0 {
+		for _, attr := range user.Attributes {
+			name, _ := attr["Name"].(string)
+			value, _ := attr["Value"].(string)
+			record.Attributes[name] = value
+		}
+	}
+	for _, name := range w.attributes {
+		if value, ok := user.Attribute(name); ok {
+			record.Attributes[name] = value
+		}
+	}
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return nil
+}
+
+// importWriter はユーザーをユーザーインポートジョブのCSVとして書き込む
+type importWriter struct {
+	w      *csv.Writer
+	header []string
+}
+
+// newImportWriter は新しいimportWriterを作成し、ヘッダーを書き込む
+func newImportWriter(w io.Writer, header []string) (*importWriter, error) {
+	iw := &importWriter{
+		w:      csv.NewWriter(w),
+		header: header,
+	}
+	if err := iw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return iw, nil
+}
+
+func (w *importWriter) Write(userPoolID string, user *pkgtypes.UserInfo) error {
+	row := make([]string, len(w.header))
+	for i, name := range w.header {
+		switch name {
+		case "cognito:username":
+			row[i] = user.Username
+		case "cognito:mfa_enabled":
+			// MFAの秘密鍵はエクスポートできないため、インポートしたユーザーはMFAを設定し直す
+			row[i] = "false"
+		case "email_verified", "phone_number_verified":
+			// 真偽値の列は空欄にせず、値がない場合はfalseにする
+			row[i] = "false"
+			if value, ok := user.Attribute(name); ok && value != "" {
+				row[i] = value
+			}
+		default:
+			row[i], _ = user.Attribute(name)
+		}
+	}
+	return w.w.Write(row)
+}
+
+func (w *importWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// formatTime は日時をRFC3339で表す。日時がない場合は空文字列を返す
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
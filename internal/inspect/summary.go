@@ -0,0 +1,192 @@
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/restore"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// Summary はバックアップしたユーザープールの内容の概要を表す
+type Summary struct {
+	UserPoolID    string         `json:"user_pool_id"`
+	Name          string         `json:"name"`
+	Users         int            `json:"users"`
+	EnabledUsers  int            `json:"enabled_users"`
+	DisabledUsers int            `json:"disabled_users"`
+	UserStatuses  map[string]int `json:"user_statuses"` // ユーザーの状態 -> ユーザー数
+	Groups        []string       `json:"groups"`
+	Clients       []string       `json:"clients"`
+	Config        ConfigSummary  `json:"config"`
+}
+
+// ConfigSummary はユーザープールの設定の概要を表す
+type ConfigSummary struct {
+	MFA                    string   `json:"mfa"`
+	PasswordPolicy         string   `json:"password_policy"`
+	UsernameAttributes     []string `json:"username_attributes"`
+	AliasAttributes        []string `json:"alias_attributes"`
+	AutoVerifiedAttributes []string `json:"auto_verified_attributes"`
+	CustomAttributes       []string `json:"custom_attributes"`
+	Triggers               []string `json:"triggers"`
+	DeletionProtection     string   `json:"deletion_protection"`
+}
+
+// Summarize はsourceのユーザープールの設定、グループ、アプリクライアント、ユーザーを読み込み、概要を返す
+// ユーザーは1件ずつ数えるため、ユーザー全体をメモリに展開しない
+func Summarize(ctx context.Context, source restore.Source) (*Summary, error) {
+	poolConfig, err := source.PoolConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := source.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := source.Clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{
+		UserPoolID:   source.UserPoolID(),
+		UserStatuses: make(map[string]int),
+		Config:       summarizeConfig(poolConfig),
+	}
+	if poolConfig.Name != nil {
+		summary.Name = *poolConfig.Name
+	}
+	for _, group := range groups {
+		if group.GroupName != nil {
+			summary.Groups = append(summary.Groups, *group.GroupName)
+		}
+	}
+	sort.Strings(summary.Groups)
+	for _, client := range clients {
+		if client.ClientName != nil {
+			summary.Clients = append(summary.Clients, *client.ClientName)
+		}
+	}
+	sort.Strings(summary.Clients)
+
+	err = source.Users(ctx, func(user *pkgtypes.UserInfo) error {
+		summary.Users++
+		if user.IsEnabled() {
+			summary.EnabledUsers++
+		} else {
+			summary.DisabledUsers++
+		}
+		status := user.UserStatus
+		if status == "" {
+			status = "UNKNOWN"
+		}
+		summary.UserStatuses[status]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// FindUser はsourceからusernameのユーザーを探す。見つからない場合はnilを返す
+func FindUser(ctx context.Context, source restore.Source, username string) (*pkgtypes.UserInfo, error) {
+	var found *pkgtypes.UserInfo
+	err := source.Users(ctx, func(user *pkgtypes.UserInfo) error {
+		if found == nil && user.Username == username {
+			u := *user
+			found = &u
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// summarizeConfig はユーザープールの設定の概要を作成する
+func summarizeConfig(poolConfig *types.UserPoolType) ConfigSummary {
+	config := ConfigSummary{
+		MFA:                string(poolConfig.MfaConfiguration),
+		DeletionProtection: string(poolConfig.DeletionProtection),
+		Triggers:           triggers(poolConfig.LambdaConfig),
+	}
+	if poolConfig.Policies != nil && poolConfig.Policies.PasswordPolicy != nil {
+		config.PasswordPolicy = describePasswordPolicy(poolConfig.Policies.PasswordPolicy)
+	}
+	for _, attr := range poolConfig.UsernameAttributes {
+		config.UsernameAttributes = append(config.UsernameAttributes, string(attr))
+	}
+	for _, attr := range poolConfig.AliasAttributes {
+		config.AliasAttributes = append(config.AliasAttributes, string(attr))
+	}
+	for _, attr := range poolConfig.AutoVerifiedAttributes {
+		config.AutoVerifiedAttributes = append(config.AutoVerifiedAttributes, string(attr))
+	}
+	for _, attr := range poolConfig.SchemaAttributes {
+		if attr.Name != nil && strings.HasPrefix(*attr.Name, "custom:") {
+			config.CustomAttributes = append(config.CustomAttributes, *attr.Name)
+		}
+	}
+	sort.Strings(config.CustomAttributes)
+	return config
+}
+
+// describePasswordPolicy はパスワードポリシーを1行で表す
+func describePasswordPolicy(policy *types.PasswordPolicyType) string {
+	var parts []string
+	if policy.MinimumLength != nil {
+		parts = append(parts, fmt.Sprintf("min length %d", *policy.MinimumLength))
+	}
+	for _, r := range []struct {
+		name     string
+		required bool
+	}{
+		{"uppercase", policy.RequireUppercase},
+		{"lowercase", policy.RequireLowercase},
+		{"numbers", policy.RequireNumbers},
+		{"symbols", policy.RequireSymbols},
+	} {
+		if r.required {
+			parts = append(parts, r.name)
+		}
+	}
+	if policy.PasswordHistorySize != nil && *policy.PasswordHistorySize > 0 {
+		parts = append(parts, fmt.Sprintf("history %d", *policy.PasswordHistorySize))
+	}
+	if policy.TemporaryPasswordValidityDays > 0 {
+		parts = append(parts, fmt.Sprintf("temporary password valid %d days", policy.TemporaryPasswordValidityDays))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// triggers は設定されているLambdaトリガーの名前を返す
+// トリガーの種類はSDKの更新で増えるため、個別に列挙せずJSONのフィールドから判定する
+func triggers(config *types.LambdaConfigType) []string {
+	if config == nil {
+		return nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	var names []string
+	for name, value := range fields {
+		if value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,202 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// トリガーソース
+const (
+	TriggerSourceAuthentication     = "UserMigration_Authentication"
+	TriggerSourceForgotPassword     = "UserMigration_ForgotPassword"
+	TriggerSourcePostAuthentication = "PostAuthentication_Authentication"
+)
+
+// Handler はacbのバックアップを使用してCognitoのユーザー移行トリガーを処理する
+type Handler struct {
+	source       *aws.CognitoClient
+	target       *aws.CognitoClient
+	sourcePoolID string
+	clientID     string
+	clientSecret string
+	legacySub    string // 元のsubを保存するカスタム属性名
+	users        map[string]*types.UserInfo
+	aliases      map[string]*types.UserInfo
+	subs         map[string]*types.UserInfo
+}
+
+// NewHandler は新しいHandlerを作成する
+// sourceは移行元のユーザープール、targetはトリガーが設定された移行先のユーザープールを操作するクライアント
+func NewHandler(source, target *aws.CognitoClient, sourcePoolID, clientID string, users []types.UserInfo) *Handler {
+	h := &Handler{
+		source:       source,
+		target:       target,
+		sourcePoolID: sourcePoolID,
+		clientID:     clientID,
+		users:        make(map[string]*types.UserInfo, len(users)),
+		aliases:      make(map[string]*types.UserInfo),
+		subs:         make(map[string]*types.UserInfo),
+	}
+
+	// ユーザー名とメールアドレスのどちらでもサインインできるように索引を作成
+	for i := range users {
+		user := &users[i]
+		h.users[user.Username] = user
+		if email, _ := user.Attribute("email"); email != "" {
+			h.aliases[email] = user
+		}
+		if sub, _ := user.Attribute("sub"); sub != "" {
+			h.subs[sub] = user
+		}
+	}
+
+	return h
+}
+
+// SetClientSecret は移行元のアプリクライアントのシークレットを設定する
+func (h *Handler) SetClientSecret(clientSecret string) {
+	h.clientSecret = clientSecret
+}
+
+// SetLegacySubAttribute は移行元のsubを保存するカスタム属性を設定する
+// 属性は移行先のユーザープールに作成しておく必要がある
+func (h *Handler) SetLegacySubAttribute(name string) {
+	if name == "" {
+		h.legacySub = ""
+		return
+	}
+	h.legacySub = "custom:" + strings.TrimPrefix(name, "custom:")
+}
+
+// Handle はトリガーソースに応じてイベントを処理する
+func (h *Handler) Handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	var header events.CognitoEventUserPoolsHeader
+	if err := json.Unmarshal(event, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+
+	switch header.TriggerSource {
+	case TriggerSourceAuthentication, TriggerSourceForgotPassword:
+		var migrateEvent events.CognitoEventUserPoolsMigrateUser
+		if err := json.Unmarshal(event, &migrateEvent); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal migrate user event: %w", err)
+		}
+		return h.migrateUser(ctx, &migrateEvent)
+	case TriggerSourcePostAuthentication:
+		var postAuthEvent events.CognitoEventUserPoolsPostAuthentication
+		if err := json.Unmarshal(event, &postAuthEvent); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal post authentication event: %w", err)
+		}
+		return h.restoreGroups(ctx, &postAuthEvent)
+	}
+
+	// 対象外のトリガーはそのまま返す
+	return event, nil
+}
+
+// migrateUser は移行元のユーザープールで認証し、バックアップの属性でユーザーを移行する
+func (h *Handler) migrateUser(ctx context.Context, event *events.CognitoEventUserPoolsMigrateUser) (*events.CognitoEventUserPoolsMigrateUser, error) {
+	user := h.findUser(event.UserName)
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	if event.TriggerSource == TriggerSourceAuthentication {
+		if err := h.source.AuthenticateUser(ctx, h.sourcePoolID, h.clientID, h.clientSecret, user.Username, event.Password); err != nil {
+			fmt.Printf("Warning: authentication failed for user %s: %v\n", user.Username, err)
+			return nil, fmt.Errorf("bad password")
+		}
+		event.FinalUserStatus = "CONFIRMED"
+	}
+
+	event.UserAttributes = userAttributes(user)
+	if oldSub, ok := user.Attribute("sub"); h.legacySub != "" && ok {
+		event.UserAttributes[h.legacySub] = oldSub
+	}
+	event.MessageAction = "SUPPRESS"
+
+	fmt.Printf("Migrating user %s (%s)\n", user.Username, event.TriggerSource)
+	return event, nil
+}
+
+// restoreGroups は移行済みユーザーをバックアップ時点のグループに追加する
+// 移行トリガーではグループを指定できないため、認証後トリガーで復元する
+func (h *Handler) restoreGroups(ctx context.Context, event *events.CognitoEventUserPoolsPostAuthentication) (*events.CognitoEventUserPoolsPostAuthentication, error) {
+	user := h.findMigratedUser(event)
+	if user == nil || len(user.Groups) == 0 {
+		return event, nil
+	}
+
+	current, err := h.target.ListUserGroups(ctx, event.UserPoolID, event.UserName)
+	if err != nil {
+		return nil, err
+	}
+	joined := make(map[string]bool, len(current))
+	for _, group := range current {
+		joined[group] = true
+	}
+
+	for _, groupName := range user.Groups {
+		if joined[groupName] {
+			continue
+		}
+		input := &cognitoidentityprovider.AdminAddUserToGroupInput{
+			UserPoolId: &event.UserPoolID,
+			Username:   &event.UserName,
+			GroupName:  &groupName,
+		}
+		if _, err := h.target.AddUserToGroup(ctx, input); err != nil {
+			fmt.Printf("Warning: failed to add user %s to group %s: %v\n", event.UserName, groupName, err)
+		}
+	}
+
+	return event, nil
+}
+
+// findUser はユーザー名またはメールアドレスでバックアップ内のユーザーを検索する
+func (h *Handler) findUser(username string) *types.UserInfo {
+	if user, ok := h.users[username]; ok {
+		return user
+	}
+	return h.aliases[username]
+}
+
+// findMigratedUser は認証後トリガーのユーザーに対応するバックアップ内のユーザーを検索する
+// メールアドレスでサインインするユーザープールでは、移行先のユーザー名は新しく割り当てられたUUIDになるため、
+// 元のsubを保存したカスタム属性とメールアドレスでも検索する
+func (h *Handler) findMigratedUser(event *events.CognitoEventUserPoolsPostAuthentication) *types.UserInfo {
+	if user := h.findUser(event.UserName); user != nil {
+		return user
+	}
+	if h.legacySub != "" {
+		if user := h.subs[event.Request.UserAttributes[h.legacySub]]; user != nil {
+			return user
+		}
+	}
+	if email := event.Request.UserAttributes["email"]; email != "" {
+		return h.aliases[email]
+	}
+	return nil
+}
+
+// userAttributes は移行先に設定するユーザー属性を返す
+func userAttributes(user *types.UserInfo) map[string]string {
+	attrs := make(map[string]string, len(user.Attributes))
+	for _, attr := range user.Attributes {
+		name, _ := attr["Name"].(string)
+		value, _ := attr["Value"].(string)
+		// subはCognitoが割り当てるため指定できない
+		if name == "" || name == "sub" {
+			continue
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
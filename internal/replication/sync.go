@@ -0,0 +1,191 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	cognito "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/aws"
+	"github.com/takaishi/acb/internal/restore"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// clockSkew は移行元の最終更新日時と手元の時計のずれとして許容する時間
+const clockSkew = time.Minute
+
+// State は前回の同期の状態を表す
+type State struct {
+	SourceUserPoolID string    `json:"source_user_pool_id"`
+	TargetUserPoolID string    `json:"target_user_pool_id"`
+	Cursor           time.Time `json:"cursor"`         // 次回の同期で対象にするユーザーの最終更新日時の下限
+	LastSyncedAt     time.Time `json:"last_synced_at"` // 前回の同期の開始日時
+	Usernames        []string  `json:"usernames"`      // 前回の同期時点で存在したユーザー名（削除の検出に使用）
+}
+
+// Result は同期結果を表す
+type Result struct {
+	Total   int // 移行元のユーザー数
+	Applied int // 作成または更新したユーザー数
+	Failed  int // 反映に失敗したユーザー数（次回の同期で再試行する）
+	Deleted int // 削除したユーザー数
+}
+
+// Syncer は移行元のユーザープールの変更を移行先のユーザープールに反映する
+//
+// 変更の検出にはユーザーの最終更新日時を使用する。グループの所属の変更では最終更新日時が変わらないため、
+// 所属のみの変更はSetFullで全件を反映するまで移行先に反映されない
+type Syncer struct {
+	source       *aws.CognitoClient
+	target       *aws.CognitoClient
+	sourcePoolID string
+	targetPoolID string
+	users        *restore.Users
+	full         bool
+}
+
+// NewSyncer は新しいSyncerを作成する
+func NewSyncer(source, target *aws.CognitoClient, sourcePoolID, targetPoolID string) *Syncer {
+	return &Syncer{
+		source:       source,
+		target:       target,
+		sourcePoolID: sourcePoolID,
+		targetPoolID: targetPoolID,
+		users:        restore.NewUsers(target, restore.Options{Conflict: restore.ConflictOverwrite}),
+	}
+}
+
+// SetFull はカーソルに関係なくすべてのユーザーを反映するかを設定する
+func (s *Syncer) SetFull(full bool) {
+	s.full = full
+}
+
+// Sync は前回の同期以降に作成、更新、無効化、削除されたユーザーを移行先に反映し、stateを更新する
+// 反映に失敗したユーザーと削除に失敗したユーザーは、次回の同期で再試行する
+func (s *Syncer) Sync(ctx context.Context, state *State) (*Result, error) {
+	if state.SourceUserPoolID != "" && (state.SourceUserPoolID != s.sourcePoolID || state.TargetUserPoolID != s.targetPoolID) {
+		return nil, fmt.Errorf("state belongs to %s -> %s", state.SourceUserPoolID, state.TargetUserPoolID)
+	}
+
+	startedAt := time.Now().UTC()
+	since := state.Cursor
+	if s.full {
+		since = time.Time{}
+	}
+
+	// 同じ日時に更新されたユーザーを取りこぼさないよう、カーソルと同じ日時のユーザーも対象にする
+	result := &Result{}
+	seen := make(map[string]bool)
+	var failedAt *time.Time
+	err := restore.NewLiveSource(s.source, s.sourcePoolID).Users(ctx, func(user *pkgtypes.UserInfo) error {
+		seen[user.Username] = true
+		modified := user.UserLastModifiedDate
+		if modified != nil && modified.Before(since) {
+			return nil
+		}
+
+		if err := s.apply(ctx, user); err != nil {
+			fmt.Printf("Warning: failed to sync user %s: %v\n", user.Username, err)
+			result.Failed++
+			if modified != nil && (failedAt == nil || modified.Before(*failedAt)) {
+				failedAt = modified
+			}
+			return nil
+		}
+		result.Applied++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 一覧の取得中に変更されたユーザーを取りこぼさないよう、カーソルは一覧の取得を始めた日時までにする
+	// 反映済みのユーザーの最終更新日時を使うと、先に取得した後で変更されたユーザーが次回の同期で対象外になる
+	cursor := startedAt.Add(-clockSkew)
+	// 失敗したユーザーが次回の同期の対象になるよう、カーソルは最も古い失敗したユーザーの最終更新日時までにする
+	if failedAt != nil && failedAt.Before(cursor) {
+		cursor = *failedAt
+	}
+	result.Total = len(seen)
+
+	usernames := make([]string, 0, len(seen))
+	for username := range seen {
+		usernames = append(usernames, username)
+	}
+
+	// 前回存在して今回存在しないユーザーを削除
+	for _, username := range state.Usernames {
+		if seen[username] {
+			continue
+		}
+		if err := s.target.DeleteUser(ctx, s.targetPoolID, username); err != nil {
+			var notFound *types.UserNotFoundException
+			if !errors.As(err, &notFound) {
+				// 次回の同期で削除を再試行するよう、ユーザー名を残す
+				fmt.Printf("Warning: failed to delete user %s: %v\n", username, err)
+				usernames = append(usernames, username)
+				continue
+			}
+		}
+		result.Deleted++
+	}
+	sort.Strings(usernames)
+
+	state.SourceUserPoolID = s.sourcePoolID
+	state.TargetUserPoolID = s.targetPoolID
+	state.Cursor = cursor
+	state.LastSyncedAt = startedAt
+	state.Usernames = usernames
+
+	return result, nil
+}
+
+// apply はユーザーを移行先に作成または上書きし、グループの所属を移行元に揃える
+func (s *Syncer) apply(ctx context.Context, user *pkgtypes.UserInfo) error {
+	if _, err := s.users.RestoreUser(ctx, s.targetPoolID, user); err != nil {
+		return err
+	}
+	return s.reconcileGroups(ctx, user)
+}
+
+// reconcileGroups は移行先のユーザーのグループの所属を移行元と同じにする
+// 上書きでの復元はグループへの追加のみを行うため、移行元で外されたグループからはここで外す
+func (s *Syncer) reconcileGroups(ctx context.Context, user *pkgtypes.UserInfo) error {
+	current, err := s.target.ListUserGroups(ctx, s.targetPoolID, user.Username)
+	if err != nil {
+		return err
+	}
+
+	want := make(map[string]bool, len(user.Groups))
+	for _, groupName := range user.Groups {
+		want[groupName] = true
+	}
+	has := make(map[string]bool, len(current))
+	for _, groupName := range current {
+		has[groupName] = true
+		if want[groupName] {
+			continue
+		}
+		if err := s.target.RemoveUserFromGroup(ctx, s.targetPoolID, user.Username, groupName); err != nil {
+			return err
+		}
+	}
+
+	// 復元時にグループへの追加に失敗した場合は警告のみのため、ここで追加し直す
+	for _, groupName := range user.Groups {
+		if has[groupName] {
+			continue
+		}
+		if _, err := s.target.AddUserToGroup(ctx, &cognito.AdminAddUserToGroupInput{
+			UserPoolId: &s.targetPoolID,
+			Username:   &user.Username,
+			GroupName:  &groupName,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
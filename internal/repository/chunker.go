@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"hash/fnv"
+	"io"
+)
+
+// チャンクの区切りの条件
+// ファイルは行単位で区切り、最小サイズを超えた後、行のハッシュが条件を満たす位置で区切る
+// 区切りの位置が行の内容だけで決まるため、ユーザーが追加・削除・変更されても、その前後以外のチャンクは変わらない
+const (
+	minChunkSize      = 16 * 1024
+	maxChunkSize      = 4 * 1024 * 1024
+	chunkBoundaryMask = 0x1f // 最小サイズを超えた後、平均で32行ごとに区切る
+)
+
+// chunker はファイルを内容で決まる位置でチャンクに分割する
+type chunker struct {
+	r   *bufio.Reader
+	buf bytes.Buffer
+	eof bool
+}
+
+// newChunker はrを分割する新しいchunkerを作成する
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+// Next は次のチャンクを返す。ファイルの末尾ではio.EOFを返す
+// 返したスライスは次の呼び出しまで有効
+func (c *chunker) Next() ([]byte, error) {
+	c.buf.Reset()
+	for !c.eof {
+		line, err := c.r.ReadSlice('\n')
+		c.buf.Write(line)
+		switch err {
+		case nil:
+		case bufio.ErrBufferFull:
+			// 長い行は最大サイズで区切る
+			if c.buf.Len() >= maxChunkSize {
+				return c.buf.Bytes(), nil
+			}
+			continue
+		case io.EOF:
+			c.eof = true
+			continue
+		default:
+			return nil, err
+		}
+
+		if c.buf.Len() >= maxChunkSize || (c.buf.Len() >= minChunkSize && isBoundary(line)) {
+			return c.buf.Bytes(), nil
+		}
+	}
+
+	if c.buf.Len() == 0 {
+		return nil, io.EOF
+	}
+	return c.buf.Bytes(), nil
+}
+
+// isBoundary は行の後ろでチャンクを区切るかを返す
+func isBoundary(line []byte) bool {
+	h := fnv.New32a()
+	h.Write(line)
+	return h.Sum32()&chunkBoundaryMask == 0
+}
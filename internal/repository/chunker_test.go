@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// chunks はdataを分割したチャンクを返す
+func chunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data))
+	var out [][]byte
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+// userLines はユーザーをJSON Linesで出力した場合を模した、n行のデータを返す
+func userLines(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `{"Username":"user-%06d","Attributes":[{"Name":"email","Value":"user-%06d@example.com"}]}`+"\n", i, i)
+	}
+	return buf.Bytes()
+}
+
+func TestChunker(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		sizes []int // nilの場合はサイズを検証しない
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "smaller than the minimum",
+			data:  []byte("a\nb\nc\n"),
+			sizes: []int{6},
+		},
+		{
+			name:  "without a trailing newline",
+			data:  []byte("a\nb"),
+			sizes: []int{3},
+		},
+		{
+			name:  "long line is split at the maximum",
+			data:  bytes.Repeat([]byte("x"), 2*maxChunkSize+100),
+			sizes: []int{maxChunkSize, maxChunkSize, 100},
+		},
+		{
+			name: "many lines",
+			data: userLines(20000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunks(t, tt.data)
+
+			if joined := bytes.Join(got, nil); !bytes.Equal(joined, tt.data) {
+				t.Fatalf("chunks do not add up to the input: got %d bytes, want %d bytes", len(joined), len(tt.data))
+			}
+			if tt.sizes != nil {
+				var sizes []int
+				for _, chunk := range got {
+					sizes = append(sizes, len(chunk))
+				}
+				if fmt.Sprint(sizes) != fmt.Sprint(tt.sizes) {
+					t.Errorf("chunk sizes = %v, want %v", sizes, tt.sizes)
+				}
+			}
+			for i, chunk := range got {
+				if len(chunk) > maxChunkSize {
+					t.Errorf("chunk %d is %d bytes, larger than the maximum", i, len(chunk))
+				}
+				// 最後以外のチャンクは、行の区切りか最大サイズで終わる
+				if i < len(got)-1 && len(chunk) < maxChunkSize && (len(chunk) < minChunkSize || chunk[len(chunk)-1] != '\n') {
+					t.Errorf("chunk %d (%d bytes) does not end at a line boundary after the minimum size", i, len(chunk))
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerLocality(t *testing.T) {
+	original := userLines(20000)
+	lines := bytes.SplitAfter(original, []byte("\n"))
+
+	tests := []struct {
+		name   string
+		modify func(lines [][]byte) [][]byte
+	}{
+		{
+			name: "line changed",
+			modify: func(lines [][]byte) [][]byte {
+				lines[10000] = []byte(`{"Username":"changed"}` + "\n")
+				return lines
+			},
+		},
+		{
+			name: "line added",
+			modify: func(lines [][]byte) [][]byte {
+				return append(lines[:10000:10000], append([][]byte{[]byte(`{"Username":"added"}` + "\n")}, lines[10000:]...)...)
+			},
+		},
+		{
+			name: "line removed",
+			modify: func(lines [][]byte) [][]byte {
+				return append(lines[:10000:10000], lines[10001:]...)
+			},
+		},
+	}
+
+	before := chunks(t, original)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := tt.modify(append([][]byte(nil), lines...))
+			after := chunks(t, bytes.Join(modified, nil))
+
+			seen := make(map[string]bool, len(before))
+			for _, chunk := range before {
+				seen[string(chunk)] = true
+			}
+			changed := 0
+			for _, chunk := range after {
+				if !seen[string(chunk)] {
+					changed++
+				}
+			}
+			// 変更した行を含むチャンクと、その前後で区切りがずれたチャンクだけが変わる
+			if changed == 0 || changed > 2 {
+				t.Errorf("%d of %d chunks changed, want 1 or 2", changed, len(after))
+			}
+		})
+	}
+}
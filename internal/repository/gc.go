@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// GCResult はガベージコレクションの結果を表す
+type GCResult struct {
+	Snapshots  int      // 参照を調べたスナップショットの数
+	Referenced int      // スナップショットから参照されているチャンクの数
+	Chunks     int      // 保存されているチャンクの数
+	Unused     []string // 参照されていないチャンクのキー
+	Missing    []string // 参照されているが保存されていないチャンクのID
+}
+
+// GC はどのスナップショットからも参照されていないチャンクを削除する
+// dryRunの場合は削除せずに結果のみを返す
+// スナップショットはチャンクの後に保存されるため、バックアップの実行中はロックを確認してエラーを返す
+func GC(ctx context.Context, store storage.Storage, prefix string, dryRun bool) (*GCResult, error) {
+	// リポジトリ以外の場所のファイルを削除しないよう、設定があることを確認する
+	if _, err := readConfig(ctx, store, prefix); err != nil {
+		if storage.IsNotExist(err) {
+			return nil, fmt.Errorf("no repository found at %s", prefix)
+		}
+		return nil, err
+	}
+
+	if !dryRun {
+		lock, err := acquireLock(ctx, store, prefix, lockGC, time.Now().UTC().Format("20060102T150405Z"), lockBackup)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Release(context.WithoutCancel(ctx))
+	}
+
+	snapshotKeys, err := store.List(ctx, path.Join(prefix, SnapshotsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	result := &GCResult{}
+	referenced := make(map[string]bool)
+	for _, key := range snapshotKeys {
+		if !IsSnapshotKey(key) {
+			continue
+		}
+		// 読み込めないスナップショットがある場合は、必要なチャンクを削除しないよう中止する
+		snapshot, err := LoadSnapshot(ctx, store, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		result.Snapshots++
+		for _, pool := range snapshot.Pools {
+			for _, ids := range pool.Files {
+				for _, id := range ids {
+					referenced[id] = true
+				}
+			}
+		}
+	}
+	result.Referenced = len(referenced)
+
+	chunkKeys, err := store.List(ctx, path.Join(prefix, ChunksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	stored := make(map[string]bool, len(chunkKeys))
+	for _, key := range chunkKeys {
+		id := path.Base(key)
+		stored[id] = true
+		result.Chunks++
+		if !referenced[id] {
+			result.Unused = append(result.Unused, key)
+		}
+	}
+	for id := range referenced {
+		if !stored[id] {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+	sort.Strings(result.Unused)
+	sort.Strings(result.Missing)
+
+	if dryRun {
+		return result, nil
+	}
+	for _, key := range result.Unused {
+		if err := store.Delete(ctx, key); err != nil {
+			return result, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	return result, nil
+}
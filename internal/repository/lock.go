@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/takaishi/acb/internal/storage"
+)
+
+// LocksDir はバックアップとGCが実行中であることを示すロックを保存するディレクトリ名
+const LocksDir = "locks"
+
+// ロックの種類
+const (
+	lockBackup = "backup"
+	lockGC     = "gc"
+)
+
+// Lock はリポジトリのロックを表す
+//
+// バックアップはチャンクの一覧を読み込んでから既存のチャンクを再利用し、最後にスナップショットを保存するため、
+// その間にGCが実行されると、新しいスナップショットが参照するチャンクが削除される。
+// バックアップとGCはそれぞれロックを作成してから相手のロックを確認するため、同時に実行された場合は少なくとも一方が失敗する
+type Lock struct {
+	store storage.Storage
+	key   string
+}
+
+// lockInfo はロックのファイルの内容
+type lockInfo struct {
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// LockBackup はバックアップのロックを作成する
+// GCの実行中はロックを作成せずにエラーを返す
+func (r *Repository) LockBackup(ctx context.Context, runID string) (*Lock, error) {
+	return acquireLock(ctx, r.store, r.prefix, lockBackup, runID, lockGC)
+}
+
+// Release はロックを削除する
+func (l *Lock) Release(ctx context.Context) error {
+	if err := l.store.Delete(ctx, l.key); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("failed to release lock %s: %w", l.key, err)
+	}
+	return nil
+}
+
+// acquireLock はkindのロックを作成し、conflictの種類のロックがある場合は作成したロックを削除してエラーを返す
+func acquireLock(ctx context.Context, store storage.Storage, prefix, kind, id, conflict string) (*Lock, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	data, err := json.MarshalIndent(lockInfo{
+		Kind:      kind,
+		ID:        id,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock: %w", err)
+	}
+
+	lock := &Lock{
+		store: store,
+		key:   path.Join(prefix, LocksDir, fmt.Sprintf("%s-%s-%s.json", kind, id, hex.EncodeToString(suffix))),
+	}
+	if err := store.WriteFile(ctx, lock.key, data); err != nil {
+		return nil, fmt.Errorf("failed to create lock: %w", err)
+	}
+
+	held, err := listLocks(ctx, store, prefix, conflict)
+	if err == nil && len(held) > 0 {
+		err = fmt.Errorf("repository is locked by a running %s (%s); if it is no longer running, delete the lock", conflict, strings.Join(held, ", "))
+	}
+	if err != nil {
+		lock.Release(context.WithoutCancel(ctx))
+		return nil, err
+	}
+	return lock, nil
+}
+
+// listLocks はprefixにあるkindのロックのキーを返す
+func listLocks(ctx context.Context, store storage.Storage, prefix, kind string) ([]string, error) {
+	keys, err := store.List(ctx, path.Join(prefix, LocksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+
+	var locks []string
+	for _, key := range keys {
+		if strings.HasPrefix(path.Base(key), kind+"-") {
+			locks = append(locks, key)
+		}
+	}
+	return locks, nil
+}
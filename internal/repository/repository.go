@@ -0,0 +1,482 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/storage"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// リポジトリのレイアウト:
+//
+//	<prefix>/config.json                  # リポジトリの設定
+//	<prefix>/snapshots/<run-id>.json      # 実行ごとのスナップショット
+//	<prefix>/chunks/<id[:2]>/<id>         # チャンク（圧縮し、暗号化する場合は暗号化したもの）
+//
+// ユーザー情報や設定のファイルを内容で決まる位置でチャンクに分割し、同じ内容のチャンクは一度だけ保存する
+const (
+	ConfigFile   = "config.json"
+	SnapshotsDir = "snapshots"
+	ChunksDir    = "chunks"
+
+	configVersion   = 1
+	snapshotVersion = 1
+	compressionZstd = "zstd"
+)
+
+// Stats はバックアップで保存したチャンクの統計を表す
+type Stats struct {
+	NewChunks    int   // 新しく保存したチャンクの数
+	NewBytes     int64 // 新しく保存したチャンクのサイズ（圧縮・暗号化後）
+	ReusedChunks int   // 保存済みのため再利用したチャンクの数
+}
+
+// Repository は重複を排除してバックアップを保存するリポジトリ
+type Repository struct {
+	store   storage.Storage
+	prefix  string
+	config  *types.RepositoryConfig
+	cipher  *encryption.BlobCipher // 暗号化しない場合はnil
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+
+	mu     sync.Mutex
+	chunks map[string]bool // 保存済みのチャンクのID（読み込むまではnil）
+	stats  Stats
+}
+
+// ConfigKey はリポジトリの設定のキーを返す
+func ConfigKey(prefix string) string {
+	return path.Join(prefix, ConfigFile)
+}
+
+// SnapshotKey は実行IDのスナップショットのキーを返す
+func SnapshotKey(prefix, runID string) string {
+	return path.Join(prefix, SnapshotsDir, runID+".json")
+}
+
+// IsSnapshotKey はキーがリポジトリのスナップショットかを返す
+func IsSnapshotKey(key string) bool {
+	return path.Base(path.Dir(key)) == SnapshotsDir && strings.HasSuffix(key, ".json")
+}
+
+// PrefixOf はスナップショットのキーからリポジトリのプレフィックスを返す
+func PrefixOf(snapshotKey string) string {
+	prefix := path.Dir(path.Dir(snapshotKey))
+	if prefix == "." {
+		return ""
+	}
+	return prefix
+}
+
+// chunkKey はチャンクのキーを返す
+func chunkKey(prefix, id string) string {
+	return path.Join(prefix, ChunksDir, id[:2], id)
+}
+
+// Open はprefixのリポジトリを開く
+// 暗号化されたリポジトリの場合は、encryptorで設定に保存されたデータキーを復号化する
+func Open(ctx context.Context, store storage.Storage, prefix string, encryptor encryption.Encryptor) (*Repository, error) {
+	config, err := readConfig(ctx, store, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newRepository(ctx, store, prefix, config, encryptor)
+}
+
+// OpenOrInit はprefixのリポジトリを開き、存在しない場合は作成する
+// encryptorが指定された場合は暗号化されたリポジトリを作成し、既存のリポジトリの暗号化の有無と一致しない場合はエラーを返す
+func OpenOrInit(ctx context.Context, store storage.Storage, prefix string, encryptor encryption.Encryptor, kmsKeyID string) (*Repository, bool, error) {
+	config, err := readConfig(ctx, store, prefix)
+	if err == nil {
+		encrypted := len(config.EncryptedDataKey) > 0
+		if encrypted && encryptor == nil {
+			return nil, false, fmt.Errorf("repository at %s is encrypted; specify the KMS key", prefix)
+		}
+		if !encrypted && encryptor != nil {
+			return nil, false, fmt.Errorf("repository at %s is not encrypted; use a new destination to enable encryption", prefix)
+		}
+		repo, err := newRepository(ctx, store, prefix, config, encryptor)
+		return repo, false, err
+	}
+	if !storage.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	config = &types.RepositoryConfig{
+		Version:     configVersion,
+		Compression: compressionZstd,
+	}
+	if encryptor != nil {
+		// チャンクごとにKMSを呼び出さないよう、リポジトリ全体で1つのデータキーを使用する
+		_, encryptedKey, err := encryptor.DataKey(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		config.KMSKeyID = kmsKeyID
+		config.EncryptedDataKey = encryptedKey
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode repository config: %w", err)
+	}
+	if err := store.WriteFile(ctx, ConfigKey(prefix), data); err != nil {
+		return nil, false, fmt.Errorf("failed to save repository config: %w", err)
+	}
+
+	repo, err := newRepository(ctx, store, prefix, config, encryptor)
+	return repo, true, err
+}
+
+// readConfig はリポジトリの設定を読み込む
+func readConfig(ctx context.Context, store storage.Storage, prefix string) (*types.RepositoryConfig, error) {
+	data, err := store.ReadFile(ctx, ConfigKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var config types.RepositoryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse repository config: %w", err)
+	}
+	if config.Version != configVersion {
+		return nil, fmt.Errorf("unsupported repository version: %d", config.Version)
+	}
+	if config.Compression != compressionZstd {
+		return nil, fmt.Errorf("unsupported repository compression: %s", config.Compression)
+	}
+	return &config, nil
+}
+
+// newRepository は設定からRepositoryを作成する
+func newRepository(ctx context.Context, store storage.Storage, prefix string, config *types.RepositoryConfig, encryptor encryption.Encryptor) (*Repository, error) {
+	repo := &Repository{
+		store:  store,
+		prefix: prefix,
+		config: config,
+	}
+
+	if len(config.EncryptedDataKey) > 0 {
+		if encryptor == nil {
+			return nil, fmt.Errorf("repository at %s is encrypted", prefix)
+		}
+		dataKey, err := encryptor.DecryptDataKey(ctx, config.EncryptedDataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt repository data key: %w", err)
+		}
+		repo.cipher, err = encryption.NewBlobCipher(dataKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	repo.encoder, err = zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start compression: %w", err)
+	}
+	repo.decoder, err = zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	return repo, nil
+}
+
+// Compression はチャンクの圧縮方式を返す
+func (r *Repository) Compression() string {
+	return r.config.Compression
+}
+
+// Stats はこれまでに保存したチャンクの統計を返す
+func (r *Repository) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// chunkID はチャンクの内容からIDを計算する
+// 暗号化する場合は内容を推測されないよう、データキーから導出した鍵のHMACを使用する
+func (r *Repository) chunkID(data []byte) string {
+	if r.cipher != nil {
+		return r.cipher.ID(data)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadChunks は保存済みのチャンクのIDを読み込む
+// 呼び出し元でmuを保持すること
+func (r *Repository) loadChunks(ctx context.Context) error {
+	if r.chunks != nil {
+		return nil
+	}
+
+	keys, err := r.store.List(ctx, path.Join(r.prefix, ChunksDir))
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+	r.chunks = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		r.chunks[path.Base(key)] = true
+	}
+	return nil
+}
+
+// SaveChunk はチャンクを保存し、IDを返す。同じ内容のチャンクが保存済みの場合は保存しない
+func (r *Repository) SaveChunk(ctx context.Context, data []byte) (string, error) {
+	id := r.chunkID(data)
+
+	r.mu.Lock()
+	if err := r.loadChunks(ctx); err != nil {
+		r.mu.Unlock()
+		return "", err
+	}
+	if r.chunks[id] {
+		r.stats.ReusedChunks++
+		r.mu.Unlock()
+		return id, nil
+	}
+	r.mu.Unlock()
+
+	body := r.encoder.EncodeAll(data, nil)
+	if r.cipher != nil {
+		var err error
+		body, err = r.cipher.Seal(id, body)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := r.store.WriteFile(ctx, chunkKey(r.prefix, id), body); err != nil {
+		return "", fmt.Errorf("failed to save chunk %s: %w", id, err)
+	}
+
+	r.mu.Lock()
+	r.chunks[id] = true
+	r.stats.NewChunks++
+	r.stats.NewBytes += int64(len(body))
+	r.mu.Unlock()
+	return id, nil
+}
+
+// LoadChunk はチャンクを読み込み、内容がIDと一致することを確認する
+func (r *Repository) LoadChunk(ctx context.Context, id string) ([]byte, error) {
+	if len(id) < 2 {
+		return nil, fmt.Errorf("invalid chunk ID: %q", id)
+	}
+	body, err := r.store.ReadFile(ctx, chunkKey(r.prefix, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+
+	if r.cipher != nil {
+		body, err = r.cipher.Open(id, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+		}
+	}
+	data, err := r.decoder.DecodeAll(body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %s: %w", id, err)
+	}
+	if r.chunkID(data) != id {
+		return nil, fmt.Errorf("chunk %s is corrupted", id)
+	}
+	return data, nil
+}
+
+// SaveFile はrの内容をチャンクに分割して保存し、チャンクのIDの一覧を返す
+func (r *Repository) SaveFile(ctx context.Context, rd io.Reader) ([]string, error) {
+	c := newChunker(rd)
+	ids := []string{}
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.SaveChunk(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+}
+
+// OpenFile はチャンクのIDの一覧から元のファイルを読み込むリーダーを返す
+// チャンクは読み込みに応じて1つずつ取得する
+func (r *Repository) OpenFile(ctx context.Context, ids []string) io.Reader {
+	return &fileReader{
+		ctx:  ctx,
+		repo: r,
+		ids:  ids,
+	}
+}
+
+// fileReader はチャンクを順に連結して読み込む
+type fileReader struct {
+	ctx  context.Context
+	repo *Repository
+	ids  []string
+	buf  []byte
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if len(f.ids) == 0 {
+			return 0, io.EOF
+		}
+		data, err := f.repo.LoadChunk(f.ctx, f.ids[0])
+		if err != nil {
+			return 0, err
+		}
+		f.ids = f.ids[1:]
+		f.buf = data
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// NewPoolWriter はユーザープールのファイルをチャンクに分割して保存するarchive.Writerと、
+// 保存したファイルを記録するスナップショットのユーザープールを返す
+func (r *Repository) NewPoolWriter(ctx context.Context, userPoolID string) (*archive.Writer, *types.SnapshotPool) {
+	pool := &types.SnapshotPool{
+		UserPoolID: userPoolID,
+		Files:      make(map[string][]string),
+	}
+	w := archive.NewFuncWriter(func(name string, rd io.Reader) error {
+		file := strings.TrimPrefix(name, userPoolID+"/")
+		if file == name || file == "" || strings.HasPrefix(path.Clean(file), "..") {
+			return fmt.Errorf("unexpected file: %s", name)
+		}
+		ids, err := r.SaveFile(ctx, rd)
+		if err != nil {
+			return err
+		}
+		// archive.Writerがエントリの追加を直列化するため、ロックは不要
+		pool.Files[file] = ids
+		return nil
+	})
+	return w, pool
+}
+
+// NewSnapshot は実行IDの新しいスナップショットを作成する
+func NewSnapshot(runID, timestamp string) *types.Snapshot {
+	return &types.Snapshot{
+		Version:   snapshotVersion,
+		RunID:     runID,
+		Timestamp: timestamp,
+	}
+}
+
+// SaveSnapshot はスナップショットを保存し、キーと保存した内容を返す
+// スナップショットはチャンクより後に保存するため、保存されたスナップショットが参照するチャンクは必ず存在する
+func (r *Repository) SaveSnapshot(ctx context.Context, snapshot *types.Snapshot) (string, []byte, error) {
+	sort.Slice(snapshot.Pools, func(i, j int) bool {
+		return snapshot.Pools[i].UserPoolID < snapshot.Pools[j].UserPoolID
+	})
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	key := SnapshotKey(r.prefix, snapshot.RunID)
+	if err := r.store.WriteFile(ctx, key, data); err != nil {
+		return "", nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return key, data, nil
+}
+
+// ParseSnapshot はスナップショットの内容を解析する
+func ParseSnapshot(data []byte) (*types.Snapshot, error) {
+	var snapshot types.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// LoadSnapshot はkeyのスナップショットを読み込む
+func LoadSnapshot(ctx context.Context, store storage.Storage, key string) (*types.Snapshot, error) {
+	data, err := store.ReadFile(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return ParseSnapshot(data)
+}
+
+// WalkSnapshot はスナップショットの各ファイルを "<ユーザープールID>/<ファイル名>" の名前で順にfnに渡す
+func (r *Repository) WalkSnapshot(ctx context.Context, snapshot *types.Snapshot, fn func(name string, rd io.Reader) error) error {
+	for _, pool := range snapshot.Pools {
+		files := make([]string, 0, len(pool.Files))
+		for file := range pool.Files {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			if err := fn(path.Join(pool.UserPoolID, file), r.OpenFile(ctx, pool.Files[file])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Extract はkeyのスナップショットのファイルを、アーカイブを展開した場合と同じ構成でdirに書き出す
+func (r *Repository) Extract(ctx context.Context, key, dir string) error {
+	return r.ExtractFiles(ctx, key, dir, nil)
+}
+
+// ExtractFiles はkeyのスナップショットのファイルのうち、matchがtrueを返すものだけをdirに書き出す
+// 書き出さないファイルのチャンクは読み込まない。matchがnilの場合はすべてのファイルを書き出す
+func (r *Repository) ExtractFiles(ctx context.Context, key, dir string, match func(name string) bool) error {
+	snapshot, err := LoadSnapshot(ctx, r.store, key)
+	if err != nil {
+		return err
+	}
+
+	return r.WalkSnapshot(ctx, snapshot, func(name string, rd io.Reader) error {
+		if path.IsAbs(name) || strings.HasPrefix(path.Clean(name), "..") {
+			return fmt.Errorf("invalid file name in snapshot: %s", name)
+		}
+		if match != nil && !match(path.Clean(name)) {
+			return nil
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		f, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		if _, err := io.Copy(f, rd); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		return f.Close()
+	})
+}
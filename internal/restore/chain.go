@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// userIndexFile は増分バックアップに保存されたすべてのユーザーの一覧のファイル名
+const userIndexFile = "usernames.json"
+
+// ChainSource は増分バックアップと、完全バックアップまでの親のバックアップの連鎖を復元元とするSource
+// 設定、グループ、アプリクライアント、外部IDプロバイダーは最も新しいバックアップから読み込む
+// ユーザーは最も新しいバックアップのユーザーの一覧に含まれるユーザーを、そのユーザーを含む最も新しいバックアップから読み込む
+type ChainSource struct {
+	sources []*BackupSource // 新しい順。最後は完全バックアップ
+	log     io.Writer       // 警告の出力先
+}
+
+// NewChainSource は新しいChainSourceを作成する
+// sourcesは復元する増分バックアップから親をたどった順に並べる
+func NewChainSource(sources []*BackupSource) *ChainSource {
+	return &ChainSource{
+		sources: sources,
+		log:     os.Stdout,
+	}
+}
+
+// SetLog は警告の出力先を設定する
+func (s *ChainSource) SetLog(w io.Writer) {
+	s.log = w
+}
+
+// UserPoolID はバックアップされたユーザープールのIDを返す
+func (s *ChainSource) UserPoolID() string {
+	return s.sources[0].UserPoolID()
+}
+
+// PoolConfig は最も新しいバックアップからユーザープールの設定を読み込む
+func (s *ChainSource) PoolConfig(ctx context.Context) (*types.UserPoolType, error) {
+	return s.sources[0].PoolConfig(ctx)
+}
+
+// Groups は最も新しいバックアップからグループの一覧を読み込む
+func (s *ChainSource) Groups(ctx context.Context) ([]types.GroupType, error) {
+	return s.sources[0].Groups(ctx)
+}
+
+// Clients は最も新しいバックアップからアプリクライアントの一覧を読み込む
+func (s *ChainSource) Clients(ctx context.Context) ([]types.UserPoolClientType, error) {
+	return s.sources[0].Clients(ctx)
+}
+
+// IdentityProviders は最も新しいバックアップから外部IDプロバイダーの一覧を読み込む
+func (s *ChainSource) IdentityProviders(ctx context.Context) ([]types.IdentityProviderType, error) {
+	return s.sources[0].IdentityProviders(ctx)
+}
+
+// Users はバックアップの時点のユーザーを1件ずつfnに渡す
+// 削除されたユーザーは含まず、グループの所属と有効・無効はユーザーの一覧の内容にする
+func (s *ChainSource) Users(ctx context.Context, fn func(*pkgtypes.UserInfo) error) error {
+	index, err := s.sources[0].userIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read user index: %w", err)
+	}
+
+	for _, src := range s.sources {
+		err := src.Users(ctx, func(user *pkgtypes.UserInfo) error {
+			entry, ok := index[user.Username]
+			if !ok {
+				// 削除されたユーザーか、より新しいバックアップから読み込んだユーザー
+				return nil
+			}
+			delete(index, user.Username)
+
+			user.Groups = entry.Groups
+			if entry.Enabled != nil {
+				user.Enabled = entry.Enabled
+			}
+			return fn(user)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(index) > 0 {
+		fmt.Fprintf(s.log, "Warning: %d users in the user index of %s were not found in the backup chain\n", len(index), s.UserPoolID())
+	}
+	return nil
+}
+
+// userIndex はバックアップのユーザーの一覧を読み込み、ユーザー名で引ける対応表を返す
+func (s *BackupSource) userIndex(ctx context.Context) (map[string]*pkgtypes.UserIndexEntry, error) {
+	f, err := s.fsys.Open(path.Join(s.metadata.UserPoolID, userIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	found, err := seekUsers(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*pkgtypes.UserIndexEntry)
+	for found && decoder.More() {
+		var entry pkgtypes.UserIndexEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+		index[entry.Username] = &entry
+	}
+	return index, nil
+}
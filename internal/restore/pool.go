@@ -2,64 +2,143 @@ package restore
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
 
-	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/takaishi/acb/internal/aws"
-	"github.com/takaishi/acb/internal/storage"
-	"github.com/takaishi/acb/pkg/types"
 )
 
 // Pool はユーザープールの復元を管理する
 type Pool struct {
 	cognito *aws.CognitoClient
-	storage storage.Storage
+	opts    Options
 }
 
 // NewPool は新しいPool構造体を作成する
-func NewPool(cognito *aws.CognitoClient, storage storage.Storage) *Pool {
+func NewPool(cognito *aws.CognitoClient, opts Options) *Pool {
 	return &Pool{
 		cognito: cognito,
-		storage: storage,
+		opts:    opts,
 	}
 }
 
-// RestorePool はバックアップからユーザープールを復元する
-func (p *Pool) RestorePool(ctx context.Context, metadata *types.BackupMetadata) error {
-	// メタデータからプール設定ファイルのパスを構築
-	configPath := filepath.Join(metadata.UserPoolID, "pool-config.json")
-
+// RestorePool は復元元の設定でユーザープールを作成し、作成したユーザープールのIDを返す
+func (p *Pool) RestorePool(ctx context.Context, src Source) (string, error) {
 	// プール設定を読み込む
-	configData, err := p.storage.ReadFile(ctx, configPath)
+	poolConfig, err := src.PoolConfig(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read pool config: %w", err)
+		return "", err
 	}
 
-	var poolConfig types.PoolConfiguration
-	if err := json.Unmarshal(configData, &poolConfig); err != nil {
-		return fmt.Errorf("failed to unmarshal pool config: %w", err)
+	poolName := p.opts.PoolName
+	if poolName == "" && poolConfig.Name != nil {
+		poolName = *poolConfig.Name
 	}
-
-	// ユーザープールを作成
-	input := &cognitoidentityprovider.CreateUserPoolInput{
-		PoolName: &metadata.UserPoolID,
-		// 基本設定を適用
-		Policies:         aws.ToPoolPolicies(poolConfig.Policies),
-		MfaConfiguration: aws.ToMFAConfig(poolConfig.MFAConfiguration),
-		// カスタム属性を適用
-		Schema: aws.ToSchemaAttributes(poolConfig.CustomAttributes),
-		// Lambda トリガーを適用
-		LambdaConfig: aws.ToLambdaConfig(poolConfig.Triggers),
+	if poolName == "" {
+		poolName = src.UserPoolID()
 	}
 
 	// ユーザープールを作成
-	output, err := p.cognito.CreateUserPool(ctx, input)
+	output, err := p.cognito.CreateUserPool(ctx, aws.ToCreateUserPoolInput(poolConfig, poolName))
 	if err != nil {
-		return fmt.Errorf("failed to create user pool: %w", err)
+		return "", fmt.Errorf("failed to create user pool: %w", err)
 	}
 
 	fmt.Printf("Successfully restored user pool: %s\n", *output.UserPool.Id)
+	return *output.UserPool.Id, nil
+}
+
+// RestoreGroups は復元元のグループを作成する
+func (p *Pool) RestoreGroups(ctx context.Context, src Source, userPoolID string) error {
+	groups, err := src.Groups(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		_, err := p.cognito.CreateGroup(ctx, aws.ToCreateGroupInput(&group, userPoolID))
+		if err == nil {
+			continue
+		}
+
+		var exists *types.GroupExistsException
+		if !errors.As(err, &exists) {
+			fmt.Printf("Warning: failed to restore group %s: %v\n", *group.GroupName, err)
+			continue
+		}
+
+		switch p.opts.Conflict {
+		case ConflictFail:
+			return fmt.Errorf("%w: group %s", ErrConflict, *group.GroupName)
+		case ConflictOverwrite:
+			if _, err := p.cognito.UpdateGroup(ctx, aws.ToUpdateGroupInput(&group, userPoolID)); err != nil {
+				fmt.Printf("Warning: failed to overwrite group %s: %v\n", *group.GroupName, err)
+			}
+		default:
+			fmt.Printf("Skipping existing group %s\n", *group.GroupName)
+		}
+	}
+
+	fmt.Printf("Restored %d groups\n", len(groups))
 	return nil
 }
+
+// RestoreClients は復元元のアプリクライアントを作成する
+// クライアントIDとシークレットは新しく発行されるため、アプリケーション側の設定変更が必要になる
+func (p *Pool) RestoreClients(ctx context.Context, src Source, userPoolID string) error {
+	clients, err := src.Clients(ctx)
+	if err != nil {
+		return err
+	}
+
+	// アプリクライアント名は重複できるため、既存のものを名前で照合する
+	existingClients, err := p.cognito.ListUserPoolClients(ctx, userPoolID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]string, len(existingClients))
+	for _, client := range existingClients {
+		existing[*client.ClientName] = *client.ClientId
+	}
+
+	for _, client := range clients {
+		// 外部IdPは復元されないため、Cognitoのみに制限する
+		client.SupportedIdentityProviders = cognitoProviders(client.SupportedIdentityProviders)
+
+		clientID, ok := existing[*client.ClientName]
+		if !ok {
+			output, err := p.cognito.CreateUserPoolClient(ctx, aws.ToCreateUserPoolClientInput(&client, userPoolID))
+			if err != nil {
+				fmt.Printf("Warning: failed to restore client %s: %v\n", *client.ClientName, err)
+				continue
+			}
+			fmt.Printf("Restored client %s: %s -> %s\n", *client.ClientName, *client.ClientId, *output.UserPoolClient.ClientId)
+			continue
+		}
+
+		switch p.opts.Conflict {
+		case ConflictFail:
+			return fmt.Errorf("%w: client %s", ErrConflict, *client.ClientName)
+		case ConflictOverwrite:
+			if _, err := p.cognito.UpdateUserPoolClient(ctx, aws.ToUpdateUserPoolClientInput(&client, userPoolID, clientID)); err != nil {
+				fmt.Printf("Warning: failed to overwrite client %s: %v\n", *client.ClientName, err)
+			}
+		default:
+			fmt.Printf("Skipping existing client %s\n", *client.ClientName)
+		}
+	}
+
+	return nil
+}
+
+// cognitoProviders はIdPの一覧からCognitoのみを残す
+func cognitoProviders(providers []string) []string {
+	var result []string
+	for _, provider := range providers {
+		if provider == "COGNITO" {
+			result = append(result, provider)
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/takaishi/acb/internal/aws"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// ConflictPolicy は復元先に同じリソースが存在する場合の動作を表す
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"      // 既存のリソースをそのまま残す
+	ConflictOverwrite ConflictPolicy = "overwrite" // 既存のリソースをバックアップの内容で上書きする
+	ConflictFail      ConflictPolicy = "fail"      // 復元を中止する
+)
+
+// ErrConflict は復元先に同じリソースが存在し、復元を中止したことを表す
+var ErrConflict = errors.New("resource already exists")
+
+// Options は復元時の動作を指定する
+type Options struct {
+	Conflict           ConflictPolicy // 復元先に同じリソースが存在する場合の動作
+	UserPattern        *regexp.Regexp // 復元するユーザー名のパターン（nilの場合はすべて）
+	SkipGroups         bool           // グループを復元しない
+	SkipClients        bool           // アプリクライアントを復元しない
+	SkipUsers          bool           // ユーザーを復元しない
+	LegacySubAttribute string         // 元のsubを保存するカスタム属性名
+	PoolName           string         // 作成するユーザープールの名前（空の場合は元の名前）
+}
+
+// Result は復元結果を表す
+type Result struct {
+	UserPoolID  string
+	SubMappings []pkgtypes.SubMapping
+}
+
+// Restorer はユーザープールの設定、グループ、アプリクライアント、ユーザーを復元する
+type Restorer struct {
+	pool  *Pool
+	users *Users
+	opts  Options
+}
+
+// NewRestorer は新しいRestorerを作成する
+func NewRestorer(cognito *aws.CognitoClient, opts Options) *Restorer {
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictSkip
+	}
+	return &Restorer{
+		pool:  NewPool(cognito, opts),
+		users: NewUsers(cognito, opts),
+		opts:  opts,
+	}
+}
+
+// Restore は復元元のユーザープールを復元する
+// targetPoolIDが空の場合は新しいユーザープールを作成する
+func (r *Restorer) Restore(ctx context.Context, src Source, targetPoolID string) (*Result, error) {
+	if targetPoolID == "" {
+		poolID, err := r.pool.RestorePool(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		targetPoolID = poolID
+	}
+
+	result := &Result{UserPoolID: targetPoolID}
+
+	if !r.opts.SkipGroups {
+		if err := r.pool.RestoreGroups(ctx, src, targetPoolID); err != nil {
+			return result, fmt.Errorf("failed to restore groups: %w", err)
+		}
+	}
+
+	if !r.opts.SkipClients {
+		if err := r.pool.RestoreClients(ctx, src, targetPoolID); err != nil {
+			return result, fmt.Errorf("failed to restore clients: %w", err)
+		}
+	}
+
+	if !r.opts.SkipUsers {
+		mappings, err := r.users.RestoreUsers(ctx, src, targetPoolID)
+		result.SubMappings = mappings
+		if err != nil {
+			return result, fmt.Errorf("failed to restore users: %w", err)
+		}
+	}
+
+	return result, nil
+}
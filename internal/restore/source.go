@@ -0,0 +1,302 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/aws"
+	pkgtypes "github.com/takaishi/acb/pkg/types"
+)
+
+// Source は復元元のユーザープールを表すインターフェース
+type Source interface {
+	// 復元元のユーザープールIDを返す
+	UserPoolID() string
+
+	// ユーザープールの設定を返す
+	PoolConfig(ctx context.Context) (*types.UserPoolType, error)
+
+	// グループの一覧を返す
+	Groups(ctx context.Context) ([]types.GroupType, error)
+
+	// アプリクライアントの一覧を返す
+	Clients(ctx context.Context) ([]types.UserPoolClientType, error)
+
+	// 外部IDプロバイダーの一覧を返す
+	IdentityProviders(ctx context.Context) ([]types.IdentityProviderType, error)
+
+	// ユーザーを1件ずつfnに渡す
+	Users(ctx context.Context, fn func(*pkgtypes.UserInfo) error) error
+}
+
+// BackupSource は展開したバックアップを復元元とするSource
+type BackupSource struct {
+	fsys     fs.FS
+	metadata *pkgtypes.BackupMetadata
+}
+
+// NewBackupSource は新しいBackupSourceを作成する
+// fsysはバックアップを展開したディレクトリで、"<ユーザープールID>/<ファイル名>" でファイルを読み込む
+func NewBackupSource(fsys fs.FS, metadata *pkgtypes.BackupMetadata) *BackupSource {
+	return &BackupSource{
+		fsys:     fsys,
+		metadata: metadata,
+	}
+}
+
+// UserPoolID はバックアップされたユーザープールのIDを返す
+func (s *BackupSource) UserPoolID() string {
+	return s.metadata.UserPoolID
+}
+
+// PoolConfig はバックアップからユーザープールの設定を読み込む
+func (s *BackupSource) PoolConfig(ctx context.Context) (*types.UserPoolType, error) {
+	var poolConfig types.UserPoolType
+	if err := s.readJSON(ctx, "pool-config.json", &poolConfig); err != nil {
+		return nil, fmt.Errorf("failed to read pool config: %w", err)
+	}
+	return &poolConfig, nil
+}
+
+// Groups はバックアップからグループの一覧を読み込む
+func (s *BackupSource) Groups(ctx context.Context) ([]types.GroupType, error) {
+	// グループを含まない古いバックアップ
+	if !s.hasFile("groups.json") {
+		return nil, nil
+	}
+
+	var groupsBackup pkgtypes.GroupsBackup
+	if err := s.readJSON(ctx, "groups.json", &groupsBackup); err != nil {
+		return nil, fmt.Errorf("failed to read groups data: %w", err)
+	}
+	return groupsBackup.Groups, nil
+}
+
+// Clients はバックアップからアプリクライアントの一覧を読み込む
+func (s *BackupSource) Clients(ctx context.Context) ([]types.UserPoolClientType, error) {
+	// アプリクライアントを含まない古いバックアップ
+	if !s.hasFile("clients.json") {
+		return nil, nil
+	}
+
+	var clientsBackup pkgtypes.ClientsBackup
+	if err := s.readJSON(ctx, "clients.json", &clientsBackup); err != nil {
+		return nil, fmt.Errorf("failed to read clients data: %w", err)
+	}
+	return clientsBackup.UserPoolClients(), nil
+}
+
+// IdentityProviders はバックアップから外部IDプロバイダーの一覧を読み込む
+func (s *BackupSource) IdentityProviders(ctx context.Context) ([]types.IdentityProviderType, error) {
+	// 外部IDプロバイダーを含まない古いバックアップ
+	if !s.hasFile("identity-providers.json") {
+		return nil, nil
+	}
+
+	var providersBackup pkgtypes.IdentityProvidersBackup
+	if err := s.readJSON(ctx, "identity-providers.json", &providersBackup); err != nil {
+		return nil, fmt.Errorf("failed to read identity providers data: %w", err)
+	}
+	return providersBackup.IdentityProviders, nil
+}
+
+// Users はバックアップからユーザー情報を1件ずつ読み込み、fnに渡す
+// ユーザー全体をメモリに展開しないよう、シャードを1行ずつデコードする
+// シャードがない古いバックアップはusers.jsonの配列を順にデコードする
+func (s *BackupSource) Users(ctx context.Context, fn func(*pkgtypes.UserInfo) error) error {
+	shards, err := fs.Glob(s.fsys, path.Join(s.metadata.UserPoolID, pkgtypes.UsersDir, "*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("failed to read users data: %w", err)
+	}
+	if len(shards) == 0 {
+		return s.legacyUsers(ctx, fn)
+	}
+
+	// シャードのファイル名は番号を0埋めしているため、名前順が保存した順になる
+	sort.Strings(shards)
+	for _, shard := range shards {
+		if err := s.shardUsers(shard, fn); err != nil {
+			return fmt.Errorf("failed to read users data (%s): %w", path.Base(shard), err)
+		}
+	}
+	return nil
+}
+
+// shardUsers はシャードのユーザーを1件ずつfnに渡す
+func (s *BackupSource) shardUsers(shard string, fn func(*pkgtypes.UserInfo) error) error {
+	f, err := s.fsys.Open(shard)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var user pkgtypes.UserInfo
+		err := decoder.Decode(&user)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+}
+
+// legacyUsers は古いバックアップのusers.jsonからユーザーを1件ずつfnに渡す
+func (s *BackupSource) legacyUsers(ctx context.Context, fn func(*pkgtypes.UserInfo) error) error {
+	f, err := s.fsys.Open(path.Join(s.metadata.UserPoolID, "users.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read users data: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	found, err := seekUsers(decoder)
+	if err != nil {
+		return fmt.Errorf("failed to read users data: %w", err)
+	}
+	if !found {
+		return nil
+	}
+
+	for decoder.More() {
+		var user pkgtypes.UserInfo
+		if err := decoder.Decode(&user); err != nil {
+			return fmt.Errorf("failed to read users data: %w", err)
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seekUsers は {"users":[...]} 形式のJSONの "users" 配列の先頭まで読み進める
+// ユーザーがいない場合はfalseを返す
+func seekUsers(decoder *json.Decoder) (bool, error) {
+	if _, err := decoder.Token(); err != nil {
+		return false, err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		if key == "users" {
+			token, err := decoder.Token()
+			if err != nil {
+				return false, err
+			}
+			// ユーザーがいない場合はnullになる
+			return token == json.Delim('['), nil
+		}
+
+		// 他のフィールドは読み飛ばす
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// hasFile はバックアップにファイルが含まれているかを返す
+func (s *BackupSource) hasFile(name string) bool {
+	for _, file := range s.metadata.BackupFiles {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSON はバックアップのファイルを読み込んでデコードする
+func (s *BackupSource) readJSON(ctx context.Context, name string, v interface{}) error {
+	data, err := fs.ReadFile(s.fsys, path.Join(s.metadata.UserPoolID, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// LiveSource は稼働中のユーザープールを復元元とするSource
+type LiveSource struct {
+	cognito    *aws.CognitoClient
+	userPoolID string
+}
+
+// NewLiveSource は新しいLiveSourceを作成する
+func NewLiveSource(cognito *aws.CognitoClient, userPoolID string) *LiveSource {
+	return &LiveSource{
+		cognito:    cognito,
+		userPoolID: userPoolID,
+	}
+}
+
+// UserPoolID は復元元のユーザープールのIDを返す
+func (s *LiveSource) UserPoolID() string {
+	return s.userPoolID
+}
+
+// PoolConfig はユーザープールの設定を取得する
+func (s *LiveSource) PoolConfig(ctx context.Context) (*types.UserPoolType, error) {
+	output, err := s.cognito.GetUserPoolConfiguration(ctx, s.userPoolID)
+	if err != nil {
+		return nil, err
+	}
+	return output.UserPool, nil
+}
+
+// Groups はグループの一覧を取得する
+func (s *LiveSource) Groups(ctx context.Context) ([]types.GroupType, error) {
+	return s.cognito.ListGroups(ctx, s.userPoolID)
+}
+
+// Clients はアプリクライアントの一覧を取得する
+func (s *LiveSource) Clients(ctx context.Context) ([]types.UserPoolClientType, error) {
+	return s.cognito.ListUserPoolClients(ctx, s.userPoolID)
+}
+
+// IdentityProviders は外部IDプロバイダーの一覧を取得する
+func (s *LiveSource) IdentityProviders(ctx context.Context) ([]types.IdentityProviderType, error) {
+	return s.cognito.ListIdentityProviders(ctx, s.userPoolID)
+}
+
+// Users はユーザーをページ単位で取得し、1件ずつfnに渡す
+func (s *LiveSource) Users(ctx context.Context, fn func(*pkgtypes.UserInfo) error) error {
+	// ユーザーごとにグループを取得する代わりに、グループごとの所属ユーザーから対応表を作成
+	groups, err := s.cognito.ListGroups(ctx, s.userPoolID)
+	if err != nil {
+		return err
+	}
+	memberships := make(map[string][]string)
+	for _, group := range groups {
+		usernames, err := s.cognito.ListUsersInGroup(ctx, s.userPoolID, *group.GroupName)
+		if err != nil {
+			return err
+		}
+		for _, username := range usernames {
+			memberships[username] = append(memberships[username], *group.GroupName)
+		}
+	}
+
+	return s.cognito.ListUsersPages(ctx, s.userPoolID, func(users []types.UserType) error {
+		for _, user := range users {
+			userInfo := aws.ToUserInfo(user, memberships[*user.Username])
+			if err := fn(&userInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -2,70 +2,71 @@ package restore
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/takaishi/acb/internal/aws"
-	"github.com/takaishi/acb/internal/storage"
 	pkgtypes "github.com/takaishi/acb/pkg/types"
 )
 
 // Users はユーザー情報の復元を管理する
 type Users struct {
 	cognito *aws.CognitoClient
-	storage storage.Storage
+	opts    Options
 }
 
 // NewUsers は新しいUsers構造体を作成する
-func NewUsers(cognito *aws.CognitoClient, storage storage.Storage) *Users {
+func NewUsers(cognito *aws.CognitoClient, opts Options) *Users {
 	return &Users{
 		cognito: cognito,
-		storage: storage,
+		opts:    opts,
 	}
 }
 
-// RestoreUsers はバックアップからユーザー情報を復元する
-func (u *Users) RestoreUsers(ctx context.Context, metadata *pkgtypes.BackupMetadata) error {
-	// ユーザー情報ファイルのパスを構築
-	usersPath := filepath.Join(metadata.UserPoolID, "users.json")
-
-	// ユーザー情報を読み込む
-	userData, err := u.storage.ReadFile(ctx, usersPath)
-	if err != nil {
-		return fmt.Errorf("failed to read users data: %w", err)
+// RestoreUsers は復元元のユーザー情報を復元し、復元前後のsubの対応を返す
+func (u *Users) RestoreUsers(ctx context.Context, src Source, targetPoolID string) ([]pkgtypes.SubMapping, error) {
+	// 元のsubを保存するカスタム属性を用意
+	if u.opts.LegacySubAttribute != "" {
+		if err := u.cognito.EnsureCustomAttribute(ctx, targetPoolID, u.opts.LegacySubAttribute); err != nil {
+			return nil, err
+		}
 	}
 
-	var usersBackup pkgtypes.UsersBackup
-	if err := json.Unmarshal(userData, &usersBackup); err != nil {
-		return fmt.Errorf("failed to unmarshal users data: %w", err)
-	}
+	// ユーザーを1件ずつ復元
+	var mappings []pkgtypes.SubMapping
+	err := src.Users(ctx, func(user *pkgtypes.UserInfo) error {
+		if u.opts.UserPattern != nil && !u.opts.UserPattern.MatchString(user.Username) {
+			return nil
+		}
 
-	// ユーザーを一括で復元
-	for _, user := range usersBackup.Users {
-		if err := u.restoreUser(ctx, metadata.UserPoolID, &user); err != nil {
+		newSub, err := u.RestoreUser(ctx, targetPoolID, user)
+		if err != nil {
+			if errors.Is(err, ErrConflict) {
+				return err
+			}
 			fmt.Printf("Warning: failed to restore user %s: %v\n", user.Username, err)
-			continue
+			return nil
 		}
-	}
 
-	return nil
+		oldSub, _ := user.Attribute("sub")
+		mappings = append(mappings, pkgtypes.SubMapping{
+			SourceUserPoolID: src.UserPoolID(),
+			TargetUserPoolID: targetPoolID,
+			Username:         user.Username,
+			OldSub:           oldSub,
+			NewSub:           newSub,
+		})
+		return nil
+	})
+	return mappings, err
 }
 
-// restoreUser は単一のユーザーを復元する
-func (u *Users) restoreUser(ctx context.Context, userPoolID string, user *pkgtypes.UserInfo) error {
-	// ユーザー属性を変換
-	var userAttrs []types.AttributeType
-	for _, attr := range user.Attributes {
-		name := attr["Name"].(string)
-		value := attr["Value"].(string)
-		userAttrs = append(userAttrs, types.AttributeType{
-			Name:  &name,
-			Value: &value,
-		})
-	}
+// RestoreUser は単一のユーザーを復元し、復元先でのsubを返す
+func (u *Users) RestoreUser(ctx context.Context, userPoolID string, user *pkgtypes.UserInfo) (string, error) {
+	userAttrs := u.userAttributes(user)
 
 	// ユーザーを作成
 	input := &cognitoidentityprovider.AdminCreateUserInput{
@@ -76,9 +77,42 @@ func (u *Users) restoreUser(ctx context.Context, userPoolID string, user *pkgtyp
 		DesiredDeliveryMediums: []types.DeliveryMediumType{types.DeliveryMediumTypeEmail},
 	}
 
-	_, err := u.cognito.CreateUser(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+	var newSub string
+	overwritten := false
+	output, err := u.cognito.CreateUser(ctx, input)
+	if err == nil {
+		newSub = subAttribute(output.User.Attributes)
+	} else {
+		var exists *types.UsernameExistsException
+		if !errors.As(err, &exists) {
+			return "", fmt.Errorf("failed to create user: %w", err)
+		}
+
+		switch u.opts.Conflict {
+		case ConflictFail:
+			return "", fmt.Errorf("%w: user %s", ErrConflict, user.Username)
+		case ConflictOverwrite:
+			if _, err := u.cognito.UpdateUserAttributes(ctx, &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+				UserPoolId:     &userPoolID,
+				Username:       &user.Username,
+				UserAttributes: userAttrs,
+			}); err != nil {
+				return "", err
+			}
+			overwritten = true
+		default:
+			fmt.Printf("Skipping existing user %s\n", user.Username)
+		}
+
+		existing, err := u.cognito.GetUser(ctx, userPoolID, user.Username)
+		if err != nil {
+			return "", err
+		}
+		newSub = subAttribute(existing.UserAttributes)
+
+		if !overwritten {
+			return newSub, nil
+		}
 	}
 
 	// グループメンバーシップを復元
@@ -94,5 +128,55 @@ func (u *Users) restoreUser(ctx context.Context, userPoolID string, user *pkgtyp
 		}
 	}
 
-	return nil
+	// 無効化されていたユーザーは無効化した状態で復元
+	if !user.IsEnabled() {
+		if err := u.cognito.DisableUser(ctx, userPoolID, user.Username); err != nil {
+			fmt.Printf("Warning: failed to disable user %s: %v\n", user.Username, err)
+		}
+	} else if overwritten {
+		if err := u.cognito.EnableUser(ctx, userPoolID, user.Username); err != nil {
+			fmt.Printf("Warning: failed to enable user %s: %v\n", user.Username, err)
+		}
+	}
+
+	return newSub, nil
+}
+
+// userAttributes は復元先に設定するユーザー属性を返す
+func (u *Users) userAttributes(user *pkgtypes.UserInfo) []types.AttributeType {
+	// ユーザー属性を変換
+	var userAttrs []types.AttributeType
+	for _, attr := range user.Attributes {
+		name := attr["Name"].(string)
+		value := attr["Value"].(string)
+		// subはCognitoが割り当てるため指定できない
+		if name == "sub" {
+			continue
+		}
+		userAttrs = append(userAttrs, types.AttributeType{
+			Name:  &name,
+			Value: &value,
+		})
+	}
+
+	// 元のsubをカスタム属性に保存
+	if oldSub, ok := user.Attribute("sub"); u.opts.LegacySubAttribute != "" && ok {
+		name := "custom:" + strings.TrimPrefix(u.opts.LegacySubAttribute, "custom:")
+		userAttrs = append(userAttrs, types.AttributeType{
+			Name:  &name,
+			Value: &oldSub,
+		})
+	}
+
+	return userAttrs
+}
+
+// subAttribute はユーザー属性からsubを取得する
+func subAttribute(attributes []types.AttributeType) string {
+	for _, attr := range attributes {
+		if attr.Name != nil && *attr.Name == "sub" && attr.Value != nil {
+			return *attr.Value
+		}
+	}
+	return ""
 }
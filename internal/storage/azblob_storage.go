@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+func init() {
+	Register(&Backend{
+		Scheme: "azblob",
+		Capabilities: Capabilities{
+			List:      true,
+			Delete:    true,
+			Streaming: true,
+		},
+		Parse: parseAzureBlobURI,
+		New: func(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+			return NewAzureBlobStorage(loc.Bucket, opts.Azure)
+		},
+	})
+}
+
+// parseAzureBlobURI はAzure BlobのURI (azblob://container/prefix/file.tar.gz) を解析する
+func parseAzureBlobURI(uri string) (*Location, error) {
+	container, key, ok := strings.Cut(strings.TrimPrefix(uri, "azblob://"), "/")
+	if !ok || container == "" {
+		return nil, fmt.Errorf("invalid Azure Blob URI format: %s", uri)
+	}
+	return &Location{
+		Bucket: container,
+		Path:   key,
+	}, nil
+}
+
+// AzureOptions はAzure Blob Storageクライアントの設定を表す
+// 空の項目はAzure CLIと同じ環境変数から読み込む
+type AzureOptions struct {
+	Account  string // ストレージアカウント名（AZURE_STORAGE_ACCOUNT）
+	Key      string // 共有キー認証のアカウントキー（AZURE_STORAGE_KEY）
+	SASToken string // SASトークン（AZURE_STORAGE_SAS_TOKEN）
+	Endpoint string // BlobサービスのURL（空の場合は https://<account>.blob.core.windows.net/）
+}
+
+// withEnv は空の項目を環境変数の値で補完したオプションを返す
+func (o AzureOptions) withEnv() AzureOptions {
+	if o.Account == "" {
+		o.Account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if o.Key == "" {
+		o.Key = os.Getenv("AZURE_STORAGE_KEY")
+	}
+	if o.SASToken == "" {
+		o.SASToken = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	return o
+}
+
+// serviceURL はBlobサービスのURLを返す
+func (o AzureOptions) serviceURL() string {
+	if o.Endpoint != "" {
+		return strings.TrimSuffix(o.Endpoint, "/") + "/"
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", o.Account)
+}
+
+// AzureBlobStorage はAzure Blob Storageへの保存を実装
+type AzureBlobStorage struct {
+	client    *azblob.Client
+	container string
+}
+
+// NewAzureBlobStorage は新しいAzureBlobStorageを作成する
+// アカウントキーが設定されている場合は共有キー認証、そうでない場合はSASトークンで認証する
+func NewAzureBlobStorage(container string, opts AzureOptions) (*AzureBlobStorage, error) {
+	opts = opts.withEnv()
+	if opts.Account == "" && opts.Endpoint == "" {
+		return nil, fmt.Errorf("Azure storage account is not specified (set AZURE_STORAGE_ACCOUNT)")
+	}
+
+	var client *azblob.Client
+	switch {
+	case opts.Key != "":
+		if opts.Account == "" {
+			return nil, fmt.Errorf("shared key authentication requires the Azure storage account name")
+		}
+		cred, err := azblob.NewSharedKeyCredential(opts.Account, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure storage account key: %w", err)
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(opts.serviceURL(), cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Blob client: %w", err)
+		}
+	case opts.SASToken != "":
+		var err error
+		client, err = azblob.NewClientWithNoCredential(opts.serviceURL()+"?"+strings.TrimPrefix(opts.SASToken, "?"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Blob client: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("Azure credentials are not specified (set AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN)")
+	}
+
+	return &AzureBlobStorage{
+		client:    client,
+		container: container,
+	}, nil
+}
+
+// Create はブロックBlobに書き込むライターを返す
+// 書き込んだデータはブロック単位でアップロードされ、Closeでブロックの一覧をコミットする
+func (s *AzureBlobStorage) Create(ctx context.Context, key string) (Writer, error) {
+	pr, w := newPipeWriter("Azure Blob")
+
+	go func() {
+		_, err := s.client.UploadStream(ctx, s.container, key, pr, &blockblob.UploadStreamOptions{
+			BlockSize:   uploadPartSize,
+			Concurrency: uploadConcurrency,
+		})
+		// アップロードが失敗した場合は書き込み側にエラーを返す
+		// コミットされなかったブロックはAzureが破棄する
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+// Open はBlobを読み込むリーダーを返す
+func (s *AzureBlobStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.client.DownloadStream(ctx, s.container, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from Azure Blob: %w", azblobNotExist(key, err))
+	}
+	return resp.Body, nil
+}
+
+// List はprefix以下のBlobのキーの一覧を返す
+func (s *AzureBlobStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var keys []string
+	pager := s.client.NewListBlobsFlatPager(s.container, &azblob.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			keys = append(keys, *item.Name)
+		}
+	}
+	return keys, nil
+}
+
+// Delete はBlobを削除する
+func (s *AzureBlobStorage) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteBlob(ctx, s.container, key, nil); err != nil {
+		return fmt.Errorf("failed to delete from Azure Blob: %w", azblobNotExist(key, err))
+	}
+	return nil
+}
+
+// azblobNotExist はBlobが存在しないことによるエラーをfs.ErrNotExistに変換する
+func azblobNotExist(key string, err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return err
+}
+
+// WriteFile はファイルをAzure Blobに保存する
+func (s *AzureBlobStorage) WriteFile(ctx context.Context, key string, data []byte) error {
+	if _, err := s.client.UploadBuffer(ctx, s.container, key, data, nil); err != nil {
+		return fmt.Errorf("failed to upload to Azure Blob: %w", err)
+	}
+	return nil
+}
+
+// ReadFile はAzure Blobからファイルを読み込む
+func (s *AzureBlobStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsChunkSize はレジューマブルアップロードで1回に送信するサイズ
+// 送信が失敗した場合はチャンク単位で再送される
+const gcsChunkSize = 16 * 1024 * 1024
+
+func init() {
+	Register(&Backend{
+		Scheme: "gs",
+		Capabilities: Capabilities{
+			List:      true,
+			Delete:    true,
+			Streaming: true,
+		},
+		Parse: parseGCSURI,
+		New: func(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+			return NewGCSStorage(ctx, loc.Bucket, opts.GCS)
+		},
+	})
+}
+
+// parseGCSURI はGCSのURI (gs://bucket/prefix/file.tar.gz) を解析する
+func parseGCSURI(uri string) (*Location, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("invalid GCS URI format: %s", uri)
+	}
+	return &Location{
+		Bucket: bucket,
+		Path:   key,
+	}, nil
+}
+
+// GCSOptions はGCSクライアントの設定を表す
+// 認証情報はApplication Default Credentialsから読み込む
+type GCSOptions struct {
+	Endpoint string // JSON APIのエンドポイントURL（空の場合は既定のエンドポイント）
+}
+
+// GCSStorage はGoogle Cloud Storageへの保存を実装
+type GCSStorage struct {
+	client *gcs.Client
+	bucket *gcs.BucketHandle
+}
+
+// NewGCSStorage は新しいGCSStorageを作成する
+// 環境変数 STORAGE_EMULATOR_HOST が設定されている場合は、認証なしでエミュレーターに接続する
+func NewGCSStorage(ctx context.Context, bucket string, opts GCSOptions) (*GCSStorage, error) {
+	var clientOpts []option.ClientOption
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(opts.Endpoint))
+	}
+
+	client, err := gcs.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &GCSStorage{
+		client: client,
+		bucket: client.Bucket(bucket),
+	}, nil
+}
+
+// Create はGCSオブジェクトに書き込むライターを返す
+// 書き込んだデータはレジューマブルアップロードでチャンク単位に送信される
+func (s *GCSStorage) Create(ctx context.Context, key string) (Writer, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ChunkSize = gcsChunkSize
+	return &gcsWriter{
+		w:      w,
+		cancel: cancel,
+	}, nil
+}
+
+// Open はGCSオブジェクトを読み込むリーダーを返す
+func (s *GCSStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from GCS: %w", gcsNotExist(key, err))
+	}
+	return r, nil
+}
+
+// List はprefix以下のオブジェクトのキーの一覧を返す
+func (s *GCSStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	query := &gcs.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var keys []string
+	it := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		keys = append(keys, attrs.Name)
+	}
+	return keys, nil
+}
+
+// Delete はGCSオブジェクトを削除する
+func (s *GCSStorage) Delete(ctx context.Context, key string) error {
+	if err := s.bucket.Object(key).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete from GCS: %w", gcsNotExist(key, err))
+	}
+	return nil
+}
+
+// gcsNotExist はオブジェクトが存在しないことによるエラーをfs.ErrNotExistに変換する
+func gcsNotExist(key string, err error) error {
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return err
+}
+
+// WriteFile はファイルをGCSに保存する
+func (s *GCSStorage) WriteFile(ctx context.Context, key string, data []byte) error {
+	w := s.bucket.Object(key).NewWriter(ctx)
+	// 小さいファイルは1回のリクエストでアップロードする
+	w.ChunkSize = 0
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	return nil
+}
+
+// ReadFile はGCSからファイルを読み込む
+func (s *GCSStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, nil
+}
+
+// gcsWriter はレジューマブルアップロードへの書き込みを表す
+type gcsWriter struct {
+	w      *gcs.Writer
+	cancel context.CancelFunc
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Close は書き込みを終了し、アップロードを完了する
+func (w *gcsWriter) Close() error {
+	defer w.cancel()
+	if err := w.w.Close(); err != nil {
+		return fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	return nil
+}
+
+// Abort はアップロードを中止する
+// 完了していないレジューマブルアップロードはオブジェクトとして保存されない
+func (w *gcsWriter) Abort() error {
+	w.cancel()
+	w.w.Close()
+	return nil
+}
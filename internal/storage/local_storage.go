@@ -1,149 +1,108 @@
 package storage
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
-
-	"github.com/takaishi/acb/internal/encryption"
 )
 
-// LocalStorage はローカルファイルシステムへの保存を実装
-type LocalStorage struct {
-	backupData *BackupData
-	encryptor  Encryptor
+func init() {
+	Register(&Backend{
+		Scheme: "file",
+		Capabilities: Capabilities{
+			List:      true,
+			Delete:    true,
+			Streaming: true,
+		},
+		Parse: func(uri string) (*Location, error) {
+			// file:///path/to/file.tar.gz
+			return &Location{
+				Path: strings.TrimPrefix(uri, "file://"),
+			}, nil
+		},
+		New: func(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+			return NewLocalStorage()
+		},
+	})
 }
 
+// LocalStorage はローカルファイルシステムへの保存を実装
+type LocalStorage struct{}
+
 // NewLocalStorage は新しいLocalStorageを作成する
 func NewLocalStorage() (*LocalStorage, error) {
-	return &LocalStorage{
-		backupData: NewBackupData(),
-	}, nil
+	return &LocalStorage{}, nil
 }
 
-// SaveJSON はJSONデータをローカルファイルシステムに保存する
-func (s *LocalStorage) SaveJSON(ctx context.Context, prefix, userPoolID string, filename string, data interface{}) error {
-	// JSONエンコード
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to encode JSON: %w", err)
+// Create はファイルに書き込むライターを返す
+// 同じディレクトリの一時ファイルに書き込み、Closeでリネームする
+func (s *LocalStorage) Create(ctx context.Context, key string) (Writer, error) {
+	// ディレクトリを作成
+	dir := filepath.Dir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// メモリ上にデータを保存
-	if _, exists := s.backupData.Files[userPoolID]; !exists {
-		s.backupData.Files[userPoolID] = make([]BackupFile, 0)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(key)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	s.backupData.Files[userPoolID] = append(s.backupData.Files[userPoolID], BackupFile{
-		Name:    filename,
-		Content: jsonData,
-	})
-
-	return nil
+	return &localWriter{File: f, key: key}, nil
 }
 
-// ReadFile はローカルファイルシステムからファイルを読み込む
-func (s *LocalStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
-	data, err := os.ReadFile(key)
+// Open はファイルを読み込むリーダーを返す
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	return f, nil
+}
 
-	// 暗号化されているかチェックして復号化
-	if s.encryptor != nil && isEncryptedData(data) {
-		encryptedData, err := encryption.DeserializeEncryptedData(data)
+// List はディレクトリprefix以下のファイルのパスの一覧を返す
+// ディレクトリが存在しない場合は空の一覧を返す
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(prefix, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return nil, fmt.Errorf("failed to deserialize encrypted data: %w", err)
+			if errors.Is(err, fs.ErrNotExist) && path == prefix {
+				return filepath.SkipDir
+			}
+			return err
 		}
-
-		decryptedData, err := s.encryptor.Decrypt(ctx, encryptedData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		// 書き込み中の一時ファイルは除く
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
 		}
-		return decryptedData, nil
+		keys = append(keys, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
 	}
-
-	return data, nil
+	return keys, nil
 }
 
-// CreateTarGz はディレクトリをtar.gz形式で圧縮する
-func (s *LocalStorage) CreateTarGz(ctx context.Context, path string) error {
-	fmt.Printf("path: %s\n", path)
-	// file:// スキームを除去
-	path = strings.TrimPrefix(path, "file://")
-	// .tar.gz 拡張子を除去
-	path = strings.TrimSuffix(path, ".tar.gz")
-
-	// メモリ上でtar.gzを作成
-	var buf bytes.Buffer
-	gw := gzip.NewWriter(&buf)
-	tw := tar.NewWriter(gw)
-
-	// 各ユーザープールのファイルを処理
-	for userPoolID, files := range s.backupData.Files {
-		for _, file := range files {
-			// tarヘッダーを作成
-			header := &tar.Header{
-				Name:    filepath.Join(userPoolID, file.Name),
-				Size:    int64(len(file.Content)),
-				Mode:    0644,
-				ModTime: time.Now(),
-			}
-
-			// tarヘッダーを書き込み
-			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header: %w", err)
-			}
-
-			// ファイルの内容を書き込み
-			if _, err := tw.Write(file.Content); err != nil {
-				return fmt.Errorf("failed to write file content: %w", err)
-			}
-		}
-	}
-
-	// ライターをクローズ
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+// Delete はファイルを削除する
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(key); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
 	}
-	if err := gw.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
-	}
-
-	// ファイルに書き込み
-	targetFile := path + ".tar.gz"
-	fmt.Printf("targetFile: %s\n", targetFile)
-
-	// ディレクトリを作成
-	targetDir := filepath.Dir(targetFile)
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// 暗号化が有効な場合は暗号化してから保存
-	finalData := buf.Bytes()
-	if s.encryptor != nil {
-		// データを暗号化
-		encryptedData, err := s.encryptor.Encrypt(ctx, finalData)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt data: %w", err)
-		}
+	return nil
+}
 
-		// バイナリ形式にシリアライズ
-		serializedData, err := encryption.SerializeEncryptedData(encryptedData)
-		if err != nil {
-			return fmt.Errorf("failed to serialize encrypted data: %w", err)
-		}
-		finalData = serializedData
+// ReadFile はローカルファイルシステムからファイルを読み込む
+func (s *LocalStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-
-	return s.WriteFile(ctx, targetFile, finalData)
+	return data, nil
 }
 
 // WriteFile はファイルをローカルファイルシステムに保存する
@@ -156,12 +115,31 @@ func (s *LocalStorage) WriteFile(ctx context.Context, key string, data []byte) e
 	return os.WriteFile(key, data, 0644)
 }
 
-// GetBackupData はバックアップデータを返す
-func (s *LocalStorage) GetBackupData() *BackupData {
-	return s.backupData
+// localWriter は一時ファイルへの書き込みを表す
+type localWriter struct {
+	*os.File
+	key string
+}
+
+// Close は一時ファイルを保存先にリネームする
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(w.Name(), 0644); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(w.Name(), w.key); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
 }
 
-// SetEncryptor は暗号化処理を設定する
-func (s *LocalStorage) SetEncryptor(encryptor Encryptor) {
-	s.encryptor = encryptor
+// Abort は一時ファイルを削除する
+func (w *localWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
 }
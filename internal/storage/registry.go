@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	acbaws "github.com/takaishi/acb/internal/aws"
+)
+
+// StdioURI は標準入出力を表すURI
+const StdioURI = "-"
+
+// Capabilities はバックエンドが対応する操作を表す
+type Capabilities struct {
+	List      bool // キーの一覧を取得できる（カタログの読み込みに必要）
+	Delete    bool // キーを削除できる（バックアップの削除に必要）
+	Streaming bool // データ全体をメモリに保持せずに読み書きできる
+}
+
+// Location はURIが指す保存先を表す
+type Location struct {
+	URI    string // 元のURI
+	Scheme string // URIのスキーム（標準入出力の場合は "-"）
+	Bucket string // バケット名（バケットを持たないバックエンドの場合は空）
+	Path   string // バケット内のキー、またはファイルパス
+}
+
+// Options はストレージの作成に使用する設定を表す
+// 各バックエンドは必要な項目のみを使用する
+type Options struct {
+	AWS     acbaws.ClientOptions // S3の認証情報とエンドポイント
+	S3Write S3WriteOptions       // S3に書き込むオブジェクトの設定
+	GCS     GCSOptions           // GCSのエンドポイント
+	Azure   AzureOptions         // Azure Blob Storageのアカウントと認証情報
+
+	Stdin  io.Reader // 標準入出力のバックエンドが読み込むリーダー
+	Stdout io.Writer // 標準入出力のバックエンドが書き込むライター
+}
+
+// Backend はURIのスキームに対応するストレージのバックエンドを表す
+type Backend struct {
+	// Scheme はURIのスキーム（例: "s3"）
+	Scheme string
+
+	// Capabilities はバックエンドが対応する操作
+	Capabilities Capabilities
+
+	// Parse はURIを解析して保存先を返す
+	Parse func(uri string) (*Location, error)
+
+	// New は保存先のストレージを作成する
+	New func(ctx context.Context, loc *Location, opts Options) (Storage, error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]*Backend)
+)
+
+// Register はバックエンドを登録する
+// 同じスキームのバックエンドがすでに登録されている場合はパニックする
+func Register(backend *Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[backend.Scheme]; ok {
+		panic(fmt.Sprintf("storage: backend for scheme %q is already registered", backend.Scheme))
+	}
+	backends[backend.Scheme] = backend
+}
+
+// Lookup はスキームに対応するバックエンドを返す
+func Lookup(scheme string) (*Backend, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	backend, ok := backends[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage scheme: %s (supported: %s)", scheme, strings.Join(schemes(), ", "))
+	}
+	return backend, nil
+}
+
+// schemes は登録されているスキームの一覧を返す
+func schemes() []string {
+	var names []string
+	for scheme := range backends {
+		if scheme == StdioURI {
+			continue
+		}
+		names = append(names, scheme+"://")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseURI はURIを解析し、対応するバックエンドでの保存先を返す
+// "-" は標準入出力を表す
+func ParseURI(uri string) (*Location, error) {
+	if uri == "" {
+		return nil, fmt.Errorf("URI is not specified")
+	}
+
+	scheme := StdioURI
+	if uri != StdioURI {
+		var ok bool
+		scheme, _, ok = strings.Cut(uri, "://")
+		if !ok {
+			return nil, fmt.Errorf("invalid URI format: %s", uri)
+		}
+	}
+
+	backend, err := Lookup(scheme)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := backend.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	loc.URI = uri
+	loc.Scheme = scheme
+	return loc, nil
+}
+
+// Capabilities は保存先のバックエンドが対応する操作を返す
+func (l *Location) Capabilities() Capabilities {
+	backend, err := Lookup(l.Scheme)
+	if err != nil {
+		return Capabilities{}
+	}
+	return backend.Capabilities
+}
+
+// IsStdio は保存先が標準入出力かを返す
+func (l *Location) IsStdio() bool {
+	return l.Scheme == StdioURI
+}
+
+// Open は保存先のバックエンドのストレージを作成する
+func Open(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+	backend, err := Lookup(loc.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	store, err := backend.New(ctx, loc, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s storage: %w", loc.Scheme, err)
+	}
+	return store, nil
+}
@@ -1,146 +1,255 @@
 package storage
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/takaishi/acb/internal/encryption"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	acbaws "github.com/takaishi/acb/internal/aws"
 )
 
+const (
+	// マルチパートアップロードのパートサイズ（最大10,000パートのため、160GBまでアップロードできる）
+	uploadPartSize = 16 * 1024 * 1024
+	// 同時にアップロードするパート数（メモリ使用量はパートサイズ×同時実行数に収まる）
+	uploadConcurrency = 4
+)
+
+func init() {
+	Register(&Backend{
+		Scheme: "s3",
+		Capabilities: Capabilities{
+			List:      true,
+			Delete:    true,
+			Streaming: true,
+		},
+		Parse: parseS3URI,
+		New: func(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+			s, err := NewS3Storage(ctx, loc.Bucket, opts.AWS)
+			if err != nil {
+				return nil, err
+			}
+			s.SetWriteOptions(opts.S3Write)
+			return s, nil
+		},
+	})
+}
+
+// parseS3URI はS3のURI (s3://bucket/prefix/file.tar.gz) を解析する
+func parseS3URI(uri string) (*Location, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid S3 URI format: %s", uri)
+	}
+	return &Location{
+		Bucket: bucket,
+		Path:   key,
+	}, nil
+}
+
 // S3Storage はS3への保存を実装
 type S3Storage struct {
-	client     *s3.Client
-	bucket     string
-	usePrefix  bool
-	backupData *BackupData
-	encryptor  Encryptor
+	client       *s3.Client
+	bucket       string
+	writeOptions S3WriteOptions
+}
+
+// S3WriteOptions はS3に書き込むオブジェクトに設定するオプションを表す
+// 空の項目はバケットの既定の設定に従う
+type S3WriteOptions struct {
+	SSEKMSKeyID         string            // SSE-KMSで暗号化するKMSキーID
+	BucketKey           bool              // SSE-KMSでS3バケットキーを使用する
+	StorageClass        string            // ストレージクラス（例: GLACIER_IR）
+	ObjectLockMode      string            // オブジェクトロックのモード（GOVERNANCE または COMPLIANCE）
+	ObjectLockRetention time.Duration     // 書き込みからオブジェクトロックで保持する期間
+	Tags                map[string]string // オブジェクトタグ
+	ChecksumAlgorithm   string            // チェックサムアルゴリズム（例: SHA256）
+}
+
+// Validate はオプションの値が正しいかを検証する
+func (o S3WriteOptions) Validate() error {
+	if o.BucketKey && o.SSEKMSKeyID == "" {
+		return fmt.Errorf("bucket key requires an SSE-KMS key ID")
+	}
+	if o.StorageClass != "" && !slices.Contains(s3types.StorageClass("").Values(), s3types.StorageClass(o.StorageClass)) {
+		return fmt.Errorf("invalid storage class: %s", o.StorageClass)
+	}
+	if o.ObjectLockMode != "" && !slices.Contains(s3types.ObjectLockMode("").Values(), s3types.ObjectLockMode(o.ObjectLockMode)) {
+		return fmt.Errorf("invalid object lock mode: %s", o.ObjectLockMode)
+	}
+	if (o.ObjectLockMode == "") != (o.ObjectLockRetention == 0) {
+		return fmt.Errorf("object lock mode and retention must be specified together")
+	}
+	if o.ChecksumAlgorithm != "" && !slices.Contains(s3types.ChecksumAlgorithm("").Values(), s3types.ChecksumAlgorithm(o.ChecksumAlgorithm)) {
+		return fmt.Errorf("invalid checksum algorithm: %s", o.ChecksumAlgorithm)
+	}
+	return nil
+}
+
+// apply はオプションをPutObjectの入力に設定する
+func (o S3WriteOptions) apply(input *s3.PutObjectInput) {
+	if o.SSEKMSKeyID != "" {
+		input.ServerSideEncryption = s3types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(o.SSEKMSKeyID)
+		if o.BucketKey {
+			input.BucketKeyEnabled = aws.Bool(true)
+		}
+	}
+	if o.StorageClass != "" {
+		input.StorageClass = s3types.StorageClass(o.StorageClass)
+	}
+	if o.ObjectLockMode != "" {
+		// 保持期限は書き込むオブジェクトごとに書き込み時点から計算する
+		input.ObjectLockMode = s3types.ObjectLockMode(o.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(o.ObjectLockRetention))
+	}
+	if len(o.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range o.Tags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if o.ChecksumAlgorithm != "" {
+		input.ChecksumAlgorithm = s3types.ChecksumAlgorithm(o.ChecksumAlgorithm)
+	}
 }
 
 // NewS3Storage は新しいS3Storageを作成する
-func NewS3Storage(ctx context.Context, bucket string) (*S3Storage, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func NewS3Storage(ctx context.Context, bucket string, opts acbaws.ClientOptions) (*S3Storage, error) {
+	cfg, err := acbaws.LoadConfig(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 	}
 
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(cfg, opts.S3Options)
 
-	bucketLocation, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
-		Bucket: &bucket,
-	})
-	if err != nil {
-		return nil, err
-	}
-	if client.Options().Region != string(bucketLocation.LocationConstraint) {
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(string(bucketLocation.LocationConstraint)))
+	// リージョンが指定されていない場合はバケットのリージョンに切り替える
+	if opts.Region == "" {
+		bucketLocation, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+			Bucket: &bucket,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+			return nil, err
+		}
+		if client.Options().Region != string(bucketLocation.LocationConstraint) {
+			cfg, err := acbaws.LoadConfig(ctx, opts, config.WithRegion(string(bucketLocation.LocationConstraint)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+			}
+			client = s3.NewFromConfig(cfg, opts.S3Options)
 		}
-		client = s3.NewFromConfig(cfg)
 	}
 	return &S3Storage{
-		client:     client,
-		bucket:     bucket,
-		backupData: NewBackupData(),
+		client: client,
+		bucket: bucket,
 	}, nil
 }
 
-// SaveJSON はJSONデータをS3に保存する
-func (s *S3Storage) SaveJSON(ctx context.Context, prefix, userPoolID string, filename string, data interface{}) error {
-	// JSONエンコード
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to encode JSON: %w", err)
-	}
+// SetWriteOptions は書き込むすべてのオブジェクトに設定するオプションを設定する
+func (s *S3Storage) SetWriteOptions(opts S3WriteOptions) {
+	s.writeOptions = opts
+}
 
-	// メモリ上にデータを保存
-	if _, exists := s.backupData.Files[userPoolID]; !exists {
-		s.backupData.Files[userPoolID] = make([]BackupFile, 0)
-	}
-	s.backupData.Files[userPoolID] = append(s.backupData.Files[userPoolID], BackupFile{
-		Name:    filename,
-		Content: jsonData,
-	})
+// Create はS3オブジェクトに書き込むライターを返す
+// 書き込んだデータはパート単位でマルチパートアップロードされる
+func (s *S3Storage) Create(ctx context.Context, key string) (Writer, error) {
+	pr, w := newPipeWriter("S3")
 
-	return nil
-}
+	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
+		u.PartSize = uploadPartSize
+		u.Concurrency = uploadConcurrency
+	})
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   pr,
+	}
+	s.writeOptions.apply(input)
 
-// CreateTarGz はディレクトリをtar.gz形式で圧縮する
-func (s *S3Storage) CreateTarGz(ctx context.Context, prefix string) error {
-	// メモリ上でtar.gzを作成
-	var buf bytes.Buffer
-	gw := gzip.NewWriter(&buf)
-	tw := tar.NewWriter(gw)
-
-	// 各ユーザープールのファイルを処理
-	for userPoolID, files := range s.backupData.Files {
-		for _, file := range files {
-			// tarヘッダーを作成
-			header := &tar.Header{
-				Name:    filepath.Join(userPoolID, file.Name),
-				Size:    int64(len(file.Content)),
-				Mode:    0644,
-				ModTime: time.Now(),
-			}
+	go func() {
+		_, err := uploader.Upload(ctx, input)
+		// アップロードが失敗した場合は書き込み側にエラーを返す
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
 
-			// tarヘッダーを書き込み
-			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header: %w", err)
-			}
+	return w, nil
+}
 
-			// ファイルの内容を書き込み
-			if _, err := tw.Write(file.Content); err != nil {
-				return fmt.Errorf("failed to write file content: %w", err)
-			}
+// Open はS3オブジェクトを読み込むリーダーを返す
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("failed to download from S3: %s: %w", key, fs.ErrNotExist)
 		}
+		return nil, fmt.Errorf("failed to download from S3: %w", err)
 	}
+	return output.Body, nil
+}
 
-	// ライターをクローズ
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
-	}
-	if err := gw.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
+// List はprefix以下のオブジェクトのキーの一覧を返す
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
 	}
 
-	// 暗号化が有効な場合は暗号化してから保存
-	finalData := buf.Bytes()
-	if s.encryptor != nil {
-		// データを暗号化
-		encryptedData, err := s.encryptor.Encrypt(ctx, finalData)
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt data: %w", err)
+			return nil, fmt.Errorf("failed to list objects: %w", err)
 		}
-
-		// バイナリ形式にシリアライズ
-		serializedData, err := encryption.SerializeEncryptedData(encryptedData)
-		if err != nil {
-			return fmt.Errorf("failed to serialize encrypted data: %w", err)
+		for _, obj := range output.Contents {
+			keys = append(keys, *obj.Key)
 		}
-		finalData = serializedData
 	}
+	return keys, nil
+}
 
-	// S3にアップロード
-	return s.WriteFile(ctx, prefix, finalData)
+// Delete はS3オブジェクトを削除する
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+	return nil
 }
 
 // WriteFile はファイルをS3に保存する
 func (s *S3Storage) WriteFile(ctx context.Context, key string, data []byte) error {
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: &s.bucket,
 		Key:    &key,
 		Body:   bytes.NewReader(data),
-	})
+	}
+	s.writeOptions.apply(input)
+
+	_, err := s.client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload to S3: %w", err)
 	}
@@ -149,44 +258,15 @@ func (s *S3Storage) WriteFile(ctx context.Context, key string, data []byte) erro
 
 // ReadFile はS3からファイルを読み込む
 func (s *S3Storage) ReadFile(ctx context.Context, key string) ([]byte, error) {
-	fmt.Printf("bucket: %s, key: %s\n", s.bucket, key)
-	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-	})
+	body, err := s.Open(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download from S3: %w", err)
+		return nil, err
 	}
-	defer output.Body.Close()
+	defer body.Close()
 
-	data, err := io.ReadAll(output.Body)
+	data, err := io.ReadAll(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-
-	// 暗号化されているかチェックして復号化
-	if s.encryptor != nil && isEncryptedData(data) {
-		encryptedData, err := encryption.DeserializeEncryptedData(data)
-		if err != nil {
-			return nil, fmt.Errorf("failed to deserialize encrypted data: %w", err)
-		}
-
-		decryptedData, err := s.encryptor.Decrypt(ctx, encryptedData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt data: %w", err)
-		}
-		return decryptedData, nil
-	}
-
 	return data, nil
 }
-
-// GetBackupData はバックアップデータを返す
-func (s *S3Storage) GetBackupData() *BackupData {
-	return s.backupData
-}
-
-// SetEncryptor は暗号化処理を設定する
-func (s *S3Storage) SetEncryptor(encryptor Encryptor) {
-	s.encryptor = encryptor
-}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+func init() {
+	Register(&Backend{
+		Scheme: StdioURI,
+		Capabilities: Capabilities{
+			Streaming: true,
+		},
+		Parse: func(uri string) (*Location, error) {
+			return &Location{
+				Path: StdioURI,
+			}, nil
+		},
+		New: func(ctx context.Context, loc *Location, opts Options) (Storage, error) {
+			return NewStdioStorage(opts.Stdin, opts.Stdout), nil
+		},
+	})
+}
+
+// StdioStorage は標準入力から読み込み、標準出力に書き込むストレージを実装
+// パイプで他のツールと連携するためのもので、キーは無視される
+// ストリームは1つしかないため、書き込みと読み込みはそれぞれ1回のみできる
+type StdioStorage struct {
+	mu      sync.Mutex
+	in      io.Reader
+	out     io.Writer
+	opened  bool
+	created bool
+}
+
+// NewStdioStorage は新しいStdioStorageを作成する
+func NewStdioStorage(in io.Reader, out io.Writer) *StdioStorage {
+	return &StdioStorage{
+		in:  in,
+		out: out,
+	}
+}
+
+// Create は標準出力に書き込むライターを返す
+// 書き込んだデータは取り消せないため、Abortしても出力済みのデータは残る
+func (s *StdioStorage) Create(ctx context.Context, key string) (Writer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.created {
+		return nil, errors.New("stdout can only hold a single archive")
+	}
+	s.created = true
+	return &stdioWriter{w: s.out}, nil
+}
+
+// Open は標準入力を読み込むリーダーを返す
+func (s *StdioStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened {
+		return nil, errors.New("stdin can only be read once")
+	}
+	s.opened = true
+	return io.NopCloser(s.in), nil
+}
+
+// List は一覧を取得できないため、常に空の一覧を返す
+func (s *StdioStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+// Delete は標準入出力では削除できないため、エラーを返す
+func (s *StdioStorage) Delete(ctx context.Context, key string) error {
+	return errors.New("cannot delete from stdin or stdout")
+}
+
+// WriteFile はデータを標準出力に書き込む
+func (s *StdioStorage) WriteFile(ctx context.Context, key string, data []byte) error {
+	w, err := s.Create(ctx, key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return w.Close()
+}
+
+// ReadFile は標準入力を最後まで読み込む
+func (s *StdioStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from stdin: %w", err)
+	}
+	return data, nil
+}
+
+// stdioWriter は標準出力への書き込みを表す
+type stdioWriter struct {
+	w io.Writer
+}
+
+func (w *stdioWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Close は何もしない（標準出力は呼び出し元が管理する）
+func (w *stdioWriter) Close() error {
+	return nil
+}
+
+// Abort は何もしない（出力済みのデータは取り消せない）
+func (w *stdioWriter) Abort() error {
+	return nil
+}
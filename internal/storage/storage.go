@@ -2,61 +2,95 @@ package storage
 
 import (
 	"context"
-
-	"github.com/takaishi/acb/internal/encryption"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 )
 
-// BackupFile はバックアップファイルを表す
-type BackupFile struct {
-	Name    string
-	Content []byte
-}
+// Writer はストレージへのストリーミング書き込みを表す
+// Closeした時点で書き込みが確定し、それまでは保存先に途中のデータは現れない
+type Writer interface {
+	io.WriteCloser
 
-// BackupData はバックアップデータを保持する
-type BackupData struct {
-	Files map[string][]BackupFile // userPoolID -> files
-}
-
-// NewBackupData は新しいBackupDataを作成する
-func NewBackupData() *BackupData {
-	return &BackupData{
-		Files: make(map[string][]BackupFile),
-	}
+	// 書き込みを中止し、書き込み途中のデータを破棄する
+	Abort() error
 }
 
 // Storage はバックアップの保存先を表すインターフェース
+// 存在しないキーを読み込んだ場合や削除した場合は、fs.ErrNotExistをラップしたエラーを返す
 type Storage interface {
-	SaveJSON(ctx context.Context, prefix, userPoolID string, filename string, data interface{}) error
+	// keyに書き込むライターを返す
+	Create(ctx context.Context, key string) (Writer, error)
+
+	// keyの内容を読み込むリーダーを返す
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// prefix以下のキーの一覧を返す
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// keyを削除する
+	Delete(ctx context.Context, key string) error
+
 	WriteFile(ctx context.Context, key string, data []byte) error
 	ReadFile(ctx context.Context, key string) ([]byte, error)
-	CreateTarGz(ctx context.Context, prefix string) error
-	GetBackupData() *BackupData
-	// 暗号化関連の新規追加
-	SetEncryptor(encryptor Encryptor)
 }
 
-// Encryptor は暗号化/復号化のインターフェース
-type Encryptor = encryption.Encryptor
+// IsNotExist はファイルが存在しないことによるエラーかを判定する
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
 
-// isEncryptedData はデータが暗号化されているかを判定する
-// 暗号化されたデータは最初の8バイトが長さ情報（IV長 + 暗号化DK長）になっている
-func isEncryptedData(data []byte) bool {
-	// 最低限のヘッダーサイズをチェック
-	if len(data) < 8 {
-		return false
+// errUploadAborted はアップロードを中止したことを表す
+var errUploadAborted = errors.New("upload aborted")
+
+// pipeWriter はパイプを通して別のゴルーチンで実行するアップロードへの書き込みを表す
+// アップロードはパイプから読み込み、終了したらその結果をdoneに送る
+type pipeWriter struct {
+	service  string
+	pw       *io.PipeWriter
+	done     chan error
+	err      error
+	finished bool
+}
+
+// newPipeWriter はアップロードが読み込むパイプとライターを作成する
+// serviceはエラーメッセージに使用する保存先の名前
+func newPipeWriter(service string) (*io.PipeReader, *pipeWriter) {
+	pr, pw := io.Pipe()
+	return pr, &pipeWriter{
+		service: service,
+		pw:      pw,
+		done:    make(chan error, 1),
 	}
+}
 
-	// 最初の4バイトをIV長として読み込み
-	ivLen := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
-	// 次の4バイトを暗号化DK長として読み込み
-	encKeyLen := uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16 | uint32(data[7])<<24
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
 
-	// 妥当な範囲内かチェック（IV: 12バイト、暗号化DK: 100-1000バイト程度）
-	if ivLen == 12 && encKeyLen >= 50 && encKeyLen <= 2000 {
-		// データ全体のサイズが期待通りかチェック
-		expectedMinSize := 8 + int(ivLen) + int(encKeyLen) + 16 // 最低限の暗号化データサイズ
-		return len(data) >= expectedMinSize
+// Close は書き込みを終了し、アップロードの完了を待つ
+func (w *pipeWriter) Close() error {
+	w.pw.Close()
+	if err := w.wait(); err != nil {
+		return fmt.Errorf("failed to upload to %s: %w", w.service, err)
 	}
+	return nil
+}
+
+// Abort はアップロードを中止する
+// アップロード済みのパートはアップローダーが破棄する
+func (w *pipeWriter) Abort() error {
+	w.pw.CloseWithError(errUploadAborted)
+	w.wait()
+	return nil
+}
 
-	return false
+// wait はアップロードの終了を待ち、その結果を返す
+func (w *pipeWriter) wait() error {
+	if !w.finished {
+		w.err = <-w.done
+		w.finished = true
+	}
+	return w.err
 }
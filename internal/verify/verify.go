@@ -0,0 +1,409 @@
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/takaishi/acb/internal/archive"
+	"github.com/takaishi/acb/internal/encryption"
+	"github.com/takaishi/acb/internal/repository"
+	"github.com/takaishi/acb/pkg/types"
+)
+
+// Expected はカタログに記録されたアーカイブの情報を表す
+type Expected struct {
+	Size   int64
+	SHA256 string
+	Users  map[string]int // ユーザープールID -> ユーザー数
+}
+
+// Report はアーカイブの検証結果を表す
+type Report struct {
+	Files    int      // 検証したファイル数
+	Pools    []string // アーカイブに含まれるユーザープールID
+	Problems []string // 検出した不整合
+}
+
+// OK は不整合が検出されなかったかを返す
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// poolFiles はアーカイブから読み込んだユーザープールのファイルを表す
+type poolFiles struct {
+	metadata  *types.BackupMetadata
+	checksums map[string]string // ファイル名 -> SHA-256
+	users     int
+}
+
+// Archive はrのアーカイブを復号化・展開しながら読み込み、整合性を検証する
+// 各ファイルのチェックサムとJSONの形式、ユーザー数をメタデータと照合する
+// expectedが指定された場合は、アーカイブのサイズとチェックサム、ユーザー数をカタログの記録とも照合する
+// アーカイブを読み込めない場合はエラーを返す
+func Archive(ctx context.Context, r io.Reader, encryptor encryption.Encryptor, expected *Expected) (*Report, error) {
+	digest := archive.NewDigestReader(r)
+	ar, err := archive.NewReader(ctx, digest, encryptor)
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	c := newChecker()
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.add(header.Name, ar); err != nil {
+			return nil, err
+		}
+	}
+
+	// アーカイブのチェックサムを計算するため、末尾まで読み込む
+	if _, err := io.Copy(io.Discard, digest); err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	return c.finish(expected, digest.Size(), digest.SHA256()), nil
+}
+
+// Snapshot はリポジトリのスナップショットの各ファイルをチャンクから読み込み、Archiveと同様に整合性を検証する
+// チャンクは読み込むときに内容がIDと一致することを確認する
+// dataはスナップショットの内容で、expectedのサイズとチェックサムはスナップショットと照合する
+func Snapshot(ctx context.Context, repo *repository.Repository, data []byte, expected *Expected) (*Report, error) {
+	snapshot, err := repository.ParseSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newChecker()
+	if err := repo.WalkSnapshot(ctx, snapshot, c.add); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	return c.finish(expected, int64(len(data)), hex.EncodeToString(sum[:])), nil
+}
+
+// checker はバックアップのファイルを1つずつ検証する
+type checker struct {
+	report *Report
+	pools  map[string]*poolFiles
+}
+
+func newChecker() *checker {
+	return &checker{
+		report: &Report{},
+		pools:  make(map[string]*poolFiles),
+	}
+}
+
+// add はnameのファイルの形式を検証し、チェックサムを記録する
+// ファイルを読み込めない場合はエラーを返す
+func (c *checker) add(fileName string, r io.Reader) error {
+	name := path.Clean(fileName)
+	userPoolID, file, _ := strings.Cut(name, "/")
+	if userPoolID == "" || file == "" || (strings.Contains(file, "/") && !types.IsUserShardFile(file)) {
+		c.report.addProblem("%s: unexpected file in backup", fileName)
+		return nil
+	}
+	pool, ok := c.pools[userPoolID]
+	if !ok {
+		pool = &poolFiles{checksums: make(map[string]string)}
+		c.pools[userPoolID] = pool
+	}
+
+	// 形式を検証しながらチェックサムを計算する
+	h := sha256.New()
+	if err := validateFile(io.TeeReader(r, h), file, userPoolID, pool); err != nil {
+		c.report.addProblem("%s: %v", name, err)
+	}
+	// 検証で読み残した部分もチェックサムに含める
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	pool.checksums[file] = hex.EncodeToString(h.Sum(nil))
+	c.report.Files++
+	return nil
+}
+
+// finish はユーザープールごとにファイルをメタデータと照合し、検証結果を返す
+// sizeとchecksumはバックアップ全体のサイズとSHA-256で、expectedが指定された場合に照合する
+func (c *checker) finish(expected *Expected, size int64, checksum string) *Report {
+	report := c.report
+	for userPoolID := range c.pools {
+		report.Pools = append(report.Pools, userPoolID)
+	}
+	sort.Strings(report.Pools)
+
+	for _, userPoolID := range report.Pools {
+		checkPool(report, userPoolID, c.pools[userPoolID])
+	}
+
+	if expected != nil {
+		checkExpected(report, expected, size, checksum, c.pools)
+	}
+	return report
+}
+
+// validateFile はファイル名に応じてファイルの形式を検証する
+func validateFile(r io.Reader, file, userPoolID string, pool *poolFiles) error {
+	if types.IsUserShardFile(file) {
+		count, err := validateUserShard(r)
+		pool.users += count
+		return err
+	}
+
+	switch file {
+	case "metadata.json":
+		var metadata types.BackupMetadata
+		if err := decodeStrict(r, &metadata); err != nil {
+			return err
+		}
+		if metadata.Version == "" {
+			return fmt.Errorf("version is missing")
+		}
+		if metadata.UserPoolID != userPoolID {
+			return fmt.Errorf("user_pool_id %q does not match the directory", metadata.UserPoolID)
+		}
+		if len(metadata.BackupFiles) == 0 {
+			return fmt.Errorf("backup_files is missing")
+		}
+		pool.metadata = &metadata
+		return nil
+	case "pool-config.json":
+		var poolConfig cognitotypes.UserPoolType
+		if err := decodeStrict(r, &poolConfig); err != nil {
+			return err
+		}
+		if poolConfig.Id != nil && *poolConfig.Id != userPoolID {
+			return fmt.Errorf("user pool ID %q does not match the directory", *poolConfig.Id)
+		}
+		return nil
+	case "groups.json":
+		var groups types.GroupsBackup
+		if err := decodeStrict(r, &groups); err != nil {
+			return err
+		}
+		for i, group := range groups.Groups {
+			if group.GroupName == nil || *group.GroupName == "" {
+				return fmt.Errorf("group %d has no name", i)
+			}
+		}
+		return nil
+	case "clients.json":
+		var clients types.ClientsBackup
+		if err := decodeStrict(r, &clients); err != nil {
+			return err
+		}
+		for i, client := range clients.Clients {
+			if client.ClientName == nil || *client.ClientName == "" {
+				return fmt.Errorf("client %d has no name", i)
+			}
+		}
+		return nil
+	case "identity-providers.json":
+		var providers types.IdentityProvidersBackup
+		if err := decodeStrict(r, &providers); err != nil {
+			return err
+		}
+		for i, provider := range providers.IdentityProviders {
+			if provider.ProviderName == nil || *provider.ProviderName == "" {
+				return fmt.Errorf("identity provider %d has no name", i)
+			}
+		}
+		return nil
+	case "users.json":
+		// シャードに分割する前の古いバックアップ
+		count, err := validateUsers(r)
+		pool.users += count
+		return err
+	case "usernames.json":
+		// 増分バックアップのすべてのユーザーの一覧
+		var index types.UserIndex
+		if err := decodeStrict(r, &index); err != nil {
+			return err
+		}
+		for i, entry := range index.Users {
+			if entry.Username == "" {
+				return fmt.Errorf("user %d has no username", i)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// validateUserShard はシャードのユーザーを1行ずつ検証し、ユーザー数を返す
+func validateUserShard(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	count := 0
+	for {
+		var user types.UserInfo
+		err := decoder.Decode(&user)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("user %d: %w", count, err)
+		}
+		if user.Username == "" {
+			return count, fmt.Errorf("user %d has no username", count)
+		}
+		count++
+	}
+}
+
+// validateUsers は古いバックアップのusers.jsonのユーザーを1件ずつ検証し、ユーザー数を返す
+// ユーザー全体をメモリに展開しないよう、"users" 配列を順にデコードする
+func validateUsers(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if token, err := decoder.Token(); err != nil {
+		return 0, fmt.Errorf("invalid JSON: %w", err)
+	} else if token != json.Delim('{') {
+		return 0, fmt.Errorf("invalid JSON: expected an object")
+	}
+
+	count := 0
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return count, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if key != "users" {
+			return count, fmt.Errorf("unknown field %v", key)
+		}
+
+		token, err := decoder.Token()
+		if err != nil {
+			return count, fmt.Errorf("invalid JSON: %w", err)
+		}
+		// ユーザーがいない場合はnullになる
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('[') {
+			return count, fmt.Errorf("users is not an array")
+		}
+
+		for decoder.More() {
+			var user types.UserInfo
+			if err := decoder.Decode(&user); err != nil {
+				return count, fmt.Errorf("user %d: %w", count, err)
+			}
+			if user.Username == "" {
+				return count, fmt.Errorf("user %d has no username", count)
+			}
+			count++
+		}
+		if _, err := decoder.Token(); err != nil {
+			return count, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return count, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return count, fmt.Errorf("invalid JSON: unexpected data after the object")
+	}
+	return count, nil
+}
+
+// decodeStrict はrのJSONを未知のフィールドを許可せずにデコードする
+func decodeStrict(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after the object")
+	}
+	return nil
+}
+
+// checkPool はユーザープールのファイルをメタデータと照合する
+func checkPool(report *Report, userPoolID string, pool *poolFiles) {
+	if pool.metadata == nil {
+		report.addProblem("%s: metadata.json is missing or invalid", userPoolID)
+		return
+	}
+
+	for _, file := range pool.metadata.BackupFiles {
+		if _, ok := pool.checksums[file]; !ok {
+			report.addProblem("%s: %s is listed in metadata but missing", userPoolID, file)
+		}
+	}
+
+	// チェックサムを記録していない古いバックアップは、形式のみを検証する
+	if pool.metadata.Checksums == nil {
+		return
+	}
+
+	files := make([]string, 0, len(pool.metadata.Checksums))
+	for file := range pool.metadata.Checksums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		checksum := pool.metadata.Checksums[file]
+		actual, ok := pool.checksums[file]
+		if !ok {
+			continue
+		}
+		if actual != checksum {
+			report.addProblem("%s/%s: checksum mismatch (expected %s, got %s)", userPoolID, file, checksum, actual)
+		}
+	}
+	if pool.users != pool.metadata.Users {
+		report.addProblem("%s: user count mismatch (metadata %d, backup %d)", userPoolID, pool.metadata.Users, pool.users)
+	}
+}
+
+// checkExpected はアーカイブをカタログの記録と照合する
+func checkExpected(report *Report, expected *Expected, size int64, checksum string, pools map[string]*poolFiles) {
+	if expected.Size != 0 && size != expected.Size {
+		report.addProblem("archive size mismatch (expected %d, got %d)", expected.Size, size)
+	}
+	if expected.SHA256 != "" && checksum != expected.SHA256 {
+		report.addProblem("archive checksum mismatch (expected %s, got %s)", expected.SHA256, checksum)
+	}
+
+	userPoolIDs := make([]string, 0, len(expected.Users))
+	for userPoolID := range expected.Users {
+		userPoolIDs = append(userPoolIDs, userPoolID)
+	}
+	sort.Strings(userPoolIDs)
+
+	for _, userPoolID := range userPoolIDs {
+		pool, ok := pools[userPoolID]
+		if !ok {
+			report.addProblem("%s: recorded in the catalog but missing from the archive", userPoolID)
+			continue
+		}
+		if users := expected.Users[userPoolID]; pool.users != users {
+			report.addProblem("%s: user count mismatch (catalog %d, backup %d)", userPoolID, users, pool.users)
+		}
+	}
+}
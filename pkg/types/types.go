@@ -1,13 +1,92 @@
 package types
 
+import (
+	"fmt"
+	"path"
+	"time"
+
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
 // BackupMetadata はバックアップのメタデータを表す
 type BackupMetadata struct {
 	Version       string   `json:"version"`
+	RunID         string   `json:"run_id,omitempty"`
 	Timestamp     string   `json:"timestamp"`
 	SourceAccount string   `json:"source_account"`
 	SourceRegion  string   `json:"source_region"`
 	UserPoolID    string   `json:"user_pool_id"`
 	BackupFiles   []string `json:"backup_files"`
+
+	// 以下は整合性の検証に使用する。古いバックアップには含まれない
+	Users     int               `json:"users,omitempty"`     // 保存したユーザー数
+	Checksums map[string]string `json:"checksums,omitempty"` // ファイル名 -> SHA-256
+
+	// 以下は増分バックアップの場合のみ記録する
+	Parent      string `json:"parent,omitempty"`        // 親のバックアップのアーカイブのキー
+	ParentRunID string `json:"parent_run_id,omitempty"` // 親のバックアップの実行ID
+	Since       string `json:"since,omitempty"`         // この日時以降に変更されたユーザーを保存した
+}
+
+// IsIncremental は増分バックアップかを返す
+func (m *BackupMetadata) IsIncremental() bool {
+	return m.Parent != ""
+}
+
+// BackupManifest はバックアップの実行ごとの記録を表す
+// 保存先の catalog/ 以下に実行IDごとに保存され、バックアップのカタログとして使用する
+type BackupManifest struct {
+	RunID       string         `json:"run_id"`
+	Timestamp   string         `json:"timestamp"`
+	ToolVersion string         `json:"tool_version"`
+	KMSKeyID    string         `json:"kms_key_id,omitempty"`
+	Compression string         `json:"compression,omitempty"` // アーカイブの圧縮方式（古い記録ではgzip）
+	Pools       []ManifestPool `json:"pools"`
+}
+
+// ManifestPool はバックアップしたユーザープールの記録を表す
+type ManifestPool struct {
+	UserPoolID string `json:"user_pool_id"`
+	Users      int    `json:"users"`
+	Archive    string `json:"archive"` // アーカイブのキー
+	Size       int64  `json:"size"`    // アーカイブのサイズ
+	SHA256     string `json:"sha256"`  // アーカイブのSHA-256
+
+	// 増分バックアップの場合は親のバックアップを記録する
+	Parent      string `json:"parent,omitempty"`        // 親のバックアップのアーカイブのキー
+	ParentRunID string `json:"parent_run_id,omitempty"` // 親のバックアップの実行ID
+}
+
+// Snapshot はリポジトリに保存したバックアップの実行ごとのスナップショットを表す
+// 各ファイルはチャンクのIDの一覧で表し、チャンクの内容を順に連結すると元のファイルになる
+type Snapshot struct {
+	Version   int            `json:"version"`
+	RunID     string         `json:"run_id"`
+	Timestamp string         `json:"timestamp"`
+	Pools     []SnapshotPool `json:"pools"`
+}
+
+// SnapshotPool はスナップショットに含まれるユーザープールを表す
+type SnapshotPool struct {
+	UserPoolID string              `json:"user_pool_id"`
+	Users      int                 `json:"users"`
+	Files      map[string][]string `json:"files"` // ファイル名 -> チャンクのIDの一覧
+}
+
+// RepositoryConfig はリポジトリの設定を表す
+// リポジトリの作成時に保存し、以降のバックアップと復元で同じ設定を使用する
+type RepositoryConfig struct {
+	Version          int    `json:"version"`
+	Compression      string `json:"compression"`                  // チャンクの圧縮方式
+	KMSKeyID         string `json:"kms_key_id,omitempty"`         // 暗号化に使用したKMSキー
+	EncryptedDataKey []byte `json:"encrypted_data_key,omitempty"` // チャンクの暗号化に使用するデータキー（KMSで暗号化済み）
+}
+
+// LatestPointer はユーザープールごとの最新のバックアップを指すポインタを表す
+type LatestPointer struct {
+	RunID     string            `json:"run_id"`    // 最後に更新したバックアップの実行ID
+	Timestamp string            `json:"timestamp"` // 最後に更新したバックアップの日時
+	Archives  map[string]string `json:"archives"`  // ユーザープールID -> アーカイブのキー
 }
 
 // PoolConfiguration はユーザープールの設定を表す
@@ -20,17 +99,132 @@ type PoolConfiguration struct {
 
 // UserInfo はユーザー情報を表す
 type UserInfo struct {
-	Username    string                   `json:"username"`
-	Attributes  []map[string]interface{} `json:"attributes"`
-	Groups      []string                 `json:"groups"`
-	MFASettings map[string]interface{}   `json:"mfa_settings"`
+	Username             string                   `json:"username"`
+	Attributes           []map[string]interface{} `json:"attributes"`
+	Groups               []string                 `json:"groups"`
+	MFASettings          map[string]interface{}   `json:"mfa_settings"`
+	Enabled              *bool                    `json:"enabled,omitempty"`
+	UserStatus           string                   `json:"user_status,omitempty"`
+	UserCreateDate       *time.Time               `json:"user_create_date,omitempty"`
+	UserLastModifiedDate *time.Time               `json:"user_last_modified_date,omitempty"`
+}
+
+// IsEnabled はユーザーが有効かどうかを返す
+// 状態を記録していない古いバックアップのユーザーは有効とみなす
+func (u *UserInfo) IsEnabled() bool {
+	return u.Enabled == nil || *u.Enabled
 }
 
-// UsersBackup はユーザー情報のバックアップを表す
+// Attribute はユーザー属性の値を返す
+func (u *UserInfo) Attribute(name string) (string, bool) {
+	for _, attr := range u.Attributes {
+		if n, _ := attr["Name"].(string); n == name {
+			value, _ := attr["Value"].(string)
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// UsersBackup は古いバックアップのusers.jsonの形式を表す
+// 現在のバックアップはユーザー情報をUsersDir以下のシャードに1行1件で保存する
 type UsersBackup struct {
 	Users []UserInfo `json:"users"`
 }
 
+// UsersDir はユーザー情報のシャードを保存するディレクトリ名
+const UsersDir = "users"
+
+// UserShardFile はn番目（1から始まる）のユーザー情報のシャードのファイル名を返す
+func UserShardFile(n int) string {
+	return path.Join(UsersDir, fmt.Sprintf("%05d.jsonl", n))
+}
+
+// IsUserShardFile はファイル名がユーザー情報のシャードかを返す
+func IsUserShardFile(file string) bool {
+	return path.Dir(file) == UsersDir && path.Ext(file) == ".jsonl"
+}
+
+// UserIndex は増分バックアップの時点のすべてのユーザーを表す
+// 増分バックアップのユーザー情報には変更されたユーザーのみが含まれるため、
+// 削除されたユーザーと、ユーザーの更新日時が変わらないグループの所属の変更はこの一覧から復元する
+type UserIndex struct {
+	Users []UserIndexEntry `json:"users"`
+}
+
+// UserIndexEntry はユーザーの一覧の1件を表す
+type UserIndexEntry struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+	Enabled  *bool    `json:"enabled,omitempty"`
+}
+
+// GroupsBackup はグループのバックアップを表す
+type GroupsBackup struct {
+	Groups []cognitotypes.GroupType `json:"groups"`
+}
+
+// ClientsBackup はアプリクライアントのバックアップを表す
+type ClientsBackup struct {
+	Clients []ClientBackup `json:"clients"`
+}
+
+// ClientBackup はアプリクライアント1件のバックアップを表す
+// クライアントシークレットは保存せず、シークレットの有無のみをHasSecretに記録する
+type ClientBackup struct {
+	cognitotypes.UserPoolClientType
+	HasSecret bool `json:"has_secret,omitempty"`
+}
+
+// NewClientsBackup はクライアントシークレットを取り除いたアプリクライアントのバックアップを作成する
+func NewClientsBackup(clients []cognitotypes.UserPoolClientType) ClientsBackup {
+	backup := ClientsBackup{Clients: make([]ClientBackup, 0, len(clients))}
+	for _, client := range clients {
+		hasSecret := client.ClientSecret != nil
+		client.ClientSecret = nil
+		backup.Clients = append(backup.Clients, ClientBackup{
+			UserPoolClientType: client,
+			HasSecret:          hasSecret,
+		})
+	}
+	return backup
+}
+
+// UserPoolClients はバックアップしたアプリクライアントを返す
+// シークレットのあるクライアントは、復元時にシークレットを生成するよう空のClientSecretを設定する
+// シークレットを保存していた古いバックアップでは、ClientSecretをそのまま返す
+func (b ClientsBackup) UserPoolClients() []cognitotypes.UserPoolClientType {
+	clients := make([]cognitotypes.UserPoolClientType, 0, len(b.Clients))
+	for _, client := range b.Clients {
+		c := client.UserPoolClientType
+		if client.HasSecret && c.ClientSecret == nil {
+			secret := ""
+			c.ClientSecret = &secret
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// IdentityProvidersBackup は外部IDプロバイダーのバックアップを表す
+type IdentityProvidersBackup struct {
+	IdentityProviders []cognitotypes.IdentityProviderType `json:"identity_providers"`
+}
+
+// SubMapping は復元前後のユーザーのsubの対応を表す
+type SubMapping struct {
+	SourceUserPoolID string `json:"source_user_pool_id"`
+	TargetUserPoolID string `json:"target_user_pool_id"`
+	Username         string `json:"username"`
+	OldSub           string `json:"old_sub"`
+	NewSub           string `json:"new_sub"`
+}
+
+// SubMappings は復元時に作成されたsubの対応表を表す
+type SubMappings struct {
+	Mappings []SubMapping `json:"mappings"`
+}
+
 // BackupOptions はバックアップ操作のオプションを表す
 type BackupOptions struct {
 	Pattern  string